@@ -0,0 +1,137 @@
+// Package clientpooltest builds pools backed by in-memory fake connections, for
+// testing code that uses thrift_clientpool without a live backend.
+package clientpooltest
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pool "github.com/wangxingge/thrift_clientpool"
+)
+
+var (
+	ErrDialFailed      = errors.New("fake dial failed.")
+	ErrKeepAliveFailed = errors.New("fake keepalive failed.")
+	ErrClosed          = errors.New("fake connection closed.")
+)
+
+// Behavior scripts the fake backend, rates are in [0, 1].
+type Behavior struct {
+	PoolSize             int
+	InitialPoolSize      int
+	DialLatency          time.Duration
+	DialFailureRate      float64
+	KeepAliveFailureRate float64
+}
+
+type FakeConn struct {
+	ID     int64
+	closed int32
+}
+
+func (c *FakeConn) Closed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// Backend is the fake server the pool dials, it counts every callback invocation.
+type Backend struct {
+	behavior   Behavior
+	down       int32
+	nextID     int64
+	dials      int64
+	closes     int64
+	keepAlives int64
+	random     *rand.Rand
+	randomSync sync.Mutex
+}
+
+// Dials returns how many times the pool dialed, failed dials included.
+func (b *Backend) Dials() int64 {
+	return atomic.LoadInt64(&b.dials)
+}
+
+// Closes returns how many times the pool closed a connection.
+func (b *Backend) Closes() int64 {
+	return atomic.LoadInt64(&b.closes)
+}
+
+// KeepAlives returns how many times the pool probed a connection.
+func (b *Backend) KeepAlives() int64 {
+	return atomic.LoadInt64(&b.keepAlives)
+}
+
+// SetDown makes every dial and keepalive fail until it is called with false.
+func (b *Backend) SetDown(down bool) {
+
+	if down {
+		atomic.StoreInt32(&b.down, 1)
+	} else {
+		atomic.StoreInt32(&b.down, 0)
+	}
+}
+
+func (b *Backend) fail(rate float64) bool {
+
+	if atomic.LoadInt32(&b.down) == 1 {
+		return true
+	}
+
+	b.randomSync.Lock()
+	defer b.randomSync.Unlock()
+	return b.random.Float64() < rate
+}
+
+func (b *Backend) dial(name, address, port string) (connection interface{}, err error) {
+
+	atomic.AddInt64(&b.dials, 1)
+	time.Sleep(b.behavior.DialLatency)
+
+	if b.fail(b.behavior.DialFailureRate) {
+		return nil, ErrDialFailed
+	}
+
+	return &FakeConn{ID: atomic.AddInt64(&b.nextID, 1)}, nil
+}
+
+func (b *Backend) close(connection interface{}) (err error) {
+
+	atomic.AddInt64(&b.closes, 1)
+	if !atomic.CompareAndSwapInt32(&connection.(*FakeConn).closed, 0, 1) {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (b *Backend) keepAlive(connection interface{}) (err error) {
+
+	atomic.AddInt64(&b.keepAlives, 1)
+	if connection.(*FakeConn).Closed() {
+		return ErrClosed
+	}
+
+	if b.fail(b.behavior.KeepAliveFailureRate) {
+		return ErrKeepAliveFailed
+	}
+	return nil
+}
+
+// NewTestPool returns a started pool dialing the returned fake backend.
+func NewTestPool(name string, behavior Behavior, opts ...pool.Option) (*pool.ThriftClientPool, *Backend, error) {
+
+	if behavior.PoolSize < 1 {
+		behavior.PoolSize = 1
+	}
+
+	backend := &Backend{behavior: behavior, random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+	p, err := pool.NewThriftClientPool(name, "127.0.0.1", "0", backend.dial, backend.close, backend.keepAlive, behavior.PoolSize, behavior.InitialPoolSize, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.Start()
+	return p, backend, nil
+}
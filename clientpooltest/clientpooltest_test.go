@@ -0,0 +1,103 @@
+package clientpooltest
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or a second passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestNewTestPoolDialsInitialConnections(t *testing.T) {
+
+	p, backend, err := NewTestPool("initial", Behavior{PoolSize: 4, InitialPoolSize: 2})
+	if err != nil {
+		t.Fatalf("NewTestPool: %v", err)
+	}
+	defer p.Release()
+
+	waitFor(t, "initial dials", func() bool { return backend.Dials() == 2 })
+	if total := p.TotalConns(); total != 2 {
+		t.Fatalf("TotalConns = %v, want 2", total)
+	}
+}
+
+func TestCountersReadWhilePoolRuns(t *testing.T) {
+
+	p, backend, err := NewTestPool("counters", Behavior{PoolSize: 2})
+	if err != nil {
+		t.Fatalf("NewTestPool: %v", err)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if connection, err := p.Get(); err == nil {
+					p.Put(connection)
+				}
+				p.TriggerKeepAlive()
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		backend.Dials()
+		backend.Closes()
+		backend.KeepAlives()
+	}
+	wg.Wait()
+
+	dials := backend.Dials()
+	if dials < 1 || dials > 2 {
+		t.Fatalf("Dials = %v, want 1 or 2", dials)
+	}
+	p.Release()
+	waitFor(t, "closes after Release", func() bool { return backend.Closes() == dials })
+}
+
+func TestSetDownFailsDials(t *testing.T) {
+
+	p, backend, err := NewTestPool("down", Behavior{PoolSize: 1})
+	if err != nil {
+		t.Fatalf("NewTestPool: %v", err)
+	}
+	defer p.Release()
+
+	backend.SetDown(true)
+	if _, err := p.Get(); err == nil {
+		t.Fatal("Get on a down backend succeeded")
+	}
+	if dials := backend.Dials(); dials < 1 {
+		t.Fatalf("Dials = %v, want at least 1", dials)
+	}
+
+}
+
+func TestSetDownFailsKeepAlives(t *testing.T) {
+
+	p, backend, err := NewTestPool("downprobe", Behavior{PoolSize: 1, InitialPoolSize: 1})
+	if err != nil {
+		t.Fatalf("NewTestPool: %v", err)
+	}
+	defer p.Release()
+
+	waitFor(t, "initial dial", func() bool { return p.TotalConns() == 1 })
+	backend.SetDown(true)
+	p.TriggerKeepAlive()
+	waitFor(t, "failed keepalive closes the connection", func() bool {
+		return backend.KeepAlives() >= 1 && backend.Closes() == 1
+	})
+}
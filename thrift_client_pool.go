@@ -1,242 +1,1501 @@
 package thrift_clientpool
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"runtime/pprof"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 var (
-	DefaultKeepAliveInterval time.Duration = time.Second * 3
-	DefaultCreateNewInterval time.Duration = time.Second * 1
-	DefaultDialRetryCount                  = 3
-	DefaultRetryInterval     time.Duration = time.Second * 10
+	DefaultKeepAliveInterval    time.Duration = time.Second * 3
+	DefaultCreateNewInterval    time.Duration = time.Second * 1
+	DefaultDialRetryCount                     = 3
+	DefaultRetryInterval        time.Duration = time.Second * 10
+	DefaultPreheatLeadTime      time.Duration = time.Minute * 1
+	DefaultKeepAliveConcurrency               = 8
+	DefaultCloseConcurrency                   = 8
+	DefaultReadyTimeout         time.Duration = time.Second * 1
+	MinInterval                 time.Duration = time.Millisecond * 10
+	MaxLoopRestartDelay         time.Duration = time.Second * 5
 )
 
+var (
+	ErrNilConnection    = errors.New("dial returned nil connection.")
+	ErrProbeTimeout     = errors.New("probe timeout.")
+	ErrDialFailed       = errors.New("dial failed.")
+	ErrNotReady         = errors.New("connection not ready.")
+	ErrCloseTimeout     = errors.New("close timeout.")
+	ErrNoIdleConnection = errors.New("no idle connection.")
+	ErrCircuitOpen      = errors.New("circuit open.")
+)
+
+type DialExhaustedPolicy int
+
+const (
+	// FailFast returns a DialFailedError once Get used up its dial retries.
+	FailFast DialExhaustedPolicy = iota
+	// WaitForRetry keeps Get waiting, until its context is done, for the retry loop to recover,
+	// also when the pool is only exhausted by connections queued for retry.
+	WaitForRetry
+)
+
+// DialFailedError matches ErrDialFailed with errors.Is and unwraps to the last dial error.
+type DialFailedError struct {
+	Cause error
+}
+
+func (e *DialFailedError) Error() string {
+	return fmt.Sprintf("dial failed: %v", e.Cause)
+}
+
+func (e *DialFailedError) Unwrap() error {
+	return e.Cause
+}
+
+func (e *DialFailedError) Is(target error) bool {
+	return target == ErrDialFailed
+}
+
+// ThriftClientPool is configured through Config, ExportConfig reads the current
+// settings and ApplyConfig, Resize and SetDial change them while it runs.
 type ThriftClientPool struct {
-	Name              string
-	Address           string
-	Port              string
-	Dial              func(name, address, port string) (connection interface{}, err error)
-	Close             func(connection interface{}) (err error)
-	KeepAlive         func(connection interface{}) (err error)
-	MaxPoolSize       int
-	DialRetryCount    int
-	KeepAliveInterval time.Duration
-	DialRetryInterval time.Duration
-	CreateNewInterval time.Duration
-	workConnCount     int32
-	alivePool         chan interface{}
-	swapPool          chan interface{}
-	retryPool         chan int
-	sync              sync.Mutex
-	isStopped         bool
-}
-
-func NewThriftClientPool(name, address, port string, dialFn func(name, address, port string) (connection interface{}, err error), closeFn func(connection interface{}) (err error), keepAliveFn func(connection interface{}) (err error), poolSize, initialPoolSize int) (*ThriftClientPool, error) {
-
-	if dialFn == nil || closeFn == nil || keepAliveFn == nil {
-		return nil, errors.New("function not specified.")
-	}
-
-	if initialPoolSize < 0 {
-		return nil, errors.New("pool size less than 0.")
-	}
-
-	if poolSize < 1 {
-		return nil, errors.New("pool size less than 1.")
-	}
-
-	if initialPoolSize > poolSize {
-		initialPoolSize = poolSize
-	}
-
-	pool := &ThriftClientPool{
-		Name:              name,
-		Address:           address,
-		Port:              port,
-		Dial:              dialFn,
-		Close:             closeFn,
-		KeepAlive:         keepAliveFn,
-		MaxPoolSize:       poolSize,
-		KeepAliveInterval: DefaultKeepAliveInterval,
-		CreateNewInterval: DefaultCreateNewInterval,
-		DialRetryCount:    DefaultDialRetryCount,
-		DialRetryInterval: DefaultRetryInterval,
-	}
-
-	pool.KeepAliveInterval = time.Second * 30
-	pool.DialRetryInterval = time.Second * 30
-	pool.retryPool = make(chan int, poolSize)
-	pool.alivePool = make(chan interface{}, poolSize)
-	pool.swapPool = make(chan interface{}, poolSize)
-
-	for i := 0; i < initialPoolSize; i++ {
-		if c, err := dialFn(pool.Name, pool.Address, pool.Port); err == nil {
-			pool.alivePool <- c
-		} else {
-			pool.retryPool <- 0
-		}
-	}
+	// settings holds the current *Config, it is replaced as a whole so the loops
+	// read it without locking, see cfg and update.
+	settings         atomic.Value
+	settingsSync     sync.Mutex
+	workConnCount    int32
+	dialsInFlight    int32
+	keepAliveRunning int32
+	passEvictions    int32
+	probing          int32
+	permitConns      int32
+	permitSlots      int32
+	softMax          int32
+	pendingGets      int32
+	concurrencyLimit int32
+	admitting        int
+	admitFreed       chan struct{}
+	admitSync        sync.Mutex
+	saturated        int32
+	saturations      []bool
+	saturationCount  int32
+	saturationSync   sync.Mutex
+	saturationFire   sync.Mutex
+	workIdle         chan struct{}
+	workSync         sync.Mutex
+	nextConnID       uint64
+	retryQueued      []time.Time
+	retrySync        sync.Mutex
+	warnedIntervals  map[string]bool
+	warnSync         sync.Mutex
+	maxGetWait       int64
+	retryAttempts    int64
+	retrySuccesses   int64
+	retryFailures    int64
+	loopRestarts     int64
+	forcedCloses     int64
+	resolved         []string
+	resolvedAt       time.Time
+	resolveNext      int
+	resolveSync      sync.Mutex
+	breakers         map[string]*endpointBreaker
+	breakerSync      sync.Mutex
+	rpcStats         map[string]RPCStats
+	rpcSync          sync.Mutex
+	dialSuccess      dialTimings
+	dialFailure      dialTimings
+	dialTimeSync     sync.Mutex
+	alivePool        IdleStore
+	aliveReady       chan struct{}
+	aliveSync        sync.Mutex
+	capacity         int
+	swapChan         atomic.Value
+	swapAlloc        sync.Once
+	retryChan        atomic.Value
+	retryAlloc       sync.Once
+	keepAliveTrigger chan struct{}
+	closing          chan struct{}
+	cooling          []coolingConn
+	coolingCount     int32
+	coolSync         sync.Mutex
+	startOnce        sync.Once
+	started          int32
+	warmOnce         sync.Once
+	lazyInitial      int
+	startupProbe     bool
+	waiters          waitQueue
+	schedules        []preheatSchedule
+	clock            func() time.Time
+	conns            map[interface{}]*Conn
+	connsSync        sync.Mutex
+	callersHeld      map[string]int
+	callersFreed     chan struct{}
+	callersSync      sync.Mutex
+	unservableSince  int64
+	failing          int32
+	healthSync       sync.Mutex
+	swapSince        time.Time
+	swapAlerted      bool
+	churnCounts      [churnSlots]int
+	churnSecond      int64
+	churnAlerted     bool
+	churnSync        sync.Mutex
+	sync             sync.Mutex
+	state            int32
+}
+
+func NewThriftClientPool(name, address, port string, dialFn func(name, address, port string) (connection interface{}, err error), closeFn func(connection interface{}) (err error), keepAliveFn func(connection interface{}) (err error), poolSize, initialPoolSize int, opts ...Option) (*ThriftClientPool, error) {
+
+	base := DefaultConfig(name, address, port)
+	base.InitialSizePolicy = ClampInitialSize
+	base.KeepAliveInterval = time.Second * 30
+	base.DialRetryInterval = time.Second * 30
+	// the pool size is always given here, a default never overrides it.
+	base.MaxPoolSize = 0
 
-	return pool, nil
+	config := base
+	config.Dial = dialFn
+	config.Close = closeFn
+	config.KeepAlive = keepAliveFn
+	config.MaxPoolSize = poolSize
+	config.InitialPoolSize = initialPoolSize
+
+	return newPool(base, config, opts)
 }
 
+// Start runs the background loops once, with LazyStart it is left to the first
+// Get to dial the initial connections and start them. A pool that is no longer
+// running is not started.
 func (p *ThriftClientPool) Start() {
-	go p.retryLoop()
-	go p.keepAliveLoop()
+
+	if !p.cfg().LazyStart && p.serving() {
+		p.startOnce.Do(p.start)
+	}
+}
+
+func (p *ThriftClientPool) start() {
+
+	atomic.StoreInt32(&p.started, 1)
+	if p.cfg().LazyStart {
+		p.warmUp(p.lazyInitial)
+	}
+
+	go p.runLoop("retry", p.retryLoop)
+	go p.runLoop("keepAlive", p.keepAliveLoop)
+
+	if runsMaintenance(p.cfg()) {
+		go p.runLoop("maintenance", p.maintenanceLoop)
+	}
+
+	if runsMetrics(p.cfg()) {
+		go p.runLoop("metrics", p.metricsLoop)
+	}
+}
+
+// runsMaintenance and runsMetrics report whether start runs the optional loops
+// for config, ApplyConfig can not switch them once the pool started.
+func runsMaintenance(config *Config) bool {
+	return config.MaintenanceInterval > 0
+}
+
+func runsMetrics(config *Config) bool {
+	return config.MetricsSink != nil && config.MetricsInterval > 0
+}
+
+// runLoop runs a background loop and restarts it after a panic until the pool
+// is stopped, so one bad round does not end the maintenance for good. The delay
+// before a restart doubles from MinInterval up to MaxLoopRestartDelay while the
+// loop keeps panicking, so a panic on every round does not spin. The loop
+// goroutine carries the pprof labels pool and loop to tell it apart in dumps.
+func (p *ThriftClientPool) runLoop(name string, loop func()) {
+
+	pprof.Do(context.Background(), pprof.Labels("pool", p.Name(), "loop", name), func(context.Context) {
+		delay := MinInterval
+		for {
+			start := time.Now()
+			if p.runLoopOnce(name, loop) || p.stopped() {
+				return
+			}
+			atomic.AddInt64(&p.loopRestarts, 1)
+
+			if time.Since(start) > MaxLoopRestartDelay {
+				// the loop ran fine for a while, the panic is not a repeating one.
+				delay = MinInterval
+			}
+			p.logger().Warnf("Restart %v loop of pool %v in %v.", name, p.Name(), delay)
+			select {
+			case <-time.After(delay):
+			case <-p.closing:
+				return
+			}
+			if delay *= 2; delay > MaxLoopRestartDelay {
+				delay = MaxLoopRestartDelay
+			}
+		}
+	})
+}
+
+func (p *ThriftClientPool) runLoopOnce(name string, loop func()) (ok bool) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger().Errorf("%v loop of pool %v recovered from panic: %v", name, p.Name(), r)
+			ok = false
+		}
+	}()
+
+	loop()
+	return true
 }
 
 func (p *ThriftClientPool) Get() (connection interface{}, err error) {
+	return p.GetContext(context.Background())
+}
 
-	select {
-	case <-time.After(p.CreateNewInterval):
-		p.sync.Lock()
-		defer p.sync.Unlock()
-
-		log.Println("Get new connection from new create.")
-		if int(p.workConnCount)+len(p.retryPool)+len(p.alivePool)+len(p.swapPool) < p.MaxPoolSize {
-
-			retry := 0
-			for retry < p.DialRetryCount {
-				if connection, err = p.Dial(p.Name, p.Address, p.Port); err != nil {
-					retry++
-					continue
-				} else {
-					atomic.AddInt32(&p.workConnCount, 1)
-					return
+// GetContext is Get giving up when ctx is done, a cancelled Get does not leave
+// a retry slot behind.
+func (p *ThriftClientPool) GetContext(ctx context.Context) (connection interface{}, err error) {
+
+	defer p.observeGetWait(time.Now())
+
+	if p.cfg().FairQueue {
+		return p.getQueued(ctx, 0)
+	}
+
+	return p.get(ctx, nil)
+}
+
+// GetWithPriority waits in the pool queue where higher priority callers are
+// served first, Gets bypassing the queue still compete when FairQueue is off.
+func (p *ThriftClientPool) GetWithPriority(ctx context.Context, priority int) (connection interface{}, err error) {
+
+	defer p.observeGetWait(time.Now())
+
+	return p.getQueued(ctx, priority)
+}
+
+func (p *ThriftClientPool) getQueued(ctx context.Context, priority int) (connection interface{}, err error) {
+
+	if err = p.waiters.enter(ctx, priority); err != nil {
+		return nil, err
+	}
+	left := false
+	leave := func() {
+		if !left {
+			left = true
+			p.waiters.leave()
+		}
+	}
+	defer leave()
+
+	return p.get(ctx, leave)
+}
+
+// get borrows or dials a connection, a queued Get passes leave to hand its turn
+// on once it stops waiting, so only the wait is ordered and dials overlap.
+func (p *ThriftClientPool) get(ctx context.Context, leave func()) (connection interface{}, err error) {
+
+	defer func() { p.endGet(ctx, connection, err) }()
+
+	if admitted, err := p.beginGet(ctx); err != nil {
+		return nil, err
+	} else if admitted {
+		defer p.admitDone()
+	}
+
+	atomic.AddInt32(&p.pendingGets, 1)
+	defer atomic.AddInt32(&p.pendingGets, -1)
+
+	// an idle hit needs neither the timer nor the wakeup channel of the wait.
+	if connection, ok := p.borrowIdle(nil); ok {
+		p.logger().Debugf("Get new connection from alive pool.")
+		return connection, nil
+	}
+
+	timeout := time.After(p.queueWait(ctx))
+	coalesced := false
+	primeFailures := 0
+
+	for {
+		ready := p.idleReady()
+
+		if connection, ok := p.borrowIdle(nil); ok {
+			p.logger().Debugf("Get new connection from alive pool.")
+			return connection, nil
+		}
+
+		if err = p.failFast(); err != nil {
+			return nil, err
+		}
+
+		if timeout == nil && !p.serving() {
+			return nil, ErrPoolNotRunning
+		}
+
+		select {
+		case <-ready:
+		case connection = <-p.swapPool():
+			p.logger().Debugf("Get new connection from swap pool.")
+			p.borrowConnection(connection)
+			if !p.probeBorrowed(connection) {
+				connection = nil
+				continue
+			}
+			return
+		case <-timeout:
+			if p.cfg().CoalesceDials && !coalesced && p.inFlightDialFillsPool() {
+				// wait for a return instead of dialing into an exhausted pool.
+				coalesced = true
+				timeout = time.After(p.safeInterval("CreateNewInterval", p.cfg().CreateNewInterval))
+				continue
+			}
+			dialCtx, cancel := p.dialContext(ctx)
+			connection, err = p.createConnection(dialCtx, leave)
+			cancel()
+			if p.waitForRetry(err) {
+				// the retry slot is queued, wait for the retry loop or a return.
+				p.logger().Infof("Dial failed on %v, wait for retry loop: %v", p.Name(), err)
+				timeout = nil
+				continue
+			}
+			if err == nil {
+				if err = p.primeBorrowed(connection); err != nil {
+					// the connection was closed, dial another one.
+					connection = nil
+					if primeFailures++; primeFailures < p.cfg().DialRetryCount {
+						timeout = time.After(0)
+						continue
+					}
 				}
 			}
+			return
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// GetExisting borrows an idle connection, waiting at most the queue wait of
+// Get for one to be returned, and fails with ErrNoIdleConnection instead of
+// dialing, so latency bound callers can shed load on a cache miss.
+func (p *ThriftClientPool) GetExisting() (connection interface{}, err error) {
+
+	// the queue wait bounds the admission too.
+	ctx, cancel := context.WithTimeout(context.Background(), p.queueWait(context.Background()))
+	defer cancel()
+	defer func() { p.endGet(ctx, connection, err) }()
+
+	if admitted, err := p.beginGet(ctx); err == context.DeadlineExceeded {
+		return nil, ErrNoIdleConnection
+	} else if err != nil {
+		return nil, err
+	} else if admitted {
+		defer p.admitDone()
+	}
+
+	for {
+		ready := p.idleReady()
 
-			if retry >= p.DialRetryCount {
-				p.retryPool <- 0
-				return nil, err
+		if connection, ok := p.borrowIdle(nil); ok {
+			return connection, nil
+		}
+
+		if err = p.failFast(); err != nil {
+			return nil, err
+		}
+
+		select {
+		case <-ready:
+		case connection = <-p.swapPool():
+			p.borrowConnection(connection)
+			if p.probeBorrowed(connection) {
+				return connection, nil
 			}
-		} else {
-			return nil, errors.New(fmt.Sprintf("Pool Was Exhausted, detail: working: %v, alive: %v, retry: %v.", p.workConnCount, len(p.alivePool), len(p.retryPool)))
+		case <-ctx.Done():
+			return nil, ErrNoIdleConnection
 		}
-	case connection = <-p.alivePool:
-		log.Println("Get new connection from alive pool.")
-		atomic.AddInt32(&p.workConnCount, 1)
-		return
-	case connection = <-p.swapPool:
-		log.Println("Get new connection from swap pool.")
-		atomic.AddInt32(&p.workConnCount, 1)
+	}
+}
+
+// beginGet runs what every borrow does first: it fails on a stopped pool,
+// starts a LazyStart pool, warms it and admits the caller. admitDone must
+// follow an admitted borrow.
+func (p *ThriftClientPool) beginGet(ctx context.Context) (admitted bool, err error) {
+
+	if !p.serving() {
+		return false, ErrPoolNotRunning
+	}
+
+	if p.cfg().LazyStart {
+		p.startOnce.Do(p.start)
+	}
+	p.warmOnFirstGet()
+
+	return p.admit(ctx)
+}
+
+// endGet tags a borrowed connection with the correlation id of ctx, or logs the
+// failed borrow under it.
+func (p *ThriftClientPool) endGet(ctx context.Context, connection interface{}, err error) {
+
+	if err == nil && connection != nil {
+		p.tagBorrow(ctx, connection)
+	} else if id := CorrelationID(ctx); id != "" {
+		p.logger().Warnf("Get on %v failed [correlation %v]: %v", p.Name(), id, err)
+	}
+}
+
+// failFast returns a CircuitOpenError with CircuitFailFast while every endpoint breaker is open.
+func (p *ThriftClientPool) failFast() error {
+
+	if p.cfg().CircuitFailFast {
+		if retryAt, open := p.circuitOpen(); open {
+			return &CircuitOpenError{RetryAt: retryAt}
+		}
+	}
+	return nil
+}
+
+// borrowIdle borrows the next idle connection passing probeBorrowed and, when
+// set, accept, after the cooled down connections came back. accept runs without p.sync, the connections it refused are put
+// back in hand-out order and stay counted in probing meanwhile.
+func (p *ThriftClientPool) borrowIdle(accept func(connection interface{}) bool) (connection interface{}, ok bool) {
+
+	p.restoreCooled(time.Now())
+
+	var refused []interface{}
+	for i, max := 0, p.alivePool.Len(); accept == nil || i < max; i++ {
+		atomic.AddInt32(&p.probing, 1)
+		if connection, ok = p.popAlive(); !ok {
+			atomic.AddInt32(&p.probing, -1)
+			break
+		}
+		if accept != nil && !p.callAccept(accept, connection) {
+			refused = append(refused, connection)
+			continue
+		}
+
+		p.borrowConnection(connection)
+		atomic.AddInt32(&p.probing, -1)
+		if p.probeBorrowed(connection) {
+			p.restoreRefused(refused)
+			return connection, true
+		}
+	}
+
+	p.restoreRefused(refused)
+	return nil, false
+}
+
+func (p *ThriftClientPool) restoreRefused(refused []interface{}) {
+
+	if len(refused) > 0 {
+		p.restoreIdle(refused)
+		atomic.AddInt32(&p.probing, -int32(len(refused)))
+	}
+}
+
+// waitForRetry reports whether Get should park after err instead of failing,
+// a pool exhausted by pending retry slots is an outage, not a full pool.
+func (p *ThriftClientPool) waitForRetry(err error) bool {
+
+	if err == nil || p.cfg().OnDialExhausted != WaitForRetry {
+		return false
+	}
+	if _, dialFailed := err.(*DialFailedError); dialFailed {
+		return true
+	}
+	return err != context.Canceled && err != context.DeadlineExceeded && len(p.retryPool()) > 0
+}
+
+// createConnection dials for Get, leave is called once the dial has its slot.
+func (p *ThriftClientPool) createConnection(ctx context.Context, leave func()) (connection interface{}, err error) {
+
+	p.sync.Lock()
+	defer p.unlock()
+	return p.create(ctx, leave)
+}
+
+// createReserved dials into the slot of a permit, the slot is handed over under
+// p.sync so no Get takes it in between.
+func (p *ThriftClientPool) createReserved(ctx context.Context) (connection interface{}, err error) {
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	atomic.AddInt32(&p.permitSlots, -1)
+	return p.create(ctx, nil)
+}
+
+// create must be called with p.sync held, see createConnection. A queued Get
+// hands its turn on through leave only after the first limit check, so the
+// Gets queued behind still count for the HardMax burst.
+func (p *ThriftClientPool) create(ctx context.Context, leave func()) (connection interface{}, err error) {
+
+	p.logger().Debugf("Get new connection from new create.")
+	for retry := 0; retry < p.cfg().DialRetryCount; retry++ {
+		// p.sync is released while dialing and in the backoff, check every round.
+		if p.poolFull() {
+			return nil, p.exhaustedError()
+		}
+		if leave != nil {
+			leave()
+			leave = nil
+		}
+
+		if connection, err = p.createDial(ctx); err == nil {
+			if ctx.Err() != nil {
+				// caller gave up while dialing, keep the connection for others.
+				p.pushAlive(connection)
+				atomic.AddInt32(&p.dialsInFlight, -1)
+				return nil, ctx.Err()
+			}
+			p.borrowConnection(connection)
+			atomic.AddInt32(&p.dialsInFlight, -1)
+			return
+		}
+
+		if ctx.Err() != nil {
+			// the caller aborted, not the backend failed.
+			return nil, ctx.Err()
+		}
+
+		if backoff := p.cfg().Backoff; backoff != nil && retry+1 < p.cfg().DialRetryCount {
+			p.unlock()
+			waitErr := sleepContext(ctx, p.backoffNext(backoff, retry))
+			p.sync.Lock()
+
+			if waitErr != nil {
+				return nil, waitErr
+			}
+		}
+	}
+
+	p.pushRetry()
+	return nil, &DialFailedError{Cause: err}
+}
+
+// poolFull reports whether Get may not dial another connection, see getLimit.
+// The dials in flight hold their slot in totalConnCount.
+func (p *ThriftClientPool) poolFull() bool {
+	return p.totalConnCount() >= p.getLimit()
+}
+
+type dialResult struct {
+	connection interface{}
+	err        error
+}
+
+// createDial dials for createConnection, which holds p.sync. The lock is
+// released while Dial, the DialLimiter and IsReady block, and the caller stops
+// waiting once ctx is done. A successful dial stays counted in dialsInFlight
+// until the caller placed the connection, an abandoned one pools it itself.
+func (p *ThriftClientPool) createDial(ctx context.Context) (connection interface{}, err error) {
+
+	atomic.AddInt32(&p.dialsInFlight, 1)
+	p.unlock()
+	defer p.sync.Lock()
+
+	result := make(chan dialResult, 1)
+	go func() {
+		connection, err := p.dialConnection()
+		result <- dialResult{connection: connection, err: err}
+	}()
+
+	select {
+	case dialed := <-result:
+		if dialed.err != nil {
+			atomic.AddInt32(&p.dialsInFlight, -1)
+		}
+		return dialed.connection, dialed.err
+	case <-ctx.Done():
+		go p.landDial(result)
+		return nil, ctx.Err()
+	}
+}
+
+// landDial pools the connection of a dial its caller gave up on, or closes it
+// once the pool is stopped.
+func (p *ThriftClientPool) landDial(result <-chan dialResult) {
+
+	dialed := <-result
+	if dialed.err != nil {
+		atomic.AddInt32(&p.dialsInFlight, -1)
 		return
 	}
 
-	return nil, errors.New("Get Connection Timeout")
+	var closing closeQueue
+	defer p.closeQueued(&closing)
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	if p.stopped() {
+		closing.discard = append(closing.discard, dialed.connection)
+	} else {
+		p.pushAlive(dialed.connection)
+	}
+	atomic.AddInt32(&p.dialsInFlight, -1)
+}
+
+// SetSoftMax caps the connections Get and preheat may dial below MaxPoolSize,
+// e.g. under memory pressure. Borrowed connections stay valid, 0 removes the cap.
+// With HardMax set Get may burst past it up to HardMax while Gets queue up,
+// see getLimit.
+func (p *ThriftClientPool) SetSoftMax(n int) {
+	atomic.StoreInt32(&p.softMax, int32(n))
+}
+
+func (p *ThriftClientPool) SoftMax() int {
+	return int(atomic.LoadInt32(&p.softMax))
+}
+
+// dialLimit is the total connection count new dials may reach.
+func (p *ThriftClientPool) dialLimit() int {
+
+	if softMax := p.SoftMax(); softMax > 0 && softMax < p.cfg().MaxPoolSize {
+		return softMax
+	}
+	return p.cfg().MaxPoolSize
+}
+
+// safeInterval clamps a non-positive interval to MinInterval so the loops can
+// not spin, warning once per setting.
+func (p *ThriftClientPool) safeInterval(name string, interval time.Duration) time.Duration {
+
+	if interval > 0 {
+		return interval
+	}
+
+	p.warnSync.Lock()
+	if !p.warnedIntervals[name] {
+		if p.warnedIntervals == nil {
+			p.warnedIntervals = map[string]bool{}
+		}
+		p.warnedIntervals[name] = true
+		p.logger().Warnf("%v of pool %v is %v, use %v instead.", name, p.Name(), interval, MinInterval)
+	}
+	p.warnSync.Unlock()
+
+	return MinInterval
+}
+
+// queueWait is how long get waits for an idle connection before dialing,
+// QueueTimeout if set, else what the context deadline leaves after DialTimeout.
+func (p *ThriftClientPool) queueWait(ctx context.Context) time.Duration {
+
+	config := p.cfg()
+	if config.QueueTimeout > 0 {
+		return config.QueueTimeout
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && config.DialTimeout > 0 {
+		if wait := time.Until(deadline) - config.DialTimeout; wait > 0 {
+			return wait
+		}
+		return 0
+	}
+
+	return p.safeInterval("CreateNewInterval", config.CreateNewInterval)
+}
+
+// dialContext bounds the dialing part of get by DialTimeout.
+func (p *ThriftClientPool) dialContext(ctx context.Context) (context.Context, context.CancelFunc) {
+
+	if timeout := p.cfg().DialTimeout; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+func (p *ThriftClientPool) exhaustedError() error {
+	return errors.New(fmt.Sprintf("Pool Was Exhausted, detail: working: %v, alive: %v, retry: %v.", atomic.LoadInt32(&p.workConnCount), p.alivePool.Len(), len(p.retryPool())))
+}
+
+func (p *ThriftClientPool) inFlightDialFillsPool() bool {
+
+	return atomic.LoadInt32(&p.dialsInFlight) > 0 && p.totalConnCount() >= p.dialLimit()
 }
 
 func (p *ThriftClientPool) Put(connection interface{}) (err error) {
 
+	var closing closeQueue
 	p.sync.Lock()
+	owner, err := p.put(connection, &closing)
+	p.unlock()
+
+	p.closeQueued(&closing)
+	p.closeForeign(owner, connection)
+	return
+}
+
+// put must be called with p.sync held, the connections it decides to close are
+// queued to closing and closed by the caller once the lock is released. A
+// connection of another pool is not touched, put returns its owner and the
+// caller hands it over with closeForeign. One no pool knows, e.g. after
+// CloseConn, is ignored.
+func (p *ThriftClientPool) put(connection interface{}, closing *closeQueue) (owner *ThriftClientPool, err error) {
 
 	if connection != nil {
-		if p.isStopped {
-			p.Close(connection)
+		switch state, ok := p.markReturned(connection); {
+		case !ok:
+			if owner = ownerOf(connection); owner == nil {
+				p.logger().Warnf("Put connection unknown to pool %v, ignore it.", p.Name())
+			} else {
+				p.logger().Warnf("Put connection of pool %v to pool %v, close it.", owner.Name(), p.Name())
+			}
+			return owner, errors.New("connection does not belong to pool.")
+		case state == ConnClosed:
+			return nil, nil
+		case state == ConnIdle:
+			p.logger().Warnf("Put connection already idle in pool %v, ignore it.", p.Name())
+			return nil, errors.New("connection was not borrowed.")
+		}
+
+		p.releaseCaller(connection)
+		p.untagBorrow(connection)
+		p.clearDeadline(connection)
+
+		if p.State() == StateDraining && p.cfg().DrainPolicy == DrainKeep && p.lastCallerOf(connection) != "" {
+			// kept for GetFor of the same caller, Release closes it.
+			p.pushAlive(connection)
+		} else if !p.serving() {
+			closing.release = append(closing.release, connection)
 		} else {
-			if len(p.alivePool) < p.MaxPoolSize {
-				p.alivePool <- connection
+			if p.expired(connection) {
+				p.queueRecycle(connection, true, closing)
+			} else if p.flushed(connection) {
+				closing.discard = append(closing.discard, connection)
+			} else if p.overSoftMax() {
+				if p.logEnabled(LevelDebug) {
+					p.logger().Debugf("Pool %v over soft max, close returned connection.", p.Name())
+				}
+				closing.discard = append(closing.discard, connection)
+			} else if p.totalConnCount() <= p.cfg().MaxPoolSize {
+				p.repool(connection)
+			} else {
+				p.logger().Infof("Pool %v over size, close returned connection.", p.Name())
+				closing.discard = append(closing.discard, connection)
 			}
 		}
 	}
 
-	atomic.SwapInt32(&p.workConnCount, p.workConnCount-1)
-	p.sync.Unlock()
+	p.releaseWorkSlot()
+	return nil, nil
+}
+
+// closeForeign closes a connection returned to the wrong pool through its owner,
+// so the owner closes it with its own Close and frees the slot it holds.
+func (p *ThriftClientPool) closeForeign(owner *ThriftClientPool, connection interface{}) {
 
-	return
+	if owner == nil || owner == p {
+		return
+	}
+	if err := owner.CloseConn(connection); err != nil {
+		p.logger().Warnf("Close connection of pool %v error: %v", owner.Name(), err)
+	}
 }
 
 func (p *ThriftClientPool) Release() {
+
+	if err := p.ReleaseContext(context.Background()); err != nil {
+		p.logger().Errorf("%v", err)
+	}
+}
+
+// TotalConns returns in-use, idle, cooling, probing, dialing and retrying
+// connections and the slots permits reserve, counted the same way Get decides
+// the pool is exhausted.
+func (p *ThriftClientPool) TotalConns() int {
+
 	p.sync.Lock()
-	p.isStopped = true
+	defer p.unlock()
+	return p.totalConnCount()
+}
 
-	for connection := range p.alivePool {
-		if err := p.Close(connection); err != nil {
-			log.Println("Release connection error: ", err)
+func (p *ThriftClientPool) totalConnCount() int {
+	return int(atomic.LoadInt32(&p.workConnCount)) + len(p.retryPool()) + p.alivePool.Len() + len(p.swapPool()) + int(atomic.LoadInt32(&p.coolingCount)) + int(atomic.LoadInt32(&p.probing)) + int(atomic.LoadInt32(&p.dialsInFlight)) + int(atomic.LoadInt32(&p.permitConns)) + int(atomic.LoadInt32(&p.permitSlots))
+}
+
+func (p *ThriftClientPool) popAlive() (connection interface{}, ok bool) {
+	return p.alivePool.Pop()
+}
+
+func (p *ThriftClientPool) borrowConnection(connection interface{}) {
+
+	p.markBorrowed(connection)
+	p.acquireWorkSlot()
+	p.refreshHealth()
+
+	if config := p.cfg(); config.SetDeadline != nil && config.BorrowDeadline > 0 {
+		if err := p.callSetDeadline(config.SetDeadline, connection, time.Now().Add(config.BorrowDeadline)); err != nil {
+			p.logger().Warnf("Set borrow deadline error: %v", err)
+		}
+	}
+}
+
+// probeBorrowed runs BorrowProbe and OnFirstUse on an idle connection just
+// borrowed by Get, a failed or flushed connection is closed and Get goes on
+// with the next one.
+func (p *ThriftClientPool) probeBorrowed(connection interface{}) bool {
+
+	if p.flushed(connection) {
+		if p.logEnabled(LevelDebug) {
+			p.logger().Debugf("Connection on %v was flushed, close it.", p.Name())
+		}
+		p.dropBorrowed(connection)
+		return false
+	}
+
+	if probe := p.cfg().BorrowProbe; probe != nil {
+		if err := p.boundProbe(func(context.Context) error { return p.callBorrowProbe(probe, connection) }); err != nil {
+			p.logger().Warnf("Borrow probe failed on %v, close connection: %v", p.Name(), err)
+			p.notifyEvict(p.setLastError(connection, err))
+			p.dropBorrowed(connection)
+			return false
+		}
+	}
+
+	return p.primeBorrowed(connection) == nil
+}
+
+// primeBorrowed runs OnFirstUse the first time a connection is borrowed, a
+// connection it fails on is closed.
+func (p *ThriftClientPool) primeBorrowed(connection interface{}) (err error) {
+
+	onFirstUse := p.cfg().OnFirstUse
+	if onFirstUse == nil || !p.markPrimed(connection) {
+		return nil
+	}
+
+	if err = p.callOnFirstUse(onFirstUse, connection); err != nil {
+		p.logger().Warnf("OnFirstUse failed on %v, close connection: %v", p.Name(), err)
+		p.dropBorrowed(connection)
+	}
+	return
+}
+
+func (p *ThriftClientPool) callOnFirstUse(onFirstUse func(connection interface{}) error, connection interface{}) (err error) {
+
+	defer p.recoverCallback("OnFirstUse", &err)
+	return onFirstUse(connection)
+}
+
+// dropBorrowed closes a connection Get borrowed but will not hand out, its
+// work slot is held until Close returned.
+func (p *ThriftClientPool) dropBorrowed(connection interface{}) {
+
+	p.discardConnection(connection)
+	p.sync.Lock()
+	p.releaseWorkSlot()
+	p.unlock()
+}
+
+func (p *ThriftClientPool) callBorrowProbe(probe func(connection interface{}) error, connection interface{}) (err error) {
+
+	defer p.recoverCallback("BorrowProbe", &err)
+	return probe(connection)
+}
+
+// pushAlive stores an idle connection and wakes up the Gets waiting for one.
+func (p *ThriftClientPool) pushAlive(connection interface{}) {
+
+	p.alivePool.Push(connection)
+	p.releaseLateIdle()
+	p.wakeIdle()
+	p.refreshHealth()
+}
+
+func (p *ThriftClientPool) wakeIdle() {
+
+	p.aliveSync.Lock()
+	if p.aliveReady != nil {
+		close(p.aliveReady)
+		p.aliveReady = nil
+	}
+	p.aliveSync.Unlock()
+}
+
+// idleReady returns a channel closed by the next pushAlive or restoreIdle.
+func (p *ThriftClientPool) idleReady() <-chan struct{} {
+
+	p.aliveSync.Lock()
+	defer p.aliveSync.Unlock()
+
+	if p.aliveReady == nil {
+		p.aliveReady = make(chan struct{})
+	}
+	return p.aliveReady
+}
+
+func (p *ThriftClientPool) popSwap() (connection interface{}, ok bool) {
+
+	select {
+	case connection = <-p.swapPool():
+		return connection, true
+	default:
+		return nil, false
+	}
+}
+
+func (p *ThriftClientPool) releaseConnection(connection interface{}) (err error) {
+
+	if onRelease := p.cfg().OnRelease; onRelease != nil {
+		p.callHook("OnRelease", func() { onRelease(p.Name(), connection) })
+	}
+
+	return p.discardConnection(connection)
+}
+
+func (p *ThriftClientPool) discardConnection(connection interface{}) (err error) {
+
+	err = p.closeConnection(connection)
+	p.untrackConn(connection)
+	return
+}
+
+// closeQueue collects the connections a section holding p.sync decided to
+// close, closeQueued closes them once it is released so a slow Close or hook
+// does not hold up Get and Put. They stay tracked until closed, but no store
+// holds them any more.
+type closeQueue struct {
+	discard []interface{}
+	release []interface{}
+	evict   []eviction
+	recycle []recycling
+}
+
+// eviction is a borrowed connection returned with an error, it stays counted
+// in probing until it is evicted.
+type eviction struct {
+	connection interface{}
+	cause      error
+}
+
+// closeQueued must be called without p.sync, released connections run OnRelease
+// first, evicted and recycled ones BeforeClose, a connection BeforeClose keeps
+// is re-pooled.
+func (p *ThriftClientPool) closeQueued(closing *closeQueue) {
+
+	for _, evicted := range closing.evict {
+		if !p.evictConnection(evicted.connection, evicted.cause) {
+			p.untagBorrow(evicted.connection)
+			p.clearDeadline(evicted.connection)
+			p.markReturned(evicted.connection)
+			p.repool(evicted.connection)
+		}
+		atomic.AddInt32(&p.probing, -1)
+	}
+	for _, r := range closing.recycle {
+		if p.recycle(r) {
+			p.repool(r.connection)
+		}
+		atomic.AddInt32(&p.probing, -1)
+	}
+	for _, connection := range closing.discard {
+		if err := p.discardConnection(connection); err != nil {
+			p.logger().Warnf("Close connection on %v error: %v", p.Name(), err)
+		}
+	}
+	for _, connection := range closing.release {
+		p.releaseConnection(connection)
+	}
+	closing.discard, closing.release, closing.evict, closing.recycle = nil, nil, nil, nil
+}
+
+func (p *ThriftClientPool) dialConnection() (connection interface{}, err error) {
+
+	budget := p.cfg().CapacityBudget
+	if budget != nil {
+		if !budget.take() {
+			return nil, ErrBudgetExhausted
+		}
+		defer func() {
+			if err != nil {
+				budget.release()
+			}
+		}()
+	}
+
+	defer func() {
+		p.noteDial(err)
+	}()
+
+	address := p.dialAddress()
+	if connection, err = p.callDial(address); err != nil {
+		p.reportEndpoint(address, err)
+		return nil, err
+	}
+
+	if connection == nil {
+		p.logger().Warnf("Dial returned nil connection on %v, treat it as failure.", p.Name())
+		p.reportEndpoint(address, ErrNilConnection)
+		return nil, ErrNilConnection
+	}
+	p.reportEndpoint(address, nil)
+
+	if err = p.callOnDial(connection); err != nil {
+		p.logger().Warnf("OnDial failed, close the connection: %v", err)
+		p.closeConnection(connection)
+		return nil, err
+	}
+
+	if !p.waitReady(connection) {
+		p.logger().Warnf("Connection on %v not ready within %v, close it.", p.Name(), p.cfg().ReadyTimeout)
+		p.closeConnection(connection)
+		return nil, ErrNotReady
+	}
+
+	conn := p.trackConn(connection)
+	p.connsSync.Lock()
+	conn.endpoint = address + ":" + p.cfg().Port
+	conn.budget = budget
+	p.connsSync.Unlock()
+	p.recordChurn()
+	return
+}
+
+func (p *ThriftClientPool) callDial(address string) (connection interface{}, err error) {
+
+	if limiter := p.cfg().DialLimiter; limiter != nil {
+		limiter.acquire()
+		defer limiter.release()
+	}
+
+	start := time.Now()
+	defer func() {
+		p.observeDial(time.Since(start), err)
+	}()
+	defer p.recoverCallback("Dial", &err)
+	config := p.cfg()
+	return config.Dial(config.Name, address, config.Port)
+}
+
+// waitReady polls IsReady until it reports true or ReadyTimeout passes.
+func (p *ThriftClientPool) waitReady(connection interface{}) bool {
+
+	config := p.cfg()
+	if config.IsReady == nil {
+		return true
+	}
+
+	deadline := time.Now().Add(config.ReadyTimeout)
+	for {
+		if p.callIsReady(config.IsReady, connection) {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(MinInterval)
+	}
+}
+
+func (p *ThriftClientPool) callIsReady(isReady func(connection interface{}) bool, connection interface{}) (ready bool) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger().Errorf("IsReady recovered from panic: %v", r)
+			ready = false
 		}
+	}()
+	return isReady(connection)
+}
+
+func (p *ThriftClientPool) callOnDial(connection interface{}) (err error) {
+
+	onDial := p.cfg().OnDial
+	if onDial == nil {
+		return nil
+	}
+
+	defer p.recoverCallback("OnDial", &err)
+	return onDial(p.Name(), connection)
+}
+
+func (p *ThriftClientPool) closeConnection(connection interface{}) (err error) {
+
+	p.recordChurn()
+	closeFn := p.cfg().Close
+	p.connsSync.Lock()
+	if conn, ok := p.conns[connection]; ok && conn.closeFn != nil {
+		// connections are closed by the Close they were dialed with.
+		closeFn = conn.closeFn
+	}
+	p.connsSync.Unlock()
+
+	timeout := p.cfg().CloseTimeout
+	if timeout <= 0 {
+		return p.callClose(closeFn, connection)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- p.callClose(closeFn, connection)
+	}()
 
-		atomic.SwapInt32(&p.workConnCount, p.workConnCount-1)
+	select {
+	case err = <-result:
+		return
+	case <-time.After(timeout):
+		// the hung Close is abandoned, Release and eviction go on.
+		atomic.AddInt64(&p.forcedCloses, 1)
+		p.logger().Warnf("Close on %v did not return within %v, abandon it.", p.Name(), timeout)
+		return ErrCloseTimeout
+	}
+}
+
+func (p *ThriftClientPool) callClose(closeFn func(connection interface{}) (err error), connection interface{}) (err error) {
+
+	defer p.recoverCallback("Close", &err)
+	return closeFn(connection)
+}
+
+func (p *ThriftClientPool) keepAliveConnection(connection interface{}) (err error) {
+	return p.boundProbe(func(ctx context.Context) error { return p.callKeepAlive(ctx, connection) })
+}
+
+// boundProbe runs probe under ProbeTimeout, both the keepalive and BorrowProbe.
+func (p *ThriftClientPool) boundProbe(probe func(ctx context.Context) error) (err error) {
+
+	if p.cfg().ProbeTimeout <= 0 {
+		return probe(context.Background())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg().ProbeTimeout)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- probe(ctx)
+	}()
+
+	select {
+	case err = <-result:
+		return
+	case <-ctx.Done():
+		// the hung probe is abandoned, the connection gets evicted.
+		return ErrProbeTimeout
+	}
+}
+
+func (p *ThriftClientPool) callKeepAlive(ctx context.Context, connection interface{}) (err error) {
+
+	defer p.recoverCallback("KeepAlive", &err)
+	config := p.cfg()
+	if config.KeepAliveContext != nil {
+		return config.KeepAliveContext(ctx, connection)
 	}
+	return config.KeepAlive(connection)
+}
+
+// clearDeadline drops the BorrowDeadline of a returned connection.
+func (p *ThriftClientPool) clearDeadline(connection interface{}) {
+
+	if config := p.cfg(); config.SetDeadline != nil && config.BorrowDeadline > 0 {
+		if err := p.callSetDeadline(config.SetDeadline, connection, time.Time{}); err != nil {
+			p.logger().Warnf("Clear borrow deadline error: %v", err)
+		}
+	}
+}
+
+func (p *ThriftClientPool) callSetDeadline(setDeadline func(connection interface{}, t time.Time) error, connection interface{}, t time.Time) (err error) {
+
+	defer p.recoverCallback("SetDeadline", &err)
+	return setDeadline(connection, t)
+}
 
-	p.sync.Unlock()
+// callHook runs a user hook without result, a panic is logged and dropped so it
+// can not take down the goroutine of the pool running it.
+func (p *ThriftClientPool) callHook(name string, hook func()) {
+
+	var err error
+	defer p.recoverCallback(name, &err)
+	hook()
+}
+
+// recoverCallback turns a panic in user supplied callback into an error.
+func (p *ThriftClientPool) recoverCallback(name string, err *error) {
+
+	if r := recover(); r != nil {
+		*err = errors.New(fmt.Sprintf("%v panic: %v", name, r))
+		p.logger().Errorf("Callback recovered from panic: %v", *err)
+	}
 }
 
 func (p *ThriftClientPool) retryLoop() {
 
-	log.Println("retry loop start.")
+	p.logger().Debugf("retry loop start.")
 
+	attempt := 0
 	for {
 		select {
-		case <-time.After(p.DialRetryInterval):
-			max := len(p.retryPool)
-			for i := 0; i < max; i++ {
-				if connection, err := p.Dial(p.Name, p.Address, p.Port); err == nil {
-					<-p.retryPool
-					p.alivePool <- connection
-					log.Println("Retry Pool Success.")
-				} else {
-					log.Printf("Retry Pool Failed.")
+		case <-time.After(p.retryInterval(attempt)):
+			if p.retryPass() {
+				attempt = 0
+				if backoff := p.cfg().Backoff; backoff != nil {
+					p.callHook("Backoff", backoff.Reset)
 				}
+			} else {
+				attempt++
 			}
 
-			if p.isStopped {
+			now := p.now()
+			p.preheat(now)
+			p.checkHealth(now)
+			p.checkSwap(now)
+			p.restoreCooled(now)
+			p.reapBurst()
+		}
+
+		if p.stopped() {
+			break
+		}
+	}
+
+	p.logger().Debugf("retry loop end.")
+}
+
+func (p *ThriftClientPool) retryInterval(attempt int) time.Duration {
+
+	if backoff := p.cfg().Backoff; backoff != nil {
+		return p.safeInterval("Backoff", p.backoffNext(backoff, attempt))
+	}
+	return p.safeInterval("DialRetryInterval", p.cfg().DialRetryInterval)
+}
+
+// backoffNext asks backoff for the wait before attempt, a panicking Backoff
+// waits DialRetryInterval instead.
+func (p *ThriftClientPool) backoffNext(backoff BackoffStrategy, attempt int) (wait time.Duration) {
+
+	var err error
+	defer func() {
+		if err != nil {
+			wait = p.cfg().DialRetryInterval
+		}
+	}()
+	defer p.recoverCallback("Backoff", &err)
+	return backoff.Next(attempt)
+}
+
+// retryPass dials once for every pending retry slot, it reports false when any dial failed.
+func (p *ThriftClientPool) retryPass() (ok bool) {
+
+	ok = true
+	max := len(p.retryPool())
+	for i := 0; i < max; i++ {
+		atomic.AddInt64(&p.retryAttempts, 1)
+		if connection, err := p.dialConnection(); err == nil {
+			if !p.popRetry() {
+				// slot dropped by Resize meanwhile.
+				p.discardConnection(connection)
 				break
 			}
+			p.pushAlive(connection)
+			atomic.AddInt64(&p.retrySuccesses, 1)
+			p.logger().Infof("Retry Pool Success.")
+		} else {
+			atomic.AddInt64(&p.retryFailures, 1)
+			p.logger().Warnf("Retry Pool Failed.")
+			ok = false
 		}
 	}
 
-	log.Println("retry loop end.")
+	return
 }
 
 func (p *ThriftClientPool) keepAliveLoop() {
 
-	log.Println("keepAlive loop start.")
+	p.logger().Debugf("keepAlive loop start.")
 
 	for {
+		// the next interval starts once the pass is done, so passes never pile up.
 		select {
-		case <-time.After(p.KeepAliveInterval):
-
-			if len(p.alivePool) > 0 {
-				// send keep alive message to each connection
-				for connection := range p.alivePool {
-					if err := p.KeepAlive(connection); err == nil {
-						//log.Printf("Keepalive Pool Success on %v\n", fmt.Sprintf("%v  %v:%v", p.Name, p.Address, p.Port))
-						p.swapPool <- connection
-					} else {
-						log.Printf("Keepalive Pool Failed on %v\n", fmt.Sprintf("%v  %v:%v", p.Name, p.Address, p.Port))
-						p.retryPool <- 0
-					}
+		case <-time.After(p.safeInterval("KeepAliveInterval", p.cfg().KeepAliveInterval)):
+			if config := p.cfg(); config.AdaptiveKeepAlive && int(atomic.LoadInt32(&p.workConnCount)) > config.AdaptiveKeepAliveThreshold {
+				// busy pools keep their connections alive with real traffic.
+				break
+			}
+			p.timedKeepAlivePass()
+		case <-p.keepAliveTrigger:
+			p.timedKeepAlivePass()
+		}
 
-					if len(p.alivePool) == 0 {
-						break
-					}
-				}
+		if p.stopped() {
+			for connection, ok := p.popAlive(); ok; connection, ok = p.popAlive() {
+				p.releaseConnection(connection)
 			}
+			break
+		}
+	}
+
+	p.logger().Debugf("keepAlive loop end.")
+}
 
-			if len(p.swapPool) > 0 {
-				// restore alive connection pool.
-				for connection := range p.swapPool {
-					p.alivePool <- connection
+// TriggerKeepAlive makes the keepalive loop run a pass now, calls made while a
+// pass is already pending are merged into it.
+func (p *ThriftClientPool) TriggerKeepAlive() {
 
-					if len(p.swapPool) == 0 {
-						break
-					}
-				}
-			}
+	select {
+	case p.keepAliveTrigger <- struct{}{}:
+	default:
+	}
+}
+
+func (p *ThriftClientPool) timedKeepAlivePass() {
+
+	start := time.Now()
+	p.keepAlivePass()
+	if interval := p.cfg().KeepAliveInterval; time.Since(start) > interval {
+		p.logger().Warnf("Keepalive pass on %v took %v, longer than interval %v.", p.Name(), time.Since(start), interval)
+	}
+}
+
+// keepAlivePass probes the idle connections with at most KeepAliveConcurrency
+// probes in flight, so a slow connection does not hold back the others.
+func (p *ThriftClientPool) keepAlivePass() {
+
+	if !atomic.CompareAndSwapInt32(&p.keepAliveRunning, 0, 1) {
+		if p.logEnabled(LevelDebug) {
+			p.logger().Debugf("Keepalive pass on %v still running, skip.", p.Name())
 		}
+		return
+	}
+	defer atomic.StoreInt32(&p.keepAliveRunning, 0)
+	atomic.StoreInt32(&p.passEvictions, 0)
 
-		if p.isStopped {
-			for connection := range p.alivePool {
-				p.Close(connection)
+	suspects := p.takeSuspects()
+	count := p.alivePool.Len() + len(suspects)
+	if count == 0 {
+		return
+	}
+
+	workers := p.cfg().KeepAliveConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+
+	probes := make(chan interface{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for connection := range probes {
+				// the probe leaves the connection in swap, retry or closed.
+				p.probeConnection(connection)
+				atomic.AddInt32(&p.probing, -1)
 			}
+		}()
+	}
+
+	// send keep alive message to each connection. A connection taken out is
+	// counted in probing first, so totalConnCount never misses it.
+	order := make([]interface{}, 0, count)
+	for _, connection := range suspects {
+		order = append(order, connection)
+		probes <- connection
+	}
+	for i := len(suspects); i < count; i++ {
+		atomic.AddInt32(&p.probing, 1)
+		connection, ok := p.popAlive()
+		if !ok {
+			atomic.AddInt32(&p.probing, -1)
+			break
+		}
+		order = append(order, connection)
+		probes <- connection
+	}
+	close(probes)
+	wg.Wait()
+
+	// restore alive connection pool in the order the connections were taken,
+	// probes finish in any order.
+	passed := map[interface{}]bool{}
+	for {
+		atomic.AddInt32(&p.probing, 1)
+		connection, ok := p.popSwap()
+		if !ok {
+			atomic.AddInt32(&p.probing, -1)
 			break
 		}
+
+		if state, _ := p.connState(connection); state == ConnClosed {
+			p.untrackConn(connection)
+			atomic.AddInt32(&p.probing, -1)
+		} else if p.flushed(connection) {
+			p.discardConnection(connection)
+			atomic.AddInt32(&p.probing, -1)
+		} else {
+			passed[connection] = true
+		}
+	}
+
+	restored := order[:0]
+	for _, connection := range order {
+		if passed[connection] {
+			restored = append(restored, connection)
+			delete(passed, connection)
+		}
+	}
+	for connection := range passed {
+		// put to swap by someone else than this pass, keep it all the same.
+		restored = append(restored, connection)
+	}
+	p.restoreIdle(restored)
+	atomic.AddInt32(&p.probing, -int32(len(restored)))
+}
+
+func (p *ThriftClientPool) probeConnection(connection interface{}) {
+
+	if state, _ := p.connState(connection); state == ConnClosed {
+		p.untrackConn(connection)
+		return
+	}
+
+	// without a MaintenanceInterval the keepalive pass does the maintenance.
+	if p.cfg().MaintenanceInterval <= 0 {
+		var closing closeQueue
+		if !p.maintain(connection, &closing) {
+			kept := p.recycle(closing.recycle[0])
+			atomic.AddInt32(&p.probing, -1)
+			if !kept {
+				return
+			}
+		}
 	}
 
-	log.Println("keepAlive loop end.")
+	if p.cfg().ProbeStaleOnly && p.recentlyAlive(connection, p.cfg().KeepAliveInterval) {
+		p.parkSwap(connection)
+		return
+	}
+
+	err := p.keepAliveConnection(connection)
+	if err == nil {
+		p.markProbed(connection)
+		p.parkSwap(connection)
+		return
+	}
+
+	p.logger().Warnf("Keepalive Pool Failed on %v", fmt.Sprintf("%v  %v:%v", p.Name(), p.cfg().Address, p.cfg().Port))
+	if max := p.cfg().MaxEvictionsPerPass; max > 0 && int(atomic.AddInt32(&p.passEvictions, 1)) > max {
+		// over the cap of this pass, keep it out of rotation until the next one.
+		p.setLastError(connection, err)
+		p.parkSuspect(connection)
+		return
+	}
+	if !p.evictConnection(connection, err) {
+		p.parkSwap(connection)
+		return
+	}
+	if p.cfg().ReplaceOnEvict && p.serving() {
+		p.replaceConnection()
+	}
 }
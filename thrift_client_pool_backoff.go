@@ -0,0 +1,102 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// BackoffStrategy decides how long to wait before the next dial attempt, it is
+// shared by Get and the retry loop so implementations must be safe for concurrent use.
+type BackoffStrategy interface {
+	Next(attempt int) time.Duration
+	Reset()
+}
+
+type constantBackoff struct {
+	interval time.Duration
+}
+
+func NewConstantBackoff(interval time.Duration) BackoffStrategy {
+	return &constantBackoff{interval: interval}
+}
+
+func (b *constantBackoff) Next(attempt int) time.Duration {
+	return b.interval
+}
+
+func (b *constantBackoff) Reset() {}
+
+type exponentialBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+// NewExponentialBackoff doubles the wait from base on every attempt, up to max.
+func NewExponentialBackoff(base, max time.Duration) BackoffStrategy {
+	return &exponentialBackoff{base: base, max: max}
+}
+
+func (b *exponentialBackoff) Next(attempt int) time.Duration {
+
+	wait := b.base
+	for i := 0; i < attempt && wait < b.max; i++ {
+		wait *= 2
+	}
+
+	if wait > b.max {
+		wait = b.max
+	}
+	return wait
+}
+
+func (b *exponentialBackoff) Reset() {}
+
+type decorrelatedJitterBackoff struct {
+	base   time.Duration
+	max    time.Duration
+	prev   time.Duration
+	random *rand.Rand
+	sync   sync.Mutex
+}
+
+// NewDecorrelatedJitterBackoff waits a random duration between base and three
+// times the previous wait, up to max.
+func NewDecorrelatedJitterBackoff(base, max time.Duration) BackoffStrategy {
+	return &decorrelatedJitterBackoff{base: base, max: max, prev: base, random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *decorrelatedJitterBackoff) Next(attempt int) time.Duration {
+
+	b.sync.Lock()
+	defer b.sync.Unlock()
+
+	wait := b.base
+	if span := int64(b.prev*3 - b.base); span > 0 {
+		wait += time.Duration(b.random.Int63n(span))
+	}
+
+	if wait > b.max {
+		wait = b.max
+	}
+	b.prev = wait
+	return wait
+}
+
+func (b *decorrelatedJitterBackoff) Reset() {
+
+	b.sync.Lock()
+	b.prev = b.base
+	b.sync.Unlock()
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,131 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoffDoublesUpToMax(t *testing.T) {
+
+	backoff := NewExponentialBackoff(time.Millisecond*10, time.Millisecond*50)
+	want := []time.Duration{10, 20, 40, 50, 50}
+	for attempt, wait := range want {
+		if got := backoff.Next(attempt); got != wait*time.Millisecond {
+			t.Fatalf("Next(%v) = %v, want %v", attempt, got, wait*time.Millisecond)
+		}
+	}
+}
+
+func TestDecorrelatedJitterBackoffStaysInBounds(t *testing.T) {
+
+	base, max := time.Millisecond*10, time.Millisecond*100
+	backoff := NewDecorrelatedJitterBackoff(base, max)
+	for attempt := 0; attempt < 100; attempt++ {
+		if wait := backoff.Next(attempt); wait < base || wait > max {
+			t.Fatalf("Next(%v) = %v, want within [%v, %v]", attempt, wait, base, max)
+		}
+	}
+
+	backoff.Reset()
+	if wait := backoff.Next(0); wait >= base*3 {
+		t.Fatalf("Next after Reset = %v, want below %v", wait, base*3)
+	}
+}
+
+func TestSleepContextReturnsWhenDone(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if err := sleepContext(ctx, time.Hour); err != context.Canceled {
+		t.Fatalf("sleepContext on a cancelled context returned %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("sleepContext slept %v on a cancelled context", elapsed)
+	}
+}
+
+func BenchmarkDecorrelatedJitterBackoff(b *testing.B) {
+
+	backoff := NewDecorrelatedJitterBackoff(time.Millisecond, time.Second)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for attempt := 0; pb.Next(); attempt++ {
+			backoff.Next(attempt)
+		}
+	})
+}
+
+// recordingBackoff waits wait on every attempt and counts its calls.
+type recordingBackoff struct {
+	wait     time.Duration
+	attempts []int
+	resets   int
+	sync     sync.Mutex
+}
+
+func (b *recordingBackoff) Next(attempt int) time.Duration {
+
+	b.sync.Lock()
+	b.attempts = append(b.attempts, attempt)
+	b.sync.Unlock()
+	return b.wait
+}
+
+func (b *recordingBackoff) Reset() {
+
+	b.sync.Lock()
+	b.resets++
+	b.sync.Unlock()
+}
+
+func (b *recordingBackoff) maxAttempt() (max int) {
+
+	b.sync.Lock()
+	defer b.sync.Unlock()
+	for _, attempt := range b.attempts {
+		if attempt > max {
+			max = attempt
+		}
+	}
+	return
+}
+
+func TestCustomBackoffDrivesGetAndRetryLoop(t *testing.T) {
+
+	backend := &fakeBackend{}
+	backend.setDown(true)
+	backoff := &recordingBackoff{wait: time.Millisecond * 20}
+	config := backend.config("backoff", 2, 0)
+	config.DialRetryCount = 3
+	config.Backoff = backoff
+	p := newTestPool(t, config)
+
+	dials := backend.dialCount()
+	start := time.Now()
+	if _, err := p.Get(); err == nil {
+		t.Fatalf("Get on a down backend succeeded")
+	}
+	if waited := time.Since(start); waited < backoff.wait*2 {
+		t.Fatalf("Get gave up after %v, the backoff asks for %v between its 3 dials", waited, backoff.wait*2)
+	}
+	if got := backend.dialCount() - dials; got < 3 {
+		t.Fatalf("Get dialed %v times, want 3", got)
+	}
+
+	// the failing retry loop passes its growing attempt count.
+	waitFor(t, "retry loop to back off", func() bool { return backoff.maxAttempt() >= 2 })
+	backoff.sync.Lock()
+	resets := backoff.resets
+	backoff.sync.Unlock()
+	backend.setDown(false)
+	waitFor(t, "retry loop to dial and reset the backoff", func() bool {
+		backoff.sync.Lock()
+		defer backoff.sync.Unlock()
+		return p.alivePool.Len() == 1 && backoff.resets > resets
+	})
+}
@@ -0,0 +1,123 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBeforeCloseVetoClearsDeadline(t *testing.T) {
+
+	b := &fakeBackend{}
+	var deadlines sync.Map
+	config := b.config("veto", 1, 1)
+	config.BorrowDeadline = time.Minute
+	config.SetDeadline = func(connection interface{}, at time.Time) error {
+		deadlines.Store(connection, at)
+		return nil
+	}
+	config.BeforeClose = func(connection interface{}) bool { return true }
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := p.PutErr(connection, errors.New("rpc failed.")); err != nil {
+		t.Fatalf("PutErr: %v", err)
+	}
+	if at, _ := deadlines.Load(connection); !at.(time.Time).IsZero() {
+		t.Fatalf("kept connection still carries deadline %v", at)
+	}
+	if connection.(*fakeConn).isClosed() || p.alivePool.Len() != 1 {
+		t.Fatalf("BeforeClose veto did not re-pool the connection")
+	}
+}
+
+func TestBeforeCloseVetoIgnoredWhenStopped(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("veto", 1, 1)
+	config.BeforeClose = func(connection interface{}) bool { return true }
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.transition(StateDraining, StateRunning)
+	if err := p.PutErr(connection, errors.New("rpc failed.")); err != nil {
+		t.Fatalf("PutErr: %v", err)
+	}
+	if !connection.(*fakeConn).isClosed() {
+		t.Fatalf("BeforeClose kept a connection of a draining pool")
+	}
+}
+
+func TestBeforeCloseAskedOnRecycle(t *testing.T) {
+
+	b := &fakeBackend{}
+	keep := true
+	asked := 0
+	config := b.config("veto", 1, 1)
+	config.MaxConnLifetime = time.Millisecond
+	config.BeforeClose = func(connection interface{}) bool {
+		asked++
+		return keep
+	}
+	p := newTestPool(t, config)
+	time.Sleep(time.Millisecond * 2)
+
+	p.maintenancePass()
+	if asked != 1 || p.alivePool.Len() != 1 {
+		t.Fatalf("maintenance retired a connection BeforeClose kept, asked %v times", asked)
+	}
+
+	keep = false
+	p.maintenancePass()
+	if asked != 2 || b.closeCount() != 1 {
+		t.Fatalf("maintenance did not retire the connection, asked %v times, %v closes", asked, b.closeCount())
+	}
+}
+
+func TestBeforeCloseMayCallBackIntoPool(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("reentry", 2, 2)
+	config.MaxConnLifetime = time.Millisecond
+	var p *ThriftClientPool
+	totals := make(chan int, 4)
+	config.BeforeClose = func(connection interface{}) bool {
+		totals <- p.TotalConns()
+		return false
+	}
+	p = newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	time.Sleep(time.Millisecond * 2)
+
+	done := make(chan struct{})
+	go func() {
+		p.Put(connection)
+		p.maintenancePass()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("BeforeClose calling TotalConns deadlocked the pool")
+	}
+
+	for i := 0; i < 2; i++ {
+		if total := <-totals; total != 2 {
+			t.Fatalf("TotalConns from BeforeClose = %v, want 2", total)
+		}
+	}
+	if b.closeCount() != 2 || p.TotalConns() != 2 {
+		t.Fatalf("%v connections closed and %v left, want 2 retired and 2 retry slots", b.closeCount(), p.TotalConns())
+	}
+}
@@ -0,0 +1,113 @@
+package thrift_clientpool
+
+import (
+	"fmt"
+	"time"
+)
+
+// CircuitOpenError is returned by Get with CircuitFailFast while the breaker of
+// every endpoint is open, it matches ErrCircuitOpen with errors.Is.
+type CircuitOpenError struct {
+	RetryAt time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open until %v", e.RetryAt)
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+type endpointBreaker struct {
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// endpointAllowed reports whether a resolved address may be dialed. After
+// EndpointFailureThreshold failed dials in a row the address is skipped for
+// EndpointCooldown, then a single dial is let through to probe it.
+func (p *ThriftClientPool) endpointAllowed(address string) bool {
+
+	threshold := p.cfg().EndpointFailureThreshold
+	if threshold <= 0 {
+		return true
+	}
+
+	p.breakerSync.Lock()
+	defer p.breakerSync.Unlock()
+
+	b, ok := p.breakers[address]
+	if !ok || b.failures < threshold {
+		return true
+	}
+
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+
+	b.probing = true
+	return true
+}
+
+func (p *ThriftClientPool) reportEndpoint(address string, err error) {
+
+	config := p.cfg()
+	if config.EndpointFailureThreshold <= 0 {
+		return
+	}
+
+	p.breakerSync.Lock()
+	defer p.breakerSync.Unlock()
+
+	if err == nil {
+		delete(p.breakers, address)
+		return
+	}
+
+	if p.breakers == nil {
+		p.breakers = map[string]*endpointBreaker{}
+	}
+	b, ok := p.breakers[address]
+	if !ok {
+		b = &endpointBreaker{}
+		p.breakers[address] = b
+	}
+
+	b.failures++
+	if b.failures >= config.EndpointFailureThreshold {
+		if !b.probing {
+			p.logger().Warnf("Endpoint %v of pool %v failed %v dials, open its breaker for %v.", address, p.Name(), b.failures, config.EndpointCooldown)
+		}
+		b.openUntil = time.Now().Add(config.EndpointCooldown)
+		b.probing = false
+	}
+}
+
+// circuitOpen reports whether the breaker of every endpoint is open, and when
+// the first of them lets a probe dial through again.
+func (p *ThriftClientPool) circuitOpen() (retryAt time.Time, open bool) {
+
+	threshold := p.cfg().EndpointFailureThreshold
+	if threshold <= 0 {
+		return time.Time{}, false
+	}
+
+	endpoints := p.endpoints()
+	now := time.Now()
+
+	p.breakerSync.Lock()
+	defer p.breakerSync.Unlock()
+
+	for i, address := range endpoints {
+		b, ok := p.breakers[address]
+		if !ok || b.failures < threshold || (!b.probing && !now.Before(b.openUntil)) {
+			return time.Time{}, false
+		}
+		if i == 0 || b.openUntil.Before(retryAt) {
+			retryAt = b.openUntil
+		}
+	}
+	return retryAt, len(endpoints) > 0
+}
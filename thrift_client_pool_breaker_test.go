@@ -0,0 +1,63 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEndpointBreakerOpensAndProbes(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("breaker", 2, 0)
+	config.EndpointFailureThreshold = 2
+	config.EndpointCooldown = time.Millisecond * 20
+	p := newTestPool(t, config)
+
+	address := "10.0.0.1"
+	p.reportEndpoint(address, errFakeDown)
+	if !p.endpointAllowed(address) {
+		t.Fatal("endpoint blocked under the failure threshold")
+	}
+	p.reportEndpoint(address, errFakeDown)
+	if p.endpointAllowed(address) {
+		t.Fatal("endpoint allowed with an open breaker")
+	}
+
+	time.Sleep(config.EndpointCooldown)
+	if !p.endpointAllowed(address) {
+		t.Fatal("probe dial blocked after the cooldown")
+	}
+	if p.endpointAllowed(address) {
+		t.Fatal("second dial allowed while the probe is in flight")
+	}
+
+	p.reportEndpoint(address, nil)
+	if !p.endpointAllowed(address) {
+		t.Fatal("endpoint blocked after a successful probe")
+	}
+}
+
+func TestCircuitFailFastWhenEveryEndpointIsOpen(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("circuit", 2, 0)
+	config.EndpointFailureThreshold = 1
+	config.EndpointCooldown = time.Hour
+	config.CircuitFailFast = true
+	p := newTestPool(t, config)
+
+	p.reportEndpoint(config.Address, errFakeDown)
+
+	_, err := p.Get()
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Get with every breaker open returned %v, want ErrCircuitOpen", err)
+	}
+	open := &CircuitOpenError{}
+	if !errors.As(err, &open) || open.RetryAt.Before(time.Now()) {
+		t.Fatalf("Get returned %v, want a CircuitOpenError retrying in the future", err)
+	}
+	if dials := b.dialCount(); dials != 0 {
+		t.Fatalf("dials with the circuit open = %v, want 0", dials)
+	}
+}
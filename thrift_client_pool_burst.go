@@ -0,0 +1,58 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+)
+
+// getLimit is the total connection count Get may dial up to. Past SoftMax it
+// bursts up to HardMax, but only while other Gets are waiting as well, in get
+// or in the FairQueue. HardMax is a ceiling over SetSoftMax, without a soft max
+// the limit is MaxPoolSize and HardMax has no effect.
+func (p *ThriftClientPool) getLimit() int {
+
+	limit := p.dialLimit()
+	hardMax := p.cfg().HardMax
+	if hardMax > p.cfg().MaxPoolSize {
+		hardMax = p.cfg().MaxPoolSize
+	}
+
+	if hardMax > limit && p.waitingGets() > 1 {
+		return hardMax
+	}
+	return limit
+}
+
+// overSoftMax reports whether a burst past SoftMax is over, no Get waits and
+// the pool holds more than SoftMax, so idle connections should be closed.
+func (p *ThriftClientPool) overSoftMax() bool {
+
+	return p.cfg().HardMax > 0 && p.waitingGets() == 0 && p.totalConnCount() > p.dialLimit()
+}
+
+// waitingGets counts the Gets in get and those queued in front of it.
+func (p *ThriftClientPool) waitingGets() int {
+	return int(atomic.LoadInt32(&p.pendingGets)) + p.waiters.queued()
+}
+
+// reapBurst closes idle connections until the pool is back at SoftMax, the
+// retry loop runs it so a burst shrinks even when nothing is returned.
+func (p *ThriftClientPool) reapBurst() {
+
+	var closing closeQueue
+	defer p.closeQueued(&closing)
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	for p.overSoftMax() {
+		connection, ok := p.popAlive()
+		if !ok {
+			break
+		}
+		closing.discard = append(closing.discard, connection)
+	}
+
+	if reaped := len(closing.discard); reaped > 0 {
+		p.logger().Infof("Pool %v shrinks back to soft max, closing %v idle connections.", p.Name(), reaped)
+	}
+}
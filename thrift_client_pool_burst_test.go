@@ -0,0 +1,106 @@
+package thrift_clientpool
+
+import (
+	"testing"
+)
+
+func TestHardMaxBurstsWithFairQueue(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("burst", 4, 1)
+	config.HardMax = 3
+	config.FairQueue = true
+	p := newTestPool(t, config)
+	p.SetSoftMax(1)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer p.Put(held)
+
+	results := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			connection, err := p.Get()
+			if err == nil {
+				// keep it borrowed, the burst is what is measured.
+				defer p.CloseConn(connection)
+			}
+			results <- err
+		}()
+	}
+
+	succeeded := 0
+	for i := 0; i < 2; i++ {
+		if err := <-results; err == nil {
+			succeeded++
+		}
+	}
+	if succeeded == 0 || b.dialCount() < 2 {
+		t.Fatalf("queued Gets did not burst past the soft max: %v succeeded, %v dials", succeeded, b.dialCount())
+	}
+}
+
+func TestHardMaxWithoutSoftMax(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("burst", 2, 0)
+	config.HardMax = 2
+	p := newTestPool(t, config)
+
+	if got := p.getLimit(); got != 2 {
+		t.Fatalf("getLimit without a soft max is %v, want MaxPoolSize 2", got)
+	}
+}
+
+func TestSoftMaxRefusesNewDials(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("softmax", 4, 0))
+
+	first, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	second, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	p.SetSoftMax(1)
+	if _, err := p.Get(); err == nil {
+		t.Fatalf("Get dialed past SoftMax")
+	}
+	if dials := b.dialCount(); dials != 2 {
+		t.Fatalf("pool over SoftMax dialed %v times, want 2", dials)
+	}
+
+	// borrowed connections stay valid and are pooled again, only dials are refused.
+	for _, connection := range []interface{}{first, second} {
+		if err := p.Put(connection); err != nil || connection.(*fakeConn).isClosed() {
+			t.Fatalf("Put under a lowered SoftMax returned %v, closed %v", err, connection.(*fakeConn).isClosed())
+		}
+	}
+	if idle := p.alivePool.Len(); idle != 2 {
+		t.Fatalf("pool has %v idle connections after the returns, want 2", idle)
+	}
+
+	held := []interface{}{}
+	for i := 0; i < 2; i++ {
+		connection, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get of an idle connection over SoftMax: %v", err)
+		}
+		held = append(held, connection)
+	}
+	p.SetSoftMax(0)
+	dialed, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get after SoftMax was lifted: %v", err)
+	}
+	if dials := b.dialCount(); dials != 3 {
+		t.Fatalf("pool dialed %v times, want 3", dials)
+	}
+	p.PutAll(append(held, dialed))
+}
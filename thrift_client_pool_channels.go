@@ -0,0 +1,54 @@
+package thrift_clientpool
+
+// The retry and swap channels are only allocated once something is queued,
+// most pools never retry and a pool without keepalive passes needs no swap
+// buffer. Readers get nil before that, which reads as an empty channel. Both
+// are sized to p.capacity, the most connections the pool can hold, and only an
+// overfull pool could fill them, so pushRetry and parkSwap never block on them
+// and fall back when they are full.
+
+func (p *ThriftClientPool) retryPool() chan int {
+
+	if ch, ok := p.retryChan.Load().(chan int); ok {
+		return ch
+	}
+	return nil
+}
+
+// retryQueue returns the retry channel for sending, allocating it on first use.
+func (p *ThriftClientPool) retryQueue() chan int {
+
+	p.retryAlloc.Do(func() {
+		p.retryChan.Store(make(chan int, p.capacity))
+	})
+	return p.retryPool()
+}
+
+func (p *ThriftClientPool) swapPool() chan interface{} {
+
+	if ch, ok := p.swapChan.Load().(chan interface{}); ok {
+		return ch
+	}
+	return nil
+}
+
+// parkSwap hands a probed connection to the keepalive pass to restore in order,
+// when the swap channel is full it goes straight back into the idle store.
+func (p *ThriftClientPool) parkSwap(connection interface{}) {
+
+	select {
+	case p.swapQueue() <- connection:
+	default:
+		p.logger().Warnf("Swap pool of %v is full, restore connection at once.", p.Name())
+		p.restoreIdle([]interface{}{connection})
+	}
+}
+
+// swapQueue returns the swap channel for sending, allocating it on first use.
+func (p *ThriftClientPool) swapQueue() chan interface{} {
+
+	p.swapAlloc.Do(func() {
+		p.swapChan.Store(make(chan interface{}, p.capacity))
+	})
+	return p.swapPool()
+}
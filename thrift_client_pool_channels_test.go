@@ -0,0 +1,117 @@
+package thrift_clientpool
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestPushRetryDoesNotBlockWhenFull(t *testing.T) {
+
+	backend := &fakeBackend{}
+	p := newTestPool(t, backend.config("retryfull", 2, 0))
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < p.capacity+1; i++ {
+			p.pushRetry()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("pushRetry blocked on a full retry channel")
+	}
+
+	if queued := len(p.retryPool()); queued != p.capacity {
+		t.Fatalf("retry slots = %v, want %v", queued, p.capacity)
+	}
+	p.retrySync.Lock()
+	stamped := len(p.retryQueued)
+	p.retrySync.Unlock()
+	if stamped != p.capacity {
+		t.Fatalf("retry stamps = %v, want %v", stamped, p.capacity)
+	}
+}
+
+func TestParkSwapRestoresWhenFull(t *testing.T) {
+
+	backend := &fakeBackend{}
+	p := newTestPool(t, backend.config("swapfull", 1, 1))
+
+	connection, ok := p.popAlive()
+	if !ok {
+		t.Fatal("no idle connection")
+	}
+	for len(p.swapQueue()) < p.capacity {
+		p.swapQueue() <- connection
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.parkSwap(connection)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("parkSwap blocked on a full swap channel")
+	}
+
+	if alive := p.alivePool.Len(); alive != 1 {
+		t.Fatalf("idle after parkSwap = %v, want 1", alive)
+	}
+	for {
+		if _, ok := p.popSwap(); !ok {
+			break
+		}
+	}
+}
+
+func TestIdlePoolLeavesRetryAndSwapUnallocated(t *testing.T) {
+
+	backend := &fakeBackend{}
+	const size = 100000
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	p := newTestPool(t, backend.config("lazy", size, 0))
+	runtime.ReadMemStats(&after)
+	// the idle store takes 16 bytes a slot, eager retry and swap channels would add 24.
+	if grown := after.TotalAlloc - before.TotalAlloc; grown >= size*24 {
+		t.Fatalf("building a pool of %v allocated %v bytes", size, grown)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		p.retryPass()
+		p.keepAlivePass()
+		p.TotalConns()
+	})
+	if allocs != 0 {
+		t.Fatalf("idle retry and keepalive passes allocated %v times a run", allocs)
+	}
+	if p.retryPool() != nil || p.swapPool() != nil {
+		t.Fatal("idle passes allocated the retry or swap channel")
+	}
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.Put(connection)
+	p.keepAlivePass()
+	if p.swapPool() == nil || p.alivePool.Len() != 1 {
+		t.Fatalf("keepalive pass left %v idle connections, want 1", p.alivePool.Len())
+	}
+
+	backend.setDown(true)
+	p.pushRetry()
+	if p.retryPool() == nil || p.retryPass() {
+		t.Fatal("retry pass against a down backend succeeded")
+	}
+	backend.setDown(false)
+	if !p.retryPass() || len(p.retryPool()) != 0 || p.alivePool.Len() != 2 {
+		t.Fatalf("retry pass left %v retry slots and %v idle connections, want 0 and 2", len(p.retryPool()), p.alivePool.Len())
+	}
+}
@@ -0,0 +1,68 @@
+package thrift_clientpool
+
+import (
+	"time"
+)
+
+// churnSlots is the number of one second counters the churn rate is summed
+// over, a fixed ring keeps the memory flat however fast connections churn.
+const churnSlots = 60
+
+// recordChurn counts a dial or close, OnChurn fires once when the dials and
+// closes of the last minute reach ChurnThreshold and again after it calmed down.
+func (p *ThriftClientPool) recordChurn() {
+
+	config := p.cfg()
+
+	p.churnSync.Lock()
+	rate := p.countChurn(time.Now(), 1)
+
+	fire := false
+	if config.ChurnThreshold > 0 && rate >= config.ChurnThreshold {
+		fire = !p.churnAlerted
+		p.churnAlerted = true
+	} else {
+		p.churnAlerted = false
+	}
+	p.churnSync.Unlock()
+
+	if fire {
+		p.logger().Warnf("Connection churn on %v reached %v per minute.", p.Name(), rate)
+		if config.OnChurn != nil {
+			p.callHook("OnChurn", func() { config.OnChurn(p.Name(), rate) })
+		}
+	}
+}
+
+// churnRate returns the dials and closes of the last minute.
+func (p *ThriftClientPool) churnRate() int {
+
+	p.churnSync.Lock()
+	defer p.churnSync.Unlock()
+
+	return p.countChurn(time.Now(), 0)
+}
+
+// countChurn adds events to the counter of now, clearing the counters that fell
+// out of the window on the way, and returns the sum, called with churnSync held.
+func (p *ThriftClientPool) countChurn(now time.Time, events int) int {
+
+	second := now.Unix()
+	if second > p.churnSecond {
+		if second-p.churnSecond >= churnSlots {
+			p.churnCounts = [churnSlots]int{}
+		} else {
+			for s := p.churnSecond + 1; s <= second; s++ {
+				p.churnCounts[s%churnSlots] = 0
+			}
+		}
+		p.churnSecond = second
+	}
+	p.churnCounts[p.churnSecond%churnSlots] += events
+
+	rate := 0
+	for _, count := range p.churnCounts {
+		rate += count
+	}
+	return rate
+}
@@ -0,0 +1,47 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOnChurnFiresOncePerAlert(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("churn", 2, 0)
+	fired := int32(0)
+	config.ChurnThreshold = 3
+	config.OnChurn = func(tag string, perMinute int) { atomic.AddInt32(&fired, 1) }
+	p := newTestPool(t, config)
+
+	for i := 0; i < 5; i++ {
+		p.recordChurn()
+	}
+	if n := atomic.LoadInt32(&fired); n != 1 {
+		t.Fatalf("OnChurn fired %v times, want 1", n)
+	}
+	if rate := p.churnRate(); rate != 5 {
+		t.Fatalf("churnRate = %v, want 5", rate)
+	}
+}
+
+func TestChurnCountersDropEventsOutsideTheWindow(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("churn", 1, 0))
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		p.countChurn(start, 1)
+	}
+	if rate := p.countChurn(start.Add(30*time.Second), 1); rate != 4 {
+		t.Fatalf("rate within the window = %v, want 4", rate)
+	}
+	if rate := p.countChurn(start.Add(churnSlots*time.Second), 0); rate != 1 {
+		t.Fatalf("rate after the first second left the window = %v, want 1", rate)
+	}
+	if rate := p.countChurn(start.Add(10*time.Minute), 0); rate != 0 {
+		t.Fatalf("rate after an idle window = %v, want 0", rate)
+	}
+}
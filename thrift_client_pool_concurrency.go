@@ -0,0 +1,69 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// SetConcurrencyLimit caps the connections borrowed at the same time below
+// MaxPoolSize, e.g. for load tests. Gets over it wait for a return or their
+// context, 0 removes the cap.
+func (p *ThriftClientPool) SetConcurrencyLimit(n int) {
+
+	atomic.StoreInt32(&p.concurrencyLimit, int32(n))
+	// a raised limit lets the waiting Gets in.
+	p.wakeAdmit()
+}
+
+func (p *ThriftClientPool) ConcurrencyLimit() int {
+	return int(atomic.LoadInt32(&p.concurrencyLimit))
+}
+
+// admit waits until a Get fits the concurrency limit, admitted Gets count
+// against it until admitDone, afterwards their borrowed connection does.
+func (p *ThriftClientPool) admit(ctx context.Context) (admitted bool, err error) {
+
+	for {
+		limit := p.ConcurrencyLimit()
+		if limit <= 0 {
+			return false, nil
+		}
+
+		p.admitSync.Lock()
+		if int(atomic.LoadInt32(&p.workConnCount))+p.admitting < limit {
+			p.admitting++
+			p.admitSync.Unlock()
+			return true, nil
+		}
+		if p.admitFreed == nil {
+			p.admitFreed = make(chan struct{})
+		}
+		freed := p.admitFreed
+		p.admitSync.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+}
+
+func (p *ThriftClientPool) admitDone() {
+
+	p.admitSync.Lock()
+	p.admitting--
+	p.admitSync.Unlock()
+	p.wakeAdmit()
+}
+
+func (p *ThriftClientPool) wakeAdmit() {
+
+	p.admitSync.Lock()
+	defer p.admitSync.Unlock()
+
+	if p.admitFreed != nil {
+		close(p.admitFreed)
+		p.admitFreed = nil
+	}
+}
@@ -0,0 +1,44 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimitHoldsGetsBack(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("concurrency", 2, 2))
+	p.SetConcurrencyLimit(1)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Get over the concurrency limit returned %v, want DeadlineExceeded", err)
+	}
+
+	got := make(chan error, 1)
+	go func() {
+		connection, err := p.Get()
+		if err == nil {
+			p.Put(connection)
+		}
+		got <- err
+	}()
+	p.SetConcurrencyLimit(2)
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("Get after raising the limit: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("raising the limit did not let the waiting Get in")
+	}
+	p.Put(held)
+}
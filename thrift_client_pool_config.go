@@ -0,0 +1,425 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+type InitialSizePolicy int
+
+const (
+	// RejectInitialSize fails construction when InitialPoolSize is over MaxPoolSize.
+	RejectInitialSize InitialSizePolicy = iota
+	// ClampInitialSize dials MaxPoolSize connections instead. NewThriftClientPool
+	// defaults to it, strict callers set RejectInitialSize with WithConfig.
+	ClampInitialSize
+)
+
+// Config gathers the tunables of a ThriftClientPool, see NewFromConfig.
+type Config struct {
+	Name                       string
+	Address                    string
+	Port                       string
+	Dial                       func(name, address, port string) (connection interface{}, err error)
+	Close                      func(connection interface{}) (err error)
+	KeepAlive                  func(connection interface{}) (err error)
+	KeepAliveContext           func(ctx context.Context, connection interface{}) (err error)
+	BorrowProbe                func(connection interface{}) (err error)
+	OnFirstUse                 func(connection interface{}) (err error)
+	OnDial                     func(tag string, connection interface{}) (err error)
+	IsReady                    func(connection interface{}) bool
+	OnRelease                  func(tag string, connection interface{})
+	OnEvict                    func(tag string, conn *Conn)
+	BeforeClose                func(connection interface{}) (keep bool)
+	OnConnState                func(id uint64, from, to ConnState)
+	OnSaturated                func()
+	OnDesaturated              func()
+	OnSwapStuck                func(tag string, count int, stuck time.Duration)
+	OnChurn                    func(tag string, perMinute int)
+	SetDeadline                func(connection interface{}, t time.Time) (err error)
+	Export                     func(connection interface{}) (payload []byte, err error)
+	Import                     func(payload []byte) (connection interface{}, err error)
+	Backoff                    BackoffStrategy
+	DialLimiter                *DialLimiter
+	CapacityBudget             *CapacityBudget
+	Resolver                   func(host string) (addresses []string, err error)
+	ResolveInterval            time.Duration
+	EndpointFailureThreshold   int
+	EndpointCooldown           time.Duration
+	CircuitFailFast            bool
+	MaxPoolSize                int
+	HardMax                    int
+	CallerQuota                int
+	SoftMax                    int
+	ConcurrencyLimit           int
+	MinIdle                    int
+	ChurnThreshold             int
+	InitialPoolSize            int
+	InitialSizePolicy          InitialSizePolicy
+	DialRetryCount             int
+	KeepAliveInterval          time.Duration
+	MaintenanceInterval        time.Duration
+	KeepAliveConcurrency       int
+	MaxEvictionsPerPass        int
+	AdaptiveKeepAlive          bool
+	AdaptiveKeepAliveThreshold int
+	ProbeStaleOnly             bool
+	CloseConcurrency           int
+	DialRetryInterval          time.Duration
+	CreateNewInterval          time.Duration
+	PreheatLeadTime            time.Duration
+	UnhealthyGracePeriod       time.Duration
+	MaxConnLifetime            time.Duration
+	MaxIdleTime                time.Duration
+	FairQueue                  bool
+	CoalesceDials              bool
+	ReplaceOnEvict             bool
+	LazyStart                  bool
+	WarmOnFirstGet             bool
+	ReuseConnWrappers          bool
+	BorrowDeadline             time.Duration
+	QueueTimeout               time.Duration
+	DialTimeout                time.Duration
+	OnDialExhausted            DialExhaustedPolicy
+	DrainPolicy                DrainPolicy
+	ProbeTimeout               time.Duration
+	CloseTimeout               time.Duration
+	ReadyTimeout               time.Duration
+	MetricsInterval            time.Duration
+	MetricsSink                func(stats Stats)
+	Logger                     Logger
+	IdleStore                  IdleStore
+}
+
+// DefaultConfig returns a Config filled with the package defaults.
+func DefaultConfig(name, address, port string) Config {
+	return Config{
+		Name:                 name,
+		Address:              address,
+		Port:                 port,
+		MaxPoolSize:          1,
+		DialRetryCount:       DefaultDialRetryCount,
+		KeepAliveInterval:    DefaultKeepAliveInterval,
+		KeepAliveConcurrency: DefaultKeepAliveConcurrency,
+		CloseConcurrency:     DefaultCloseConcurrency,
+		DialRetryInterval:    DefaultRetryInterval,
+		CreateNewInterval:    DefaultCreateNewInterval,
+		PreheatLeadTime:      DefaultPreheatLeadTime,
+		ReadyTimeout:         DefaultReadyTimeout,
+	}
+}
+
+func (c Config) Validate() error {
+
+	if c.Dial == nil || c.Close == nil || c.KeepAlive == nil {
+		return errors.New("function not specified.")
+	}
+
+	if c.InitialPoolSize < 0 {
+		return errors.New("pool size less than 0.")
+	}
+
+	if c.MaxPoolSize < 1 {
+		return errors.New("pool size less than 1.")
+	}
+
+	if c.HardMax < 0 || c.HardMax > c.MaxPoolSize {
+		return errors.New("hard max out of pool size.")
+	}
+
+	if c.SoftMax < 0 || c.ConcurrencyLimit < 0 {
+		return errors.New("limit less than 0.")
+	}
+
+	if c.InitialPoolSize > c.MaxPoolSize && c.InitialSizePolicy != ClampInitialSize {
+		return errors.New("initial pool size greater than pool size.")
+	}
+
+	if c.DialRetryCount < 1 {
+		return errors.New("dial retry count less than 1.")
+	}
+
+	if c.KeepAliveInterval <= 0 || c.DialRetryInterval <= 0 || c.CreateNewInterval <= 0 {
+		return errors.New("interval not positive.")
+	}
+
+	return nil
+}
+
+// NewFromConfig builds a pool from config. The defaults registered for its name
+// fill the settings config leaves at zero or at their DefaultConfig value, see
+// RegisterDefaults, opts are applied last.
+func NewFromConfig(config Config, opts ...Option) (*ThriftClientPool, error) {
+	return newPool(DefaultConfig(config.Name, config.Address, config.Port), config, opts)
+}
+
+// newPool applies the registered defaults to base, then the settings config
+// changes from base, then opts.
+func newPool(base Config, config Config, opts []Option) (*ThriftClientPool, error) {
+
+	pool := &ThriftClientPool{}
+	pool.settings.Store(&base)
+	for _, opt := range defaultsFor(config.Name) {
+		opt(pool)
+	}
+
+	settings := *pool.cfg()
+	overlay(&settings, config, base)
+	pool.alivePool = settings.IdleStore
+	settings.IdleStore = nil
+	pool.settings.Store(&settings)
+
+	for _, opt := range opts {
+		opt(pool)
+	}
+	if err := pool.cfg().Validate(); err != nil {
+		return nil, err
+	}
+
+	// Validate only lets an initial size over the pool size through with ClampInitialSize.
+	initial := pool.cfg().InitialPoolSize
+	if initial > pool.cfg().MaxPoolSize {
+		initial = pool.cfg().MaxPoolSize
+	}
+
+	// SetSoftMax and SetConcurrencyLimit own the limits from here on.
+	pool.softMax = int32(pool.cfg().SoftMax)
+	pool.concurrencyLimit = int32(pool.cfg().ConcurrencyLimit)
+	pool.capacity = pool.cfg().MaxPoolSize
+	if pool.alivePool == nil {
+		pool.alivePool = NewChannelStore(pool.cfg().MaxPoolSize)
+	}
+	pool.keepAliveTrigger = make(chan struct{}, 1)
+	pool.closing = make(chan struct{})
+
+	if pool.startupProbe {
+		if err := pool.probeStartup(); err != nil {
+			return nil, err
+		}
+	}
+
+	if pool.cfg().LazyStart {
+		pool.lazyInitial = initial
+	} else {
+		pool.warmUp(initial)
+	}
+
+	pool.refreshHealth()
+	pool.register()
+	return pool, nil
+}
+
+func (p *ThriftClientPool) warmUp(count int) {
+
+	for i := 0; i < count; i++ {
+		if c, err := p.dialConnection(); err == nil {
+			p.pushAlive(c)
+		} else {
+			p.pushRetry()
+		}
+	}
+}
+
+// ExportConfig returns the settings the pool currently runs with, including
+// the limits set by SetSoftMax and SetConcurrencyLimit.
+func (p *ThriftClientPool) ExportConfig() Config {
+
+	config := *p.cfg()
+	config.SoftMax, config.ConcurrencyLimit = p.SoftMax(), p.ConcurrencyLimit()
+	config.IdleStore = p.alivePool
+	return config
+}
+
+// Name returns the name the pool was built with.
+func (p *ThriftClientPool) Name() string {
+	return p.cfg().Name
+}
+
+// cfg returns the current settings, they must not be modified.
+func (p *ThriftClientPool) cfg() *Config {
+	return p.settings.Load().(*Config)
+}
+
+// update publishes a copy of the current settings changed by fn, readers keep
+// the settings they loaded until they load them again.
+func (p *ThriftClientPool) update(fn func(c *Config)) {
+
+	p.settingsSync.Lock()
+	defer p.settingsSync.Unlock()
+
+	next := *p.cfg()
+	fn(&next)
+	p.settings.Store(&next)
+}
+
+// ApplyConfig updates the tunables of a running pool in one step, the loops pick
+// them up on their next round. Name, endpoint, idle store and the construction
+// settings checked by fixedChange can not be changed, nor can a started pool turn the maintenance or metrics loop on or off. Hooks
+// and KeepAliveContext are replaced, Dial, Close and KeepAlive are left to UpdateCallbacks, and
+// InitialPoolSize is ignored. SoftMax and ConcurrencyLimit replace the limits
+// set by SetSoftMax and SetConcurrencyLimit. Borrowed connections are not
+// affected until they are returned, see Resize.
+func (p *ThriftClientPool) ApplyConfig(config Config) error {
+
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	if config.Name != p.Name() || config.Address != p.cfg().Address || config.Port != p.cfg().Port {
+		return errors.New("name or endpoint change requires a new pool.")
+	}
+
+	if config.IdleStore != nil && config.IdleStore != p.alivePool {
+		return errors.New("idle store change requires a new pool.")
+	}
+
+	if name := p.fixedChange(config); name != "" {
+		return errors.New(fmt.Sprintf("%v change requires a new pool.", name))
+	}
+
+	if config.MaxPoolSize > p.capacity {
+		return errors.New("pool size greater than pool capacity.")
+	}
+
+	if atomic.LoadInt32(&p.started) == 1 && (runsMaintenance(&config) != runsMaintenance(p.cfg()) || runsMetrics(&config) != runsMetrics(p.cfg())) {
+		return errors.New("maintenance or metrics loop change requires a new pool.")
+	}
+
+	p.SetSoftMax(config.SoftMax)
+	p.SetConcurrencyLimit(config.ConcurrencyLimit)
+
+	var closing closeQueue
+	defer p.closeQueued(&closing)
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	p.resize(config.MaxPoolSize, &closing)
+	p.update(func(next *Config) {
+		next.DialRetryCount = config.DialRetryCount
+		next.Backoff = config.Backoff
+		next.DialLimiter = config.DialLimiter
+		next.ResolveInterval = config.ResolveInterval
+		next.KeepAliveInterval = config.KeepAliveInterval
+		next.KeepAliveConcurrency = config.KeepAliveConcurrency
+		next.KeepAliveContext = config.KeepAliveContext
+		next.AdaptiveKeepAlive = config.AdaptiveKeepAlive
+		next.AdaptiveKeepAliveThreshold = config.AdaptiveKeepAliveThreshold
+		next.CloseConcurrency = config.CloseConcurrency
+		next.DialRetryInterval = config.DialRetryInterval
+		next.CreateNewInterval = config.CreateNewInterval
+		next.PreheatLeadTime = config.PreheatLeadTime
+		next.UnhealthyGracePeriod = config.UnhealthyGracePeriod
+		next.FairQueue = config.FairQueue
+		next.CoalesceDials = config.CoalesceDials
+		next.BorrowDeadline = config.BorrowDeadline
+		next.OnDialExhausted = config.OnDialExhausted
+		next.ProbeTimeout = config.ProbeTimeout
+		next.MetricsInterval = config.MetricsInterval
+		next.MetricsSink = config.MetricsSink
+		next.ReadyTimeout = config.ReadyTimeout
+		next.MaxConnLifetime = config.MaxConnLifetime
+		next.QueueTimeout = config.QueueTimeout
+		next.DialTimeout = config.DialTimeout
+		next.CallerQuota = config.CallerQuota
+		next.EndpointFailureThreshold = config.EndpointFailureThreshold
+		next.EndpointCooldown = config.EndpointCooldown
+		next.Logger = config.Logger
+		next.MaxIdleTime = config.MaxIdleTime
+		next.MaintenanceInterval = config.MaintenanceInterval
+		next.OnSwapStuck = config.OnSwapStuck
+		next.MinIdle = config.MinIdle
+		next.DrainPolicy = config.DrainPolicy
+		next.MaxEvictionsPerPass = config.MaxEvictionsPerPass
+		next.BorrowProbe = config.BorrowProbe
+		next.OnChurn = config.OnChurn
+		next.ChurnThreshold = config.ChurnThreshold
+		next.CloseTimeout = config.CloseTimeout
+		next.HardMax = config.HardMax
+		next.ReplaceOnEvict = config.ReplaceOnEvict
+		next.BeforeClose = config.BeforeClose
+		next.OnFirstUse = config.OnFirstUse
+		next.Export = config.Export
+		next.Import = config.Import
+		next.ProbeStaleOnly = config.ProbeStaleOnly
+		next.CircuitFailFast = config.CircuitFailFast
+		next.OnDial = config.OnDial
+		next.IsReady = config.IsReady
+		next.OnRelease = config.OnRelease
+		next.OnEvict = config.OnEvict
+		next.OnConnState = config.OnConnState
+		next.OnSaturated = config.OnSaturated
+		next.OnDesaturated = config.OnDesaturated
+		next.SetDeadline = config.SetDeadline
+		next.Resolver = config.Resolver
+	})
+
+	return nil
+}
+
+// fixedChange names the first setting config changes that only takes effect
+// while the pool is built, or returns "".
+func (p *ThriftClientPool) fixedChange(config Config) string {
+
+	current := p.cfg()
+	switch {
+	case config.CapacityBudget != current.CapacityBudget:
+		return "CapacityBudget"
+	case config.ReuseConnWrappers != current.ReuseConnWrappers:
+		return "ReuseConnWrappers"
+	case config.LazyStart != current.LazyStart:
+		return "LazyStart"
+	case config.WarmOnFirstGet != current.WarmOnFirstGet:
+		return "WarmOnFirstGet"
+	case config.InitialSizePolicy != current.InitialSizePolicy:
+		return "InitialSizePolicy"
+	}
+	return ""
+}
+
+// Clone builds a new pool with the tunables of p under another name, opts are
+// applied on top. The defaults registered for newName only fill the settings p
+// leaves at zero or at their DefaultConfig value. Connections and the idle store are not shared, the clone
+// starts empty with an idle store of the same kind, see CloningStore, and is
+// not started.
+func (p *ThriftClientPool) Clone(newName string, opts ...Option) (*ThriftClientPool, error) {
+
+	config := p.ExportConfig()
+	config.Name = newName
+	config.IdleStore = nil
+	config.InitialPoolSize = 0
+	if store, ok := p.alivePool.(CloningStore); ok {
+		config.IdleStore = store.Empty()
+	}
+
+	return NewFromConfig(config, opts...)
+}
+
+// overlay copies config to settings, apart from the fields the registered
+// defaults changed from base that config leaves at zero or at their value in base.
+func overlay(settings *Config, config Config, base Config) {
+
+	source, defaults, target := reflect.ValueOf(config), reflect.ValueOf(base), reflect.ValueOf(settings).Elem()
+	for i := 0; i < source.NumField(); i++ {
+		field, registered := source.Field(i), target.Field(i)
+		if !sameValue(registered, defaults.Field(i)) && (field.IsZero() || sameValue(field, defaults.Field(i))) {
+			continue
+		}
+		registered.Set(field)
+	}
+}
+
+func sameValue(a, b reflect.Value) bool {
+
+	if a.Kind() == reflect.Func {
+		return a.IsNil() == b.IsNil() && (a.IsNil() || a.Pointer() == b.Pointer())
+	}
+	if a.Comparable() && b.Comparable() {
+		return a.Equal(b)
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
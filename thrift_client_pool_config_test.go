@@ -0,0 +1,371 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestApplyConfigRejectsLoopSwitchAfterStart(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("loops", 2, 0))
+
+	config := p.ExportConfig()
+	config.MaintenanceInterval = time.Second
+	if err := p.ApplyConfig(config); err == nil {
+		t.Fatalf("ApplyConfig turned the maintenance loop on after start")
+	}
+	if p.cfg().MaintenanceInterval != 0 {
+		t.Fatalf("rejected ApplyConfig changed MaintenanceInterval")
+	}
+
+	config = p.ExportConfig()
+	config.MetricsInterval = time.Second
+	config.MetricsSink = func(Stats) {}
+	if err := p.ApplyConfig(config); err == nil {
+		t.Fatalf("ApplyConfig turned the metrics loop on after start")
+	}
+}
+
+func TestApplyConfigChangesLoopIntervals(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("loops", 2, 0)
+	config.MaintenanceInterval = time.Hour
+	p := newTestPool(t, config)
+
+	config = p.ExportConfig()
+	config.MaintenanceInterval = time.Minute
+	if err := p.ApplyConfig(config); err != nil {
+		t.Fatalf("ApplyConfig: %v", err)
+	}
+	if p.cfg().MaintenanceInterval != time.Minute {
+		t.Fatalf("MaintenanceInterval is %v, want 1m", p.cfg().MaintenanceInterval)
+	}
+
+	lazy := b.config("lazy", 2, 0)
+	lazy.LazyStart = true
+	p = newTestPool(t, lazy)
+	lazy = p.ExportConfig()
+	lazy.MaintenanceInterval = time.Hour
+	if err := p.ApplyConfig(lazy); err != nil {
+		t.Fatalf("ApplyConfig before the lazy start: %v", err)
+	}
+}
+
+func TestApplyConfigReplacesHooks(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("hooks", 2, 0))
+
+	config := p.ExportConfig()
+	value := reflect.ValueOf(&config).Elem()
+	hooks := []string{}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() != reflect.Func {
+			continue
+		}
+		name := value.Type().Field(i).Name
+		if connectionCallbacks[name] {
+			field.Set(reflect.Zero(field.Type()))
+			continue
+		}
+		field.Set(reflect.MakeFunc(field.Type(), func(args []reflect.Value) []reflect.Value {
+			return nil
+		}))
+		hooks = append(hooks, name)
+	}
+	config.Dial, config.Close, config.KeepAlive = b.dial, b.close, b.keepAlive
+	if err := p.ApplyConfig(config); err != nil {
+		t.Fatalf("ApplyConfig: %v", err)
+	}
+
+	applied := reflect.ValueOf(p.cfg()).Elem()
+	for _, name := range hooks {
+		if applied.FieldByName(name).IsNil() {
+			t.Errorf("ApplyConfig left hook %v untouched", name)
+		}
+	}
+}
+
+// connectionCallbacks are the Config funcs only UpdateCallbacks and SetDial change.
+var connectionCallbacks = map[string]bool{"Dial": true, "Close": true, "KeepAlive": true}
+
+func TestApplyConfigSwitchesKeepAliveContext(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("probe", 2, 1))
+
+	var probes int32
+	config := p.ExportConfig()
+	config.KeepAliveContext = func(ctx context.Context, connection interface{}) error {
+		atomic.AddInt32(&probes, 1)
+		return nil
+	}
+	if err := p.ApplyConfig(config); err != nil {
+		t.Fatalf("ApplyConfig: %v", err)
+	}
+	p.keepAlivePass()
+	if atomic.LoadInt32(&probes) != 1 || b.keepAliveCount() != 0 {
+		t.Fatalf("probe went to KeepAliveContext %v times and KeepAlive %v times, want 1 and 0", probes, b.keepAliveCount())
+	}
+
+	config = p.ExportConfig()
+	config.KeepAliveContext = nil
+	if err := p.ApplyConfig(config); err != nil {
+		t.Fatalf("ApplyConfig: %v", err)
+	}
+	p.keepAlivePass()
+	if atomic.LoadInt32(&probes) != 1 || b.keepAliveCount() != 1 {
+		t.Fatalf("probe went to KeepAliveContext %v times and KeepAlive %v times after clearing it, want 1 and 1", probes, b.keepAliveCount())
+	}
+}
+
+func TestCloneKeepsIdleStoreKind(t *testing.T) {
+
+	b := &fakeBackend{}
+	lifo := newTestPool(t, b.config("lifo", 2, 0), WithLIFO())
+	clone, err := lifo.Clone("lifo-clone")
+	if err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	defer clone.Release()
+	if _, ok := clone.alivePool.(*stackStore); !ok || clone.alivePool == lifo.alivePool {
+		t.Fatalf("clone of a LIFO pool got store %T", clone.alivePool)
+	}
+
+	fifo := newTestPool(t, b.config("fifo", 2, 0))
+	clone, err = fifo.Clone("fifo-clone", WithConfig(func(c *Config) { c.MaxPoolSize = 4 }))
+	if err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	defer clone.Release()
+	if store, ok := clone.alivePool.(*channelStore); !ok || cap(store.ch) != 4 {
+		t.Fatalf("clone of a FIFO pool got store %T, want a channel store sized to the clone", clone.alivePool)
+	}
+}
+
+// fullConfig sets every field of a LazyStart config for b, so no loop runs.
+func fullConfig(b *fakeBackend, name string) Config {
+
+	config := b.config(name, 4, 0)
+	config.Backoff = NewConstantBackoff(time.Second)
+	config.DialLimiter = NewDialLimiter(1, time.Second)
+	config.CapacityBudget = NewCapacityBudget(8)
+	value := reflect.ValueOf(&config).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if !field.IsZero() {
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Func:
+			field.Set(reflect.MakeFunc(field.Type(), func(args []reflect.Value) []reflect.Value {
+				results := []reflect.Value{}
+				for j := 0; j < field.Type().NumOut(); j++ {
+					results = append(results, reflect.Zero(field.Type().Out(j)))
+				}
+				return results
+			}))
+		case reflect.Bool:
+			field.SetBool(true)
+		case reflect.Int64:
+			field.SetInt(int64(time.Minute))
+		case reflect.Int:
+			field.SetInt(1)
+		}
+	}
+	config.InitialPoolSize = 0
+	return config
+}
+
+func TestCloneCarriesEveryField(t *testing.T) {
+
+	b := &fakeBackend{}
+	p, err := NewFromConfig(fullConfig(b, "full"))
+	if err != nil {
+		t.Fatalf("NewFromConfig: %v", err)
+	}
+	defer p.Release()
+	p.SetSoftMax(3)
+	p.SetConcurrencyLimit(2)
+
+	clone, err := p.Clone("full-clone")
+	if err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	defer clone.Release()
+
+	source, cloned := p.ExportConfig(), clone.ExportConfig()
+	if cloned.SoftMax != 3 || cloned.ConcurrencyLimit != 2 {
+		t.Fatalf("clone has SoftMax %v and ConcurrencyLimit %v, want 3 and 2", cloned.SoftMax, cloned.ConcurrencyLimit)
+	}
+	want, got := reflect.ValueOf(source), reflect.ValueOf(cloned)
+	for i := 0; i < want.NumField(); i++ {
+		name := want.Type().Field(i).Name
+		switch name {
+		case "Name", "IdleStore", "InitialPoolSize":
+			continue
+		}
+		if want.Field(i).IsZero() {
+			t.Errorf("source left %v at zero", name)
+			continue
+		}
+		equal := false
+		if want.Field(i).Kind() == reflect.Func {
+			equal = want.Field(i).Pointer() == got.Field(i).Pointer()
+		} else {
+			equal = reflect.DeepEqual(want.Field(i).Interface(), got.Field(i).Interface())
+		}
+		if !equal {
+			t.Errorf("clone changed %v", name)
+		}
+	}
+}
+
+func TestCloneDefaultsFillZeroFieldsOnly(t *testing.T) {
+
+	RegisterDefaults("clonedefaults.", WithConfig(func(c *Config) {
+		c.MinIdle = 3
+		c.ProbeTimeout = time.Second * 7
+	}))
+	t.Cleanup(func() { UnregisterDefaults("clonedefaults.") })
+
+	b := &fakeBackend{}
+	config := b.config("source", 4, 0)
+	config.MinIdle = 1
+	p := newTestPool(t, config)
+
+	clone, err := p.Clone("clonedefaults.clone")
+	if err != nil {
+		t.Fatalf("Clone: %v", err)
+	}
+	defer clone.Release()
+	if got := clone.cfg().MinIdle; got != 1 {
+		t.Errorf("defaults overrode MinIdle of the source, got %v, want 1", got)
+	}
+	if got := clone.cfg().ProbeTimeout; got != time.Second*7 {
+		t.Errorf("defaults did not fill ProbeTimeout, got %v, want 7s", got)
+	}
+}
+
+func TestApplyConfigRejectsConstructionSettings(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("fixed", 2, 0))
+
+	changes := []struct {
+		field  string
+		change func(c *Config)
+	}{
+		{"CapacityBudget", func(c *Config) { c.CapacityBudget = NewCapacityBudget(4) }},
+		{"ReuseConnWrappers", func(c *Config) { c.ReuseConnWrappers = true }},
+		{"LazyStart", func(c *Config) { c.LazyStart = true }},
+		{"WarmOnFirstGet", func(c *Config) { c.WarmOnFirstGet = true }},
+		{"InitialSizePolicy", func(c *Config) { c.InitialSizePolicy = ClampInitialSize }},
+	}
+	for _, tc := range changes {
+		config := p.ExportConfig()
+		tc.change(&config)
+		config.MinIdle = 1
+		err := p.ApplyConfig(config)
+		if err == nil || !strings.Contains(err.Error(), tc.field) {
+			t.Errorf("ApplyConfig changing %v returned %v, want an error naming it", tc.field, err)
+		}
+		if p.cfg().MinIdle != 0 {
+			t.Fatalf("rejected ApplyConfig changing %v applied MinIdle", tc.field)
+		}
+	}
+}
+
+// run with -race, the test only fails through the race detector or a hang.
+func TestConcurrentGetPutResizeRelease(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("stress", 4, 2)
+	config.KeepAliveInterval = time.Millisecond
+	config.DialRetryInterval = time.Millisecond
+	config.MaintenanceInterval = time.Millisecond
+	p := newTestPool(t, config)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+				connection, err := p.GetContext(ctx)
+				cancel()
+				if err == ErrPoolNotRunning {
+					return
+				} else if err == nil {
+					p.Put(connection)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; p.serving(); i++ {
+			p.Resize(1 + i%4)
+			p.SetSoftMax(i % 3)
+			p.SetConcurrencyLimit(i % 5)
+			_ = p.Stats()
+			_ = p.ExportConfig()
+		}
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	p.Release()
+	wg.Wait()
+	waitFor(t, "released pool to close every connection", func() bool { return b.dialCount() == b.closeCount() })
+}
+
+func TestValidateRejectsEachInvalidCombination(t *testing.T) {
+
+	b := &fakeBackend{}
+	cases := []struct {
+		name   string
+		change func(c *Config)
+		want   string
+	}{
+		{"no Dial", func(c *Config) { c.Dial = nil }, "function not specified."},
+		{"no Close", func(c *Config) { c.Close = nil }, "function not specified."},
+		{"no KeepAlive", func(c *Config) { c.KeepAlive = nil }, "function not specified."},
+		{"negative initial size", func(c *Config) { c.InitialPoolSize = -1 }, "pool size less than 0."},
+		{"zero pool size", func(c *Config) { c.MaxPoolSize = 0 }, "pool size less than 1."},
+		{"negative HardMax", func(c *Config) { c.HardMax = -1 }, "hard max out of pool size."},
+		{"HardMax over pool size", func(c *Config) { c.HardMax = 3 }, "hard max out of pool size."},
+		{"negative SoftMax", func(c *Config) { c.SoftMax = -1 }, "limit less than 0."},
+		{"negative ConcurrencyLimit", func(c *Config) { c.ConcurrencyLimit = -1 }, "limit less than 0."},
+		{"initial size over pool size", func(c *Config) { c.InitialPoolSize = 3 }, "initial pool size greater than pool size."},
+		{"zero DialRetryCount", func(c *Config) { c.DialRetryCount = 0 }, "dial retry count less than 1."},
+		{"zero KeepAliveInterval", func(c *Config) { c.KeepAliveInterval = 0 }, "interval not positive."},
+		{"zero DialRetryInterval", func(c *Config) { c.DialRetryInterval = 0 }, "interval not positive."},
+		{"negative CreateNewInterval", func(c *Config) { c.CreateNewInterval = -time.Second }, "interval not positive."},
+	}
+	for _, tc := range cases {
+		config := b.config("validate", 2, 1)
+		tc.change(&config)
+		if err := config.Validate(); err == nil || err.Error() != tc.want {
+			t.Errorf("Validate with %v returned %v, want %q", tc.name, err, tc.want)
+		}
+		if _, err := NewFromConfig(config); err == nil || err.Error() != tc.want {
+			t.Errorf("NewFromConfig with %v returned %v, want %q", tc.name, err, tc.want)
+		}
+	}
+
+	config := b.config("validate", 2, 3)
+	config.InitialSizePolicy = ClampInitialSize
+	if err := config.Validate(); err != nil {
+		t.Errorf("Validate with a clamped initial size: %v", err)
+	}
+}
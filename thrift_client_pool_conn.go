@@ -0,0 +1,617 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ConnState int
+
+const (
+	ConnIdle ConnState = iota
+	ConnInUse
+	ConnClosed
+	ConnRetrying
+	// ConnDialed is the from state of the first OnConnState call of a connection.
+	ConnDialed
+)
+
+func (s ConnState) String() string {
+
+	switch s {
+	case ConnIdle:
+		return "idle"
+	case ConnInUse:
+		return "in-use"
+	case ConnClosed:
+		return "closed"
+	case ConnRetrying:
+		return "retrying"
+	case ConnDialed:
+		return "dialed"
+	}
+	return "unknown"
+}
+
+// Conn keeps the pool bookkeeping of a single managed connection. With
+// ReuseConnWrappers set only wrappers never handed out by Conn, ForEachIdle or
+// OnEvict are recycled, so a Conn held by the caller stays valid.
+type Conn struct {
+	pool       *ThriftClientPool
+	connection interface{}
+	id         uint64
+	endpoint   string
+	useCount   int64
+	state      ConnState
+	lastErr    error
+	created    time.Time
+	lastUsed   time.Time
+	lastProbe  time.Time
+	caller     string
+	lastCaller string
+	coolUntil  time.Time
+	budget     *CapacityBudget
+	flushed    bool
+	primed     bool
+	corrID     string
+	exposed    bool
+	closeFn    func(connection interface{}) (err error)
+}
+
+func (c *Conn) CreatedAt() time.Time {
+	return c.created
+}
+
+// LastUsed returns when the connection was last returned to the pool.
+func (c *Conn) LastUsed() time.Time {
+
+	c.pool.connsSync.Lock()
+	defer c.pool.connsSync.Unlock()
+	return c.lastUsed
+}
+
+// LastKeepAlive returns when the connection last passed a keepalive probe,
+// its dial time before the first one.
+func (c *Conn) LastKeepAlive() time.Time {
+
+	c.pool.connsSync.Lock()
+	defer c.pool.connsSync.Unlock()
+	return c.lastProbe
+}
+
+// IdleFor returns how long an idle connection has been waiting, 0 when borrowed.
+func (c *Conn) IdleFor() time.Duration {
+
+	c.pool.connsSync.Lock()
+	defer c.pool.connsSync.Unlock()
+
+	if c.state != ConnIdle {
+		return 0
+	}
+	return time.Since(c.lastUsed)
+}
+
+// ForEachIdle calls fn with every idle connection of the pool.
+func (p *ThriftClientPool) ForEachIdle(fn func(conn *Conn)) {
+
+	p.connsSync.Lock()
+	idle := make([]*Conn, 0, len(p.conns))
+	for _, conn := range p.conns {
+		if conn.state == ConnIdle {
+			conn.exposed = true
+			idle = append(idle, conn)
+		}
+	}
+	p.connsSync.Unlock()
+
+	for _, conn := range idle {
+		fn(conn)
+	}
+}
+
+func (c *Conn) Connection() interface{} {
+	return c.connection
+}
+
+// LastError returns the last keepalive or RPC error seen on the connection.
+func (c *Conn) LastError() error {
+
+	c.pool.connsSync.Lock()
+	defer c.pool.connsSync.Unlock()
+	return c.lastErr
+}
+
+// Conn returns the bookkeeping of a connection managed by the pool.
+func (p *ThriftClientPool) Conn(connection interface{}) (conn *Conn, ok bool) {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	if conn, ok = p.conns[connection]; ok {
+		conn.exposed = true
+	}
+	return
+}
+
+// IsManaged reports whether the pool still considers connection valid, it is
+// false once the connection was closed, evicted or retired.
+func (p *ThriftClientPool) IsManaged(connection interface{}) bool {
+
+	state, ok := p.connState(connection)
+	return ok && state != ConnClosed
+}
+
+var connWrappers = sync.Pool{New: func() interface{} { return &Conn{} }}
+
+func (p *ThriftClientPool) trackConn(connection interface{}) *Conn {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	if p.conns == nil {
+		p.conns = make(map[interface{}]*Conn)
+	}
+
+	var conn *Conn
+	if p.cfg().ReuseConnWrappers {
+		conn = connWrappers.Get().(*Conn)
+	} else {
+		conn = &Conn{}
+	}
+
+	now := time.Now()
+	*conn = Conn{pool: p, connection: connection, id: atomic.AddUint64(&p.nextConnID, 1), created: now, lastUsed: now, lastProbe: now, closeFn: p.cfg().Close}
+	p.conns[connection] = conn
+	if onConnState := p.cfg().OnConnState; onConnState != nil {
+		p.callOnConnState(onConnState, conn.id, ConnDialed, ConnIdle)
+	}
+	return conn
+}
+
+// moveState must be called with p.connsSync held, OnConnState runs under it too
+// so it must not call back into the pool.
+func (p *ThriftClientPool) moveState(conn *Conn, to ConnState) {
+
+	from := conn.state
+	conn.state = to
+	if onConnState := p.cfg().OnConnState; onConnState != nil && from != to {
+		p.callOnConnState(onConnState, conn.id, from, to)
+	}
+}
+
+func (p *ThriftClientPool) callOnConnState(onConnState func(id uint64, from, to ConnState), id uint64, from, to ConnState) {
+
+	var err error
+	defer p.recoverCallback("OnConnState", &err)
+	onConnState(id, from, to)
+}
+
+func (p *ThriftClientPool) untrackConn(connection interface{}) {
+
+	p.connsSync.Lock()
+	p.deleteConn(connection)
+	p.connsSync.Unlock()
+	p.refreshHealth()
+}
+
+// deleteConn must be called with p.connsSync held, the caller refreshes the
+// health once it is released. With ReuseConnWrappers a
+// wrapper that never escaped is reset and recycled, so it must not be used after this.
+func (p *ThriftClientPool) deleteConn(connection interface{}) {
+
+	conn, ok := p.conns[connection]
+	if !ok {
+		return
+	}
+
+	p.moveState(conn, ConnClosed)
+	if conn.budget != nil {
+		conn.budget.release()
+	}
+	delete(p.conns, connection)
+	if p.cfg().ReuseConnWrappers && !conn.exposed {
+		*conn = Conn{}
+		connWrappers.Put(conn)
+	}
+}
+
+func (p *ThriftClientPool) setLastError(connection interface{}, err error) *Conn {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	conn, ok := p.conns[connection]
+	if !ok {
+		conn = &Conn{pool: p, connection: connection}
+	}
+	conn.lastErr = err
+	return conn
+}
+
+func (p *ThriftClientPool) connState(connection interface{}) (state ConnState, ok bool) {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	if conn, ok := p.conns[connection]; ok {
+		return conn.state, true
+	}
+	return ConnClosed, false
+}
+
+// markPrimed reports whether connection still needs OnFirstUse, and marks it done.
+func (p *ThriftClientPool) markPrimed(connection interface{}) bool {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	conn, ok := p.conns[connection]
+	if !ok || conn.primed {
+		return false
+	}
+	conn.primed = true
+	return true
+}
+
+func (p *ThriftClientPool) markProbed(connection interface{}) {
+
+	p.connsSync.Lock()
+	if conn, ok := p.conns[connection]; ok {
+		conn.lastProbe = time.Now()
+	}
+	p.connsSync.Unlock()
+}
+
+// recentlyAlive reports whether connection was returned or passed a probe
+// within interval, with ProbeStaleOnly the keepalive pass skips it then.
+func (p *ThriftClientPool) recentlyAlive(connection interface{}, interval time.Duration) bool {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	conn, ok := p.conns[connection]
+	if !ok {
+		return false
+	}
+
+	last := conn.lastUsed
+	if conn.lastProbe.After(last) {
+		last = conn.lastProbe
+	}
+	return time.Since(last) < interval
+}
+
+func (p *ThriftClientPool) setConnState(connection interface{}, state ConnState) {
+
+	p.connsSync.Lock()
+	if conn, ok := p.conns[connection]; ok {
+		p.moveState(conn, state)
+	}
+	p.connsSync.Unlock()
+}
+
+func (p *ThriftClientPool) markBorrowed(connection interface{}) {
+
+	p.connsSync.Lock()
+	if conn, ok := p.conns[connection]; ok {
+		p.moveState(conn, ConnInUse)
+		conn.useCount++
+		// only GetFor names who returns it, DrainKeep hands it back to them.
+		conn.lastCaller = ""
+	}
+	p.connsSync.Unlock()
+}
+
+// markReturned reports the state connection had before it was given back,
+// ok is false when the connection is not managed by this pool.
+func (p *ThriftClientPool) markReturned(connection interface{}) (state ConnState, ok bool) {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	conn, ok := p.conns[connection]
+	if !ok {
+		return ConnClosed, false
+	}
+
+	state = conn.state
+	switch state {
+	case ConnInUse:
+		p.moveState(conn, ConnIdle)
+		conn.lastUsed = time.Now()
+	case ConnClosed:
+		p.deleteConn(connection)
+	}
+	return state, true
+}
+
+// CloseConn closes a borrowed or idle connection and takes it out of the pool,
+// a later Put of the same connection is ignored as it is unknown to every pool.
+func (p *ThriftClientPool) CloseConn(connection interface{}) error {
+
+	p.sync.Lock()
+	state, ok := p.connState(connection)
+	if !ok || state == ConnClosed {
+		p.unlock()
+		return errors.New("connection does not belong to pool.")
+	}
+
+	// one being probed stays tracked, the keepalive pass untracks it.
+	untrack := true
+	p.setConnState(connection, ConnClosed)
+	if state == ConnInUse {
+		p.releaseCaller(connection)
+		p.releaseWorkSlot()
+	} else {
+		untrack = p.removeIdle(connection)
+	}
+	p.unlock()
+
+	err := p.closeConnection(connection)
+	if untrack {
+		p.untrackConn(connection)
+	}
+	return err
+}
+
+// removeIdle takes connection out of the idle store, connections being probed in
+// swapPool are skipped by keepAliveLoop once they are marked closed.
+func (p *ThriftClientPool) removeIdle(connection interface{}) (found bool) {
+
+	p.filterIdle(func(c interface{}) bool {
+		if c == connection {
+			found = true
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// evictConnection closes a broken connection and leaves a retry slot so the
+// retry loop dials a replacement. It reports false when BeforeClose kept the
+// connection, the caller re-pools it then.
+func (p *ThriftClientPool) evictConnection(connection interface{}, cause error) (evicted bool) {
+
+	conn := p.setLastError(connection, cause)
+	if p.beforeClose(connection) {
+		p.logger().Infof("BeforeClose kept connection on %v despite: %v", p.Name(), cause)
+		return false
+	}
+
+	p.notifyEvict(conn)
+	p.noteFailure()
+
+	if err := p.closeConnection(connection); err != nil {
+		p.logger().Warnf("Evict connection close error: %v", err)
+	}
+	p.untrackConn(connection)
+	p.pushRetry()
+	return true
+}
+
+// notifyEvict hands conn to OnEvict, its LastError holds the cause.
+func (p *ThriftClientPool) notifyEvict(conn *Conn) {
+
+	if onEvict := p.cfg().OnEvict; onEvict != nil {
+		p.connsSync.Lock()
+		conn.exposed = true
+		p.connsSync.Unlock()
+		p.callHook("OnEvict", func() { onEvict(p.Name(), conn) })
+	}
+}
+
+// beforeClose asks BeforeClose whether to keep a connection the pool is about to
+// evict or recycle, a stopped pool keeps nothing.
+func (p *ThriftClientPool) beforeClose(connection interface{}) (keep bool) {
+
+	hook := p.cfg().BeforeClose
+	if hook == nil || !p.serving() {
+		return false
+	}
+
+	var err error
+	defer func() {
+		if err != nil {
+			keep = false
+		}
+	}()
+	defer p.recoverCallback("BeforeClose", &err)
+	return hook(connection)
+}
+
+// PutErr returns a borrowed connection together with the error of the RPC made
+// on it, a connection returned with an error is evicted instead of re-pooled.
+func (p *ThriftClientPool) PutErr(connection interface{}, rpcErr error) error {
+
+	var closing closeQueue
+	p.sync.Lock()
+	owner, err := p.putErr(connection, rpcErr, &closing)
+	p.unlock()
+
+	p.closeQueued(&closing)
+	p.closeForeign(owner, connection)
+	return err
+}
+
+// putErr must be called with p.sync held, see put.
+func (p *ThriftClientPool) putErr(connection interface{}, rpcErr error, closing *closeQueue) (owner *ThriftClientPool, err error) {
+
+	if rpcErr == nil || connection == nil {
+		return p.put(connection, closing)
+	}
+
+	if state, ok := p.connState(connection); !ok {
+		// a connection of another pool is handed back to it like with put.
+		return p.put(connection, closing)
+	} else if state != ConnInUse {
+		return nil, errors.New("connection was not borrowed.")
+	}
+
+	p.releaseCaller(connection)
+	p.releaseWorkSlot()
+	if !p.serving() {
+		// Release owns every connection now, no retry slot is left behind.
+		p.untagBorrow(connection)
+		p.setLastError(connection, rpcErr)
+		closing.release = append(closing.release, connection)
+		return nil, nil
+	}
+	// the id stays on the connection for OnEvict.
+	if id := p.correlationOf(connection); id != "" {
+		p.logger().Infof("Put connection with error on %v [correlation %v], evict it: %v", p.Name(), id, rpcErr)
+	} else {
+		p.logger().Infof("Put connection with error on %v, evict it: %v", p.Name(), rpcErr)
+	}
+	// evicted once p.sync is released, see closeQueued.
+	atomic.AddInt32(&p.probing, 1)
+	closing.evict = append(closing.evict, eviction{connection: connection, cause: rpcErr})
+	return nil, nil
+}
+
+// PutAll returns a batch of borrowed connections under one lock, see Put.
+func (p *ThriftClientPool) PutAll(connections []interface{}) error {
+	return p.PutAllErr(connections, nil)
+}
+
+// PutAllErr is PutErr for a batch, rpcErrs holds the RPC error of the connection
+// at the same index. The others are re-pooled in one locked pass, connections
+// with an error are evicted once the lock is released.
+func (p *ThriftClientPool) PutAllErr(connections []interface{}, rpcErrs []error) error {
+
+	if rpcErrs != nil && len(rpcErrs) != len(connections) {
+		return errors.New("connections and errors differ in length.")
+	}
+
+	failures := []string{}
+	owners := map[interface{}]*ThriftClientPool{}
+	var closing closeQueue
+
+	p.sync.Lock()
+	for i, connection := range connections {
+		var rpcErr error
+		if rpcErrs != nil {
+			rpcErr = rpcErrs[i]
+		}
+
+		owner, err := p.putErr(connection, rpcErr, &closing)
+		if owner != nil {
+			owners[connection] = owner
+		}
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	p.unlock()
+
+	p.closeQueued(&closing)
+	for connection, owner := range owners {
+		p.closeForeign(owner, connection)
+	}
+
+	if len(failures) > 0 {
+		return errors.New(fmt.Sprintf("Put connection error: %v.", failures))
+	}
+	return nil
+}
+
+// Transfer moves idle connections with their bookkeeping into another pool
+// until it or its CapacityBudget is full, and returns how many were moved. The
+// connections are never re-dialed, the target slot is reserved in its
+// dialsInFlight before the connection leaves p, so Gets of the target can not
+// fill it meanwhile.
+func (p *ThriftClientPool) Transfer(to *ThriftClientPool) (int, error) {
+
+	if to == nil || to == p {
+		return 0, errors.New("invalid transfer target.")
+	}
+
+	if !to.serving() {
+		return 0, errors.New("transfer target was stopped.")
+	}
+
+	moved := 0
+	for {
+		to.sync.Lock()
+		if to.totalConnCount() >= to.dialLimit() {
+			to.unlock()
+			return moved, nil
+		}
+		atomic.AddInt32(&to.dialsInFlight, 1)
+		to.unlock()
+
+		p.sync.Lock()
+		connection, ok := p.popAlive()
+		var conn Conn
+		if ok {
+			p.connsSync.Lock()
+			if tracked, found := p.conns[connection]; found {
+				conn = *tracked
+			}
+			p.deleteConn(connection)
+			p.connsSync.Unlock()
+			p.refreshHealth()
+		}
+		p.unlock()
+
+		if !ok {
+			atomic.AddInt32(&to.dialsInFlight, -1)
+			return moved, nil
+		}
+
+		to.sync.Lock()
+		full := to.stopped() || to.adoptConn(connection, &conn) != nil
+		if !full {
+			to.pushAlive(connection)
+			moved++
+		}
+		atomic.AddInt32(&to.dialsInFlight, -1)
+		to.unlock()
+
+		if full {
+			p.sync.Lock()
+			if err := p.adoptConn(connection, &conn); err != nil {
+				// another pool took the budget share released above.
+				p.logger().Warnf("Transfer from %v can not take back connection, close it: %v", p.Name(), err)
+				p.callClose(conn.closeFn, connection)
+			} else {
+				p.pushAlive(connection)
+			}
+			p.unlock()
+			return moved, nil
+		}
+	}
+}
+
+// adoptConn tracks a connection coming from another pool, keeping its
+// bookkeeping. It tracks nothing and fails with ErrBudgetExhausted when the
+// CapacityBudget has no room left for the connection.
+func (p *ThriftClientPool) adoptConn(connection interface{}, from *Conn) error {
+
+	budget := p.cfg().CapacityBudget
+	if budget != nil && !budget.take() {
+		return ErrBudgetExhausted
+	}
+
+	conn := p.trackConn(connection)
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	conn.budget = budget
+	if from == nil || from.pool == nil {
+		return nil
+	}
+
+	conn.created = from.created
+	conn.lastErr = from.lastErr
+	conn.lastUsed = from.lastUsed
+	conn.lastProbe = from.lastProbe
+	conn.primed = from.primed
+	conn.closeFn = from.closeFn
+	conn.endpoint = from.endpoint
+	conn.useCount = from.useCount
+	return nil
+}
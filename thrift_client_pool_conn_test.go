@@ -0,0 +1,384 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReuseConnWrappersKeepsExposedConn(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("reuse", 2, 2)
+	config.ReuseConnWrappers = true
+	p := newTestPool(t, config)
+	waitFor(t, "initial connections", func() bool { return p.alivePool.Len() == 2 })
+
+	var held []*Conn
+	p.ForEachIdle(func(conn *Conn) { held = append(held, conn) })
+	if len(held) != 2 {
+		t.Fatalf("ForEachIdle saw %v connections, want 2", len(held))
+	}
+
+	for _, conn := range held {
+		connection := conn.Connection()
+		if err := p.CloseConn(connection); err != nil {
+			t.Fatalf("CloseConn: %v", err)
+		}
+		// a recycled wrapper would have a nil pool and panic here.
+		conn.IdleFor()
+		conn.LastKeepAlive()
+		if conn.Connection() != connection {
+			t.Fatalf("held Conn was reused for another connection")
+		}
+	}
+}
+
+func TestReuseConnWrappersRecyclesUnexposedConn(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("reuse", 1, 0)
+	config.ReuseConnWrappers = true
+	p := newTestPool(t, config)
+
+	connection := &fakeConn{}
+	conn := p.trackConn(connection)
+	p.untrackConn(connection)
+	if conn.pool != nil {
+		t.Fatalf("unexposed wrapper was not reset for reuse")
+	}
+
+	connection = &fakeConn{}
+	p.trackConn(connection)
+	conn, _ = p.Conn(connection)
+	p.untrackConn(connection)
+	if conn.pool != p {
+		t.Fatalf("exposed wrapper was reset")
+	}
+}
+
+func BenchmarkTrackConn(b *testing.B) {
+
+	for _, reuse := range []bool{false, true} {
+		name := "alloc"
+		if reuse {
+			name = "reuse"
+		}
+		b.Run(name, func(b *testing.B) {
+			backend := &fakeBackend{}
+			config := backend.config("bench", 1, 0)
+			config.ReuseConnWrappers = reuse
+			p := newTestPool(b, config)
+			connection := &fakeConn{}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				p.trackConn(connection)
+				p.untrackConn(connection)
+			}
+		})
+	}
+}
+
+func TestCloseConnUntracksBorrowedConnection(t *testing.T) {
+
+	b := &fakeBackend{}
+	budget := NewCapacityBudget(2)
+	p := newTestPool(t, b.config("closeconn", 2, 1), WithCapacityBudget(budget))
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := p.CloseConn(connection); err != nil {
+		t.Fatalf("CloseConn: %v", err)
+	}
+
+	p.connsSync.Lock()
+	tracked := len(p.conns)
+	p.connsSync.Unlock()
+	if tracked != 0 || budget.Used() != 0 || p.TotalConns() != 0 {
+		t.Fatalf("after CloseConn tracked = %v, budget used = %v, total = %v, want all 0", tracked, budget.Used(), p.TotalConns())
+	}
+
+	p.Put(connection)
+	p.PutErr(connection, errFakeDown)
+	if closes := b.closeCount(); closes != 1 {
+		t.Fatalf("closes after Put of a closed connection = %v, want 1", closes)
+	}
+	if retry := len(p.retryPool()); retry != 0 || p.TotalConns() != 0 {
+		t.Fatalf("Put of a closed connection left retry = %v, total = %v", retry, p.TotalConns())
+	}
+}
+
+func TestIsManagedTracksBorrowAndClose(t *testing.T) {
+
+	blueBackend, greenBackend := &fakeBackend{}, &fakeBackend{}
+	blue := newTestPool(t, blueBackend.config("blue", 1, 0))
+	green := newTestPool(t, greenBackend.config("green", 1, 0))
+
+	connection, err := blue.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !blue.IsManaged(connection) {
+		t.Fatalf("borrowed connection is not managed")
+	}
+	if green.IsManaged(connection) {
+		t.Fatalf("another pool manages the borrowed connection")
+	}
+
+	if err := blue.CloseConn(connection); err != nil {
+		t.Fatalf("CloseConn: %v", err)
+	}
+	if blue.IsManaged(connection) {
+		t.Fatalf("closed connection is still managed")
+	}
+}
+
+func TestPutErrOnReleasedPoolReleasesConnection(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("puterrreleased", 1, 1)
+	released := 0
+	config.OnRelease = func(tag string, connection interface{}) { released++ }
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.Release()
+	p.PutErr(connection, errFakeDown)
+
+	if released != 1 || !connection.(*fakeConn).isClosed() {
+		t.Fatalf("PutErr after Release: OnRelease ran %v times, closed %v", released, connection.(*fakeConn).isClosed())
+	}
+	if retry := len(p.retryPool()); retry != 0 {
+		t.Fatalf("PutErr after Release left %v retry slots", retry)
+	}
+}
+
+func TestLastErrorNamesEvictionCause(t *testing.T) {
+
+	errKeepAlive := errors.New("keepalive cause.")
+	errProbe := errors.New("probe cause.")
+	errRPC := errors.New("rpc cause.")
+
+	causes := map[string]struct {
+		want  error
+		setup func(b *fakeBackend, config *Config)
+		evict func(t *testing.T, p *ThriftClientPool)
+	}{
+		"keepalive": {
+			want:  errKeepAlive,
+			setup: func(b *fakeBackend, config *Config) { b.keepAliveFn = func(c *fakeConn) error { return errKeepAlive } },
+			evict: func(t *testing.T, p *ThriftClientPool) { p.keepAlivePass() },
+		},
+		"borrow probe": {
+			want:  errProbe,
+			setup: func(b *fakeBackend, config *Config) { config.BorrowProbe = func(interface{}) error { return errProbe } },
+			evict: func(t *testing.T, p *ThriftClientPool) { p.GetExisting() },
+		},
+		"rpc": {
+			want: errRPC,
+			evict: func(t *testing.T, p *ThriftClientPool) {
+				connection, err := p.Get()
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				p.PutErr(connection, errRPC)
+			},
+		},
+	}
+
+	for name, cause := range causes {
+		b := &fakeBackend{}
+		config := b.config("lasterror", 1, 1)
+		if cause.setup != nil {
+			cause.setup(b, &config)
+		}
+		evicted := make(chan error, 1)
+		config.OnEvict = func(tag string, conn *Conn) { evicted <- conn.LastError() }
+		p := newTestPool(t, config)
+
+		cause.evict(t, p)
+		select {
+		case err := <-evicted:
+			if err != cause.want {
+				t.Fatalf("%v eviction LastError = %v, want %v", name, err, cause.want)
+			}
+		default:
+			t.Fatalf("%v failure did not evict the connection", name)
+		}
+		p.Release()
+	}
+}
+
+func TestPutToAnotherPoolClosesForeignConnection(t *testing.T) {
+
+	blueBackend, greenBackend := &fakeBackend{}, &fakeBackend{}
+	blue := newTestPool(t, blueBackend.config("blue", 2, 1))
+	green := newTestPool(t, greenBackend.config("green", 2, 1))
+
+	old, err := blue.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	kept, err := blue.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if err := green.Put(old); err == nil {
+		t.Fatalf("Put of a foreign connection was accepted")
+	}
+	if !old.(*fakeConn).isClosed() {
+		t.Fatalf("Put of a foreign connection left it open")
+	}
+	if idle := green.alivePool.Len(); idle != 1 || green.IsManaged(old) {
+		t.Fatalf("foreign connection was pooled, green has %v idle", idle)
+	}
+	if blueCloses, greenCloses := blueBackend.closeCount(), greenBackend.closeCount(); blueCloses != 1 || greenCloses != 0 {
+		t.Fatalf("closes blue %v green %v, want the owner's Close once", blueCloses, greenCloses)
+	}
+	if total := blue.TotalConns(); total != 1 || blue.IsManaged(old) {
+		t.Fatalf("owner keeps the foreign connection, TotalConns %v IsManaged %v", total, blue.IsManaged(old))
+	}
+
+	// the owner does not take the closed connection back.
+	if err := blue.Put(old); err == nil || blue.alivePool.Len() != 0 {
+		t.Fatalf("owner took back the closed connection, err %v idle %v", err, blue.alivePool.Len())
+	}
+
+	// the originating pool still takes its connections back after the switch.
+	if err := blue.Put(kept); err != nil {
+		t.Fatalf("Put to the originating pool: %v", err)
+	}
+	if idle := blue.alivePool.Len(); idle != 1 || kept.(*fakeConn).isClosed() {
+		t.Fatalf("originating pool has %v idle connections after Put, want the returned one", idle)
+	}
+}
+
+func TestIdleForReportsTimeSincePut(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("idlefor", 1, 1))
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	conn, _ := p.Conn(connection)
+	if idle := conn.IdleFor(); idle != 0 {
+		t.Fatalf("borrowed connection reports idle for %v", idle)
+	}
+
+	returned := time.Now()
+	p.Put(connection)
+	time.Sleep(time.Millisecond * 50)
+
+	reported := 0
+	p.ForEachIdle(func(conn *Conn) {
+		reported++
+		idle := conn.IdleFor()
+		if waited := time.Since(returned); idle < time.Millisecond*50 || idle > waited {
+			t.Errorf("IdleFor = %v, want between 50ms and %v", idle, waited)
+		}
+		if used := conn.LastUsed(); used.Before(returned) || used.After(returned.Add(idle)) {
+			t.Errorf("LastUsed = %v, want the Put at %v", used, returned)
+		}
+	})
+	if reported != 1 {
+		t.Fatalf("ForEachIdle reported %v connections, want 1", reported)
+	}
+	if oldest := p.Stats().OldestIdle; oldest < time.Millisecond*50 {
+		t.Fatalf("Stats.OldestIdle = %v, want at least 50ms", oldest)
+	}
+}
+
+func TestOnConnStateTracesBorrowReturnEvict(t *testing.T) {
+
+	b := &fakeBackend{}
+	var failing int32
+	b.keepAliveFn = func(c *fakeConn) error {
+		if atomic.LoadInt32(&failing) == 1 {
+			return errFakeDown
+		}
+		return nil
+	}
+	var trace []string
+	var traceSync sync.Mutex
+	config := b.config("connstate", 1, 1)
+	config.OnConnState = func(id uint64, from, to ConnState) {
+		traceSync.Lock()
+		trace = append(trace, fmt.Sprintf("%v:%v>%v", id, from, to))
+		traceSync.Unlock()
+	}
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.Put(connection)
+	atomic.StoreInt32(&failing, 1)
+	p.TriggerKeepAlive()
+	waitFor(t, "the eviction", func() bool { return b.closeCount() == 1 })
+
+	traceSync.Lock()
+	defer traceSync.Unlock()
+	want := []string{"1:dialed>idle", "1:idle>in-use", "1:in-use>idle", "1:idle>closed"}
+	if !reflect.DeepEqual(trace, want) {
+		t.Fatalf("OnConnState trace = %v, want %v", trace, want)
+	}
+}
+
+func TestPutAllErrPoolsHealthyAndEvictsPoisoned(t *testing.T) {
+
+	b := &fakeBackend{}
+	var p *ThriftClientPool
+	var closedUnderLock int32
+	config := b.config("putall", 3, 3)
+	config.Close = func(connection interface{}) error {
+		if !p.sync.TryLock() {
+			atomic.StoreInt32(&closedUnderLock, 1)
+		} else {
+			p.sync.Unlock()
+		}
+		return b.close(connection)
+	}
+	p = newTestPool(t, config)
+
+	batch := []interface{}{}
+	for i := 0; i < 3; i++ {
+		connection, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		batch = append(batch, connection)
+	}
+
+	if err := p.PutAllErr(batch, []error{nil, errFakeDown, nil}); err != nil {
+		t.Fatalf("PutAllErr: %v", err)
+	}
+	if idle := p.alivePool.Len(); idle != 2 || !p.IsManaged(batch[0]) || !p.IsManaged(batch[2]) {
+		t.Fatalf("idle after PutAllErr = %v, want the 2 healthy connections", idle)
+	}
+	if closes := b.closeCount(); closes != 1 || !batch[1].(*fakeConn).isClosed() || p.IsManaged(batch[1]) {
+		t.Fatalf("closes after PutAllErr = %v, want the poisoned connection once", closes)
+	}
+	if atomic.LoadInt32(&closedUnderLock) == 1 {
+		t.Fatal("the poisoned connection was closed under the pool lock")
+	}
+	if retry, total := p.Stats().Retry, p.TotalConns(); retry != 1 || total != 3 {
+		t.Fatalf("Retry %v TotalConns %v after PutAllErr, want 1 and 3", retry, total)
+	}
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,123 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type coolingConn struct {
+	connection interface{}
+	until      time.Time
+	suspect    bool
+}
+
+// PutCooldown returns a borrowed connection like Put, but keeps it out of
+// rotation for d, e.g. after an error hinting at a briefly overloaded backend.
+func (p *ThriftClientPool) PutCooldown(connection interface{}, d time.Duration) error {
+
+	if connection != nil && d > 0 {
+		p.connsSync.Lock()
+		if conn, ok := p.conns[connection]; ok && conn.state == ConnInUse {
+			conn.coolUntil = time.Now().Add(d)
+		}
+		p.connsSync.Unlock()
+	}
+
+	return p.Put(connection)
+}
+
+// repool stores a returned connection, or parks it while its cooldown lasts.
+func (p *ThriftClientPool) repool(connection interface{}) {
+
+	p.connsSync.Lock()
+	until := time.Time{}
+	if conn, ok := p.conns[connection]; ok {
+		until, conn.coolUntil = conn.coolUntil, time.Time{}
+	}
+	p.connsSync.Unlock()
+
+	if !time.Now().Before(until) {
+		p.pushAlive(connection)
+		return
+	}
+
+	p.coolSync.Lock()
+	p.cooling = append(p.cooling, coolingConn{connection: connection, until: until})
+	atomic.StoreInt32(&p.coolingCount, int32(len(p.cooling)))
+	p.coolSync.Unlock()
+}
+
+// restoreCooled moves the connections whose cooldown passed back into rotation.
+func (p *ThriftClientPool) restoreCooled(now time.Time) {
+
+	if atomic.LoadInt32(&p.coolingCount) == 0 {
+		return
+	}
+
+	p.coolSync.Lock()
+	pending := p.cooling[:0]
+	ready := []interface{}{}
+	for _, c := range p.cooling {
+		if c.suspect || now.Before(c.until) {
+			pending = append(pending, c)
+		} else {
+			ready = append(ready, c.connection)
+		}
+	}
+	p.cooling = pending
+	atomic.StoreInt32(&p.coolingCount, int32(len(p.cooling)))
+	p.coolSync.Unlock()
+
+	for _, connection := range ready {
+		if state, _ := p.connState(connection); state == ConnClosed {
+			p.untrackConn(connection)
+		} else {
+			p.pushAlive(connection)
+		}
+	}
+}
+
+// parkSuspect keeps a connection that failed its probe over MaxEvictionsPerPass
+// out of rotation, the next keepalive pass probes it again. It counts as cooling.
+func (p *ThriftClientPool) parkSuspect(connection interface{}) {
+
+	p.coolSync.Lock()
+	p.cooling = append(p.cooling, coolingConn{connection: connection, suspect: true})
+	atomic.StoreInt32(&p.coolingCount, int32(len(p.cooling)))
+	p.coolSync.Unlock()
+}
+
+// takeSuspects hands the parked suspects to a keepalive pass, counted in
+// probing before they leave the cooling count.
+func (p *ThriftClientPool) takeSuspects() (connections []interface{}) {
+
+	p.coolSync.Lock()
+	defer p.coolSync.Unlock()
+
+	pending := p.cooling[:0]
+	for _, c := range p.cooling {
+		if c.suspect {
+			connections = append(connections, c.connection)
+		} else {
+			pending = append(pending, c)
+		}
+	}
+	atomic.AddInt32(&p.probing, int32(len(connections)))
+	p.cooling = pending
+	atomic.StoreInt32(&p.coolingCount, int32(len(p.cooling)))
+	return
+}
+
+// takeCooling empties the cooling list for Release.
+func (p *ThriftClientPool) takeCooling() (connections []interface{}) {
+
+	p.coolSync.Lock()
+	defer p.coolSync.Unlock()
+
+	for _, c := range p.cooling {
+		connections = append(connections, c.connection)
+	}
+	p.cooling = nil
+	atomic.StoreInt32(&p.coolingCount, 0)
+	return
+}
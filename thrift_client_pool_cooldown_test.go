@@ -0,0 +1,59 @@
+package thrift_clientpool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPutCooldownKeepsConnectionOutOfRotation(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("cooldown", 1, 1))
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := p.PutCooldown(connection, time.Millisecond*50); err != nil {
+		t.Fatalf("PutCooldown: %v", err)
+	}
+	if cooling := p.Stats().Cooling; cooling != 1 {
+		t.Fatalf("Cooling = %v, want 1", cooling)
+	}
+	if total := p.TotalConns(); total != 1 {
+		t.Fatalf("TotalConns while cooling = %v, want 1", total)
+	}
+	if _, err := p.GetExisting(); err != ErrNoIdleConnection {
+		t.Fatalf("GetExisting while cooling returned %v, want ErrNoIdleConnection", err)
+	}
+
+	time.Sleep(time.Millisecond * 50)
+	again, err := p.GetExisting()
+	if err != nil {
+		t.Fatalf("GetExisting after the cooldown: %v", err)
+	}
+	if again != connection {
+		t.Fatal("GetExisting after the cooldown returned another connection")
+	}
+	p.Put(again)
+}
+
+func TestReleaseClosesCoolingConnections(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("cooldownrelease", 1, 1))
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.PutCooldown(connection, time.Hour)
+	p.Release()
+
+	if !connection.(*fakeConn).isClosed() {
+		t.Fatal("Release left a cooling connection open")
+	}
+	if cooling := p.Stats().Cooling; cooling != 0 {
+		t.Fatalf("Cooling after Release = %v, want 0", cooling)
+	}
+}
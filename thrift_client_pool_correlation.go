@@ -0,0 +1,79 @@
+package thrift_clientpool
+
+import (
+	"context"
+)
+
+type correlationKey struct{}
+
+// WithCorrelationID returns a context carrying id, a Get with it tags the
+// borrowed connection so the pool logs of that borrow and its Conn carry id.
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationKey{}, id)
+}
+
+// CorrelationID returns the id WithCorrelationID stored in ctx, or "".
+func CorrelationID(ctx context.Context) string {
+
+	id, _ := ctx.Value(correlationKey{}).(string)
+	return id
+}
+
+// CorrelationID returns the id of the borrow holding the connection, "" when
+// it is idle or was borrowed without one.
+func (c *Conn) CorrelationID() string {
+
+	c.pool.connsSync.Lock()
+	defer c.pool.connsSync.Unlock()
+	return c.corrID
+}
+
+// tagBorrow records the correlation id of ctx on a connection Get borrowed.
+func (p *ThriftClientPool) tagBorrow(ctx context.Context, connection interface{}) {
+
+	id := CorrelationID(ctx)
+	if id == "" {
+		return
+	}
+
+	p.connsSync.Lock()
+	connID := uint64(0)
+	if conn, ok := p.conns[connection]; ok {
+		conn.corrID = id
+		connID = conn.id
+	}
+	p.connsSync.Unlock()
+
+	if p.logEnabled(LevelDebug) {
+		p.logger().Debugf("Borrowed connection %v from %v [correlation %v].", connID, p.Name(), id)
+	}
+}
+
+// untagBorrow clears the correlation id of a returned connection and returns it.
+func (p *ThriftClientPool) untagBorrow(connection interface{}) (id string) {
+
+	p.connsSync.Lock()
+	connID := uint64(0)
+	if conn, ok := p.conns[connection]; ok {
+		id, conn.corrID = conn.corrID, ""
+		connID = conn.id
+	}
+	p.connsSync.Unlock()
+
+	if id != "" && p.logEnabled(LevelDebug) {
+		p.logger().Debugf("Returned connection %v to %v [correlation %v].", connID, p.Name(), id)
+	}
+	return
+}
+
+// correlationOf returns the correlation id of a tracked connection, "" otherwise.
+func (p *ThriftClientPool) correlationOf(connection interface{}) string {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	if conn, ok := p.conns[connection]; ok {
+		return conn.corrID
+	}
+	return ""
+}
@@ -0,0 +1,29 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCorrelationIDTagsTheBorrow(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("correlation", 1, 1))
+
+	if id := CorrelationID(context.Background()); id != "" {
+		t.Fatalf("CorrelationID of a bare context = %q", id)
+	}
+
+	connection, err := p.GetContext(WithCorrelationID(context.Background(), "req-1"))
+	if err != nil {
+		t.Fatalf("GetContext: %v", err)
+	}
+	if id := p.correlationOf(connection); id != "req-1" {
+		t.Fatalf("correlation of the borrow = %q, want req-1", id)
+	}
+
+	p.Put(connection)
+	if id := p.correlationOf(connection); id != "" {
+		t.Fatalf("correlation after Put = %q, want empty", id)
+	}
+}
@@ -0,0 +1,421 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHungDialTimesOutWithoutHoldingLock(t *testing.T) {
+
+	b := &fakeBackend{}
+	hang := make(chan struct{})
+	var hanging int32
+	b.dialFn = func() error {
+		if atomic.LoadInt32(&hanging) == 1 {
+			<-hang
+		}
+		return nil
+	}
+	config := b.config("dial", 2, 1)
+	config.DialTimeout = time.Millisecond * 20
+	config.QueueTimeout = time.Millisecond
+	p := newTestPool(t, config)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	atomic.StoreInt32(&hanging, 1)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := p.GetContext(context.Background())
+		result <- err
+	}()
+	waitFor(t, "dial in flight", func() bool { return atomic.LoadInt32(&p.dialsInFlight) == 1 })
+
+	if err := p.Put(held); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	select {
+	case err := <-result:
+		if err != context.DeadlineExceeded {
+			t.Fatalf("Get on a hung dial returned %v, want DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Get did not give up on the hung dial")
+	}
+	if got := p.TotalConns(); got != 2 {
+		t.Fatalf("TotalConns is %v while the abandoned dial is in flight, want 2", got)
+	}
+
+	close(hang)
+	waitFor(t, "abandoned dial pooled", func() bool { return p.alivePool.Len() == 2 })
+	if got := p.TotalConns(); got != 2 {
+		t.Fatalf("TotalConns is %v after the abandoned dial landed, want 2", got)
+	}
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	}
+}
+
+func TestAbandonedDialLandingAfterStopClosesWithoutLock(t *testing.T) {
+
+	b, hanging, hang := hangingBackend()
+	config := b.config("dial", 2, 0)
+	config.DialTimeout = time.Millisecond * 20
+	hung, unblock := hangFirstClose(b, &config)
+	p := newTestPool(t, config)
+	t.Cleanup(unblock)
+
+	atomic.StoreInt32(hanging, 1)
+	if _, err := p.Get(); err != context.DeadlineExceeded {
+		t.Fatalf("Get on a hung dial returned %v, want DeadlineExceeded", err)
+	}
+	p.Release()
+	close(hang)
+	waitUnlocked(t, p, hung)
+}
+
+func TestSlowDialDoesNotBlockPut(t *testing.T) {
+
+	b, hanging, hang := hangingBackend()
+	p := newTestPool(t, b.config("dial", 2, 1))
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	atomic.StoreInt32(hanging, 1)
+
+	dialed := make(chan error, 1)
+	go func() {
+		connection, err := p.Get()
+		if err == nil {
+			p.Put(connection)
+		}
+		dialed <- err
+	}()
+	putDuring(t, p, held, hang)
+
+	close(hang)
+	if err := <-dialed; err != nil {
+		t.Fatalf("Get on the slow dial: %v", err)
+	}
+}
+
+func TestSlowCloseDoesNotBlockGet(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		initial int
+		setup   func(config *Config)
+		close   func(t *testing.T, p *ThriftClientPool)
+	}{
+		{"retire on Put", 1, func(config *Config) { config.MaxConnLifetime = time.Millisecond * 20 }, func(t *testing.T, p *ThriftClientPool) {
+			connection, err := p.Get()
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			time.Sleep(time.Millisecond * 30)
+			go p.Put(connection)
+		}},
+		{"maintenance pass", 2, func(config *Config) { config.MaxIdleTime = time.Millisecond * 20 }, func(t *testing.T, p *ThriftClientPool) {
+			time.Sleep(time.Millisecond * 30)
+			go p.maintenancePass()
+		}},
+		{"Resize", 3, func(config *Config) {}, func(t *testing.T, p *ThriftClientPool) {
+			go p.Resize(2)
+		}},
+	}
+
+	for _, c := range cases {
+		b := &fakeBackend{}
+		started, release := make(chan struct{}), make(chan struct{})
+		var blocked int32
+		config := b.config("slowclose", 3, c.initial)
+		config.Close = func(connection interface{}) error {
+			if atomic.CompareAndSwapInt32(&blocked, 0, 1) {
+				close(started)
+				<-release
+			}
+			return b.close(connection)
+		}
+		c.setup(&config)
+		p := newTestPool(t, config)
+		// runs before the Release of newTestPool, a failed case must not hang it.
+		var unblock sync.Once
+		t.Cleanup(func() { unblock.Do(func() { close(release) }) })
+
+		c.close(t, p)
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("%v: Close was not called", c.name)
+		}
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := p.Get()
+			p.TotalConns()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%v: Get beside a blocked Close: %v", c.name, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v: Get waited for a blocked Close", c.name)
+		}
+		unblock.Do(func() { close(release) })
+	}
+}
+
+func TestOnDialSetsUpEveryDial(t *testing.T) {
+
+	b := &fakeBackend{}
+	var calls, failing int32
+	tags := make(chan string, 16)
+	config := b.config("ondial", 3, 1)
+	config.DialRetryCount = 1
+	config.OnDial = func(tag string, connection interface{}) error {
+		atomic.AddInt32(&calls, 1)
+		tags <- tag
+		if atomic.LoadInt32(&failing) == 1 {
+			return errFakeDown
+		}
+		return nil
+	}
+	p := newTestPool(t, config)
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("OnDial ran %v times for the initial connection, want 1", got)
+	}
+
+	idle, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	dialed, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("OnDial ran %v times after a dialing Get, want 2", got)
+	}
+	if tag := <-tags; tag != "ondial" {
+		t.Fatalf("OnDial got tag %q, want the pool name", tag)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	if _, err := p.Get(); err == nil {
+		t.Fatalf("Get succeeded while OnDial failed")
+	}
+	if closes := b.closeCount(); closes != b.dialCount()-2 {
+		t.Fatalf("OnDial failures closed %v of %v connections", closes, b.dialCount()-2)
+	}
+	if retry := len(p.retryPool()); retry != 1 {
+		t.Fatalf("failed OnDial left %v retry slots, want 1", retry)
+	}
+
+	// the retry loop runs OnDial as well.
+	atomic.StoreInt32(&failing, 0)
+	p.retryPass()
+	if got := atomic.LoadInt32(&calls); got != 4 || p.alivePool.Len() != 1 {
+		t.Fatalf("retry dial ran OnDial %v times and pooled %v connections, want 4 and 1", got, p.alivePool.Len())
+	}
+	p.Put(idle)
+	p.Put(dialed)
+}
+
+func TestNilConnectionFromDialIsAFailure(t *testing.T) {
+
+	b := &fakeBackend{}
+	var returnNil int32 = 1
+	config := b.config("nildial", 2, 1)
+	config.DialRetryCount = 1
+	config.Dial = func(name, address, port string) (interface{}, error) {
+		if atomic.LoadInt32(&returnNil) == 1 {
+			return nil, nil
+		}
+		return b.dial(name, address, port)
+	}
+	p := newTestPool(t, config)
+	if idle, retry := p.alivePool.Len(), len(p.retryPool()); idle != 0 || retry != 1 {
+		t.Fatalf("nil initial connection left %v idle and %v retry slots, want 0 and 1", idle, retry)
+	}
+
+	connection, err := p.Get()
+	if err == nil || connection != nil {
+		t.Fatalf("Get on a nil dial returned %v, %v", connection, err)
+	}
+	if idle, retry := p.alivePool.Len(), len(p.retryPool()); idle != 0 || retry != 2 {
+		t.Fatalf("nil dial from Get left %v idle and %v retry slots, want 0 and 2", idle, retry)
+	}
+
+	atomic.StoreInt32(&returnNil, 0)
+	if !p.retryPass() {
+		t.Fatalf("retry pass failed once Dial returned connections")
+	}
+	connection, err = p.Get()
+	if err != nil || connection == nil {
+		t.Fatalf("Get after recovery returned %v, %v", connection, err)
+	}
+	p.Put(connection)
+}
+
+func TestCoalesceDialsWaitsForTheLastSlot(t *testing.T) {
+
+	b, hanging, hang := hangingBackend()
+	config := b.config("coalesce", 2, 1)
+	config.CoalesceDials = true
+	config.QueueTimeout = time.Millisecond
+	config.CreateNewInterval = time.Second
+	p := newTestPool(t, config)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	atomic.StoreInt32(hanging, 1)
+	dialing := make(chan error, 1)
+	go func() {
+		connection, err := p.Get()
+		if err == nil {
+			p.Put(connection)
+		}
+		dialing <- err
+	}()
+	waitFor(t, "dial of the last slot", func() bool { return atomic.LoadInt32(&p.dialsInFlight) == 1 })
+
+	// the burst waits for the dial in flight instead of failing on a full pool.
+	burst := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			connection, err := p.Get()
+			if err == nil {
+				p.Put(connection)
+			}
+			burst <- err
+		}()
+	}
+	waitFor(t, "the burst to wait", func() bool { return atomic.LoadInt32(&p.pendingGets) == 4 })
+	// let the queue wait of the burst run out so it coalesces on the dial.
+	time.Sleep(config.QueueTimeout * 20)
+
+	p.Put(held)
+	close(hang)
+	if err := <-dialing; err != nil {
+		t.Fatalf("Get dialing the last slot: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := <-burst; err != nil {
+			t.Fatalf("coalesced Get failed: %v", err)
+		}
+	}
+	if dials := b.dialCount(); dials != 2 {
+		t.Fatalf("burst made %v dials, want the initial one and the last slot", dials)
+	}
+}
+
+func TestCancelledGetLeavesNoRetrySlot(t *testing.T) {
+
+	b := &fakeBackend{}
+	release := make(chan error)
+	b.dialFn = func() error { return <-release }
+	p := newTestPool(t, b.config("cancel", 2, 0))
+
+	for _, dialErr := range []error{errFakeDown, nil} {
+		ctx, cancel := context.WithCancel(context.Background())
+		result := make(chan error, 1)
+		go func() {
+			_, err := p.GetContext(ctx)
+			result <- err
+		}()
+		waitFor(t, "dial in flight", func() bool { return atomic.LoadInt32(&p.dialsInFlight) == 1 })
+
+		cancel()
+		if err := <-result; err != context.Canceled {
+			t.Fatalf("Get cancelled mid-dial returned %v, want Canceled", err)
+		}
+		release <- dialErr
+		waitFor(t, "abandoned dial to land", func() bool { return atomic.LoadInt32(&p.dialsInFlight) == 0 })
+		if retry := len(p.retryPool()); retry != 0 {
+			t.Fatalf("Get cancelled mid-dial, dial error %v, left %v retry slots", dialErr, retry)
+		}
+	}
+	if idle := p.alivePool.Len(); idle != 1 {
+		t.Fatalf("abandoned successful dial left %v idle connections, want 1", idle)
+	}
+}
+
+func TestConnectionIsNotPooledUntilReady(t *testing.T) {
+
+	b := &fakeBackend{}
+	var ready, checks, prepared int32
+	config := b.config("ready", 1, 0)
+	config.ReadyTimeout = time.Second
+	config.OnDial = func(tag string, connection interface{}) error {
+		atomic.StoreInt32(&prepared, 1)
+		return nil
+	}
+	config.IsReady = func(connection interface{}) bool {
+		if atomic.LoadInt32(&prepared) == 0 {
+			t.Errorf("IsReady ran before OnDial")
+		}
+		atomic.AddInt32(&checks, 1)
+		return atomic.LoadInt32(&ready) == 1
+	}
+	p := newTestPool(t, config)
+
+	result := make(chan error, 1)
+	go func() {
+		connection, err := p.Get()
+		if err == nil {
+			p.Put(connection)
+		}
+		result <- err
+	}()
+	waitFor(t, "readiness to be polled", func() bool { return atomic.LoadInt32(&checks) > 2 })
+	select {
+	case err := <-result:
+		t.Fatalf("Get returned %v before the connection was ready", err)
+	default:
+	}
+	if got := p.alivePool.Len(); got != 0 {
+		t.Fatalf("%v connections pooled before they were ready", got)
+	}
+
+	atomic.StoreInt32(&ready, 1)
+	if err := <-result; err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got := p.alivePool.Len(); got != 1 {
+		t.Fatalf("%v connections pooled once ready, want 1", got)
+	}
+}
+
+func TestNeverReadyConnectionIsClosed(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("ready", 1, 0)
+	config.DialRetryCount = 1
+	config.ReadyTimeout = time.Millisecond * 30
+	config.IsReady = func(connection interface{}) bool { return false }
+	p := newTestPool(t, config)
+
+	if _, err := p.Get(); !errors.Is(err, ErrNotReady) {
+		t.Fatalf("Get on a never ready connection returned %v, want ErrNotReady", err)
+	}
+	if b.closeCount() != b.dialCount() {
+		t.Fatalf("%v of %v never ready connections closed", b.closeCount(), b.dialCount())
+	}
+	if got := p.alivePool.Len(); got != 0 {
+		t.Fatalf("%v never ready connections pooled", got)
+	}
+}
@@ -0,0 +1,84 @@
+package thrift_clientpool
+
+import (
+	"sort"
+	"time"
+)
+
+// dialSamples is how many recent dial durations P95 is computed from.
+const dialSamples = 128
+
+// DialStats summarizes the durations of Dial calls with one outcome, a slow
+// success points to a slow backend, fast failures to one refusing connections.
+type DialStats struct {
+	Count int64
+	Total time.Duration
+	Min   time.Duration
+	Max   time.Duration
+	P95   time.Duration
+}
+
+func (s DialStats) Avg() time.Duration {
+
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Total / time.Duration(s.Count)
+}
+
+type dialTimings struct {
+	stats   DialStats
+	samples []time.Duration
+	next    int
+}
+
+func (t *dialTimings) observe(duration time.Duration) {
+
+	if t.stats.Count == 0 || duration < t.stats.Min {
+		t.stats.Min = duration
+	}
+	if duration > t.stats.Max {
+		t.stats.Max = duration
+	}
+	t.stats.Count++
+	t.stats.Total += duration
+
+	if len(t.samples) < dialSamples {
+		t.samples = append(t.samples, duration)
+	} else {
+		t.samples[t.next] = duration
+		t.next = (t.next + 1) % dialSamples
+	}
+}
+
+func (t *dialTimings) snapshot() DialStats {
+
+	s := t.stats
+	if len(t.samples) > 0 {
+		sorted := append([]time.Duration(nil), t.samples...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		s.P95 = sorted[(len(sorted)*95+99)/100-1]
+	}
+	return s
+}
+
+func (p *ThriftClientPool) observeDial(duration time.Duration, err error) {
+
+	p.dialTimeSync.Lock()
+	defer p.dialTimeSync.Unlock()
+
+	if err == nil {
+		p.dialSuccess.observe(duration)
+	} else {
+		p.dialFailure.observe(duration)
+	}
+}
+
+// dialSnapshot returns the timings of successful and failed dials.
+func (p *ThriftClientPool) dialSnapshot() (success, failure DialStats) {
+
+	p.dialTimeSync.Lock()
+	defer p.dialTimeSync.Unlock()
+
+	return p.dialSuccess.snapshot(), p.dialFailure.snapshot()
+}
@@ -0,0 +1,58 @@
+package thrift_clientpool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDialTimingsSummarizeDurations(t *testing.T) {
+
+	timings := dialTimings{}
+	for i := 1; i <= 100; i++ {
+		timings.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	s := timings.snapshot()
+	if s.Count != 100 || s.Min != time.Millisecond || s.Max != 100*time.Millisecond {
+		t.Fatalf("snapshot = %+v, want 100 dials from 1ms to 100ms", s)
+	}
+	if avg := s.Avg(); avg != 50500*time.Microsecond {
+		t.Fatalf("Avg = %v, want 50.5ms", avg)
+	}
+	if s.P95 != 95*time.Millisecond {
+		t.Fatalf("P95 = %v, want 95ms", s.P95)
+	}
+}
+
+func TestDialTimingsKeepRecentSamples(t *testing.T) {
+
+	timings := dialTimings{}
+	for i := 0; i < dialSamples; i++ {
+		timings.observe(time.Second)
+	}
+	for i := 0; i < dialSamples; i++ {
+		timings.observe(time.Millisecond)
+	}
+
+	s := timings.snapshot()
+	if s.P95 != time.Millisecond {
+		t.Fatalf("P95 = %v, want 1ms once the slow samples rotated out", s.P95)
+	}
+	if s.Max != time.Second || s.Count != 2*dialSamples {
+		t.Fatalf("snapshot = %+v, want Max 1s over %v dials", s, 2*dialSamples)
+	}
+}
+
+func TestStatsCountDialOutcomes(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("dialstats", 2, 2))
+
+	b.setDown(true)
+	p.dialConnection()
+
+	stats := p.Stats()
+	if stats.DialSuccess.Count != 2 || stats.DialFailure.Count != 1 {
+		t.Fatalf("dial stats = %+v / %+v, want 2 successes and 1 failure", stats.DialSuccess, stats.DialFailure)
+	}
+}
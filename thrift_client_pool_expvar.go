@@ -0,0 +1,68 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"sync"
+)
+
+// ExpvarPrefix is prepended to the pool name to form the expvar variable name.
+var ExpvarPrefix = "thrift_clientpool."
+
+var (
+	// expvar can not remove a variable, so a published one reads the pool of
+	// its name from expvarPools, Release deletes the entry again.
+	expvarPools     = map[string]*ThriftClientPool{}
+	expvarPublished = map[string]bool{}
+	expvarSync      sync.Mutex
+)
+
+// PublishExpvar exports Stats as the expvar variable ExpvarPrefix + name, so it
+// shows up under /debug/vars. One pool at a time publishes a name, once it is
+// released the variable reads null until another pool publishes the name.
+func (p *ThriftClientPool) PublishExpvar() error {
+
+	expvarSync.Lock()
+	defer expvarSync.Unlock()
+
+	name := ExpvarPrefix + p.Name()
+	if _, ok := expvarPools[name]; ok || (!expvarPublished[name] && expvar.Get(name) != nil) {
+		return errors.New(fmt.Sprintf("expvar %v already published.", name))
+	}
+
+	if !expvarPublished[name] {
+		expvar.Publish(name, expvar.Func(func() interface{} {
+			return expvarStats(name)
+		}))
+		expvarPublished[name] = true
+	}
+	expvarPools[name] = p
+	return nil
+}
+
+// expvarStats returns the Stats of the pool publishing name, nil when none does.
+func expvarStats(name string) interface{} {
+
+	expvarSync.Lock()
+	p, ok := expvarPools[name]
+	expvarSync.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return p.Stats()
+}
+
+// unpublishExpvar detaches p from the expvar variable it published, if any.
+func (p *ThriftClientPool) unpublishExpvar() {
+
+	expvarSync.Lock()
+	defer expvarSync.Unlock()
+
+	for name, pool := range expvarPools {
+		if pool == p {
+			delete(expvarPools, name)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package thrift_clientpool
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+)
+
+func TestReleaseUnpublishesExpvar(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("expvarrelease", 2, 1))
+	if err := p.PublishExpvar(); err != nil {
+		t.Fatalf("PublishExpvar: %v", err)
+	}
+	name := ExpvarPrefix + "expvarrelease"
+
+	stats := Stats{}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &stats); err != nil {
+		t.Fatalf("expvar %v: %v", name, err)
+	}
+	if stats.Name != "expvarrelease" {
+		t.Fatalf("expvar Name = %q, want expvarrelease", stats.Name)
+	}
+
+	next := newTestPool(t, b.config("expvarrelease", 2, 1))
+	if err := next.PublishExpvar(); err == nil {
+		t.Fatal("PublishExpvar of a published name succeeded")
+	}
+
+	p.Release()
+	if value := expvar.Get(name).String(); value != "null" {
+		t.Fatalf("expvar after Release = %v, want null", value)
+	}
+	if err := next.PublishExpvar(); err != nil {
+		t.Fatalf("PublishExpvar after Release: %v", err)
+	}
+	next.Release()
+	if value := expvar.Get(name).String(); value != "null" {
+		t.Fatalf("expvar after the second Release = %v, want null", value)
+	}
+}
+
+func TestPublishExpvarRefusesForeignVariable(t *testing.T) {
+
+	if expvar.Get(ExpvarPrefix+"expvarforeign") == nil {
+		expvar.NewInt(ExpvarPrefix + "expvarforeign")
+	}
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("expvarforeign", 2, 1))
+	if err := p.PublishExpvar(); err == nil {
+		t.Fatal("PublishExpvar over a variable published elsewhere succeeded")
+	}
+}
@@ -28,7 +28,9 @@ func (factory *ThriftPoolFactory) NewPoolFullParam(poolName, address, port strin
 	if err != nil {
 		return err
 	}
-	tmp.KeepAliveInterval = time.Second
+	tmp.update(func(c *Config) {
+		c.KeepAliveInterval = time.Second
+	})
 
 	factory.pools[poolName] = tmp
 	tmp.Start()
@@ -53,6 +55,8 @@ func (factory *ThriftPoolFactory) PutConnection(poolName string, connection inte
 
 func (factory *ThriftPoolFactory) SetKeepAliveInterval(poolName string, interval time.Duration) {
 	if p, ok := factory.pools[poolName]; ok {
-		p.KeepAliveInterval = interval
+		p.update(func(c *Config) {
+			c.KeepAliveInterval = interval
+		})
 	}
 }
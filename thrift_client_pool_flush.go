@@ -0,0 +1,53 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Flush closes every idle connection and returns how many it closed, e.g.
+// after a credential rotation. The pool keeps running and dials again on
+// demand, connections borrowed or being probed are closed when they come back.
+func (p *ThriftClientPool) Flush() (int, error) {
+
+	if !p.serving() {
+		return 0, ErrPoolNotRunning
+	}
+
+	p.sync.Lock()
+	connections := []interface{}{}
+	for connection, ok := p.popAlive(); ok; connection, ok = p.popAlive() {
+		connections = append(connections, connection)
+	}
+	connections = append(connections, p.takeCooling()...)
+
+	p.connsSync.Lock()
+	for _, conn := range p.conns {
+		conn.flushed = true
+	}
+	p.connsSync.Unlock()
+	p.unlock()
+
+	failures := []string{}
+	for _, connection := range connections {
+		if err := p.discardConnection(connection); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	p.logger().Infof("Flushed %v idle connections of %v.", len(connections), p.Name())
+	if len(failures) > 0 {
+		return len(connections), errors.New(fmt.Sprintf("Flush connection error: %v.", failures))
+	}
+	return len(connections), nil
+}
+
+// flushed reports whether connection was out of the idle store during a Flush.
+func (p *ThriftClientPool) flushed(connection interface{}) bool {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	conn, ok := p.conns[connection]
+	return ok && conn.flushed
+}
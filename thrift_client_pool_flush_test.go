@@ -0,0 +1,51 @@
+package thrift_clientpool
+
+import (
+	"testing"
+)
+
+func TestFlushClosesIdleAndReturnedConnections(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("flush", 2, 2))
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	flushed, err := p.Flush()
+	if err != nil || flushed != 1 {
+		t.Fatalf("Flush = %v, %v, want 1 idle connection closed", flushed, err)
+	}
+
+	p.Put(held)
+	if !held.(*fakeConn).isClosed() {
+		t.Fatal("connection borrowed during Flush was kept on return")
+	}
+	if total := p.TotalConns(); total != 0 {
+		t.Fatalf("TotalConns after Flush = %v, want 0", total)
+	}
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get after Flush: %v", err)
+	}
+	if connection.(*fakeConn).isClosed() {
+		t.Fatal("Get after Flush returned a closed connection")
+	}
+	p.Put(connection)
+	if total := p.TotalConns(); total != 1 {
+		t.Fatalf("TotalConns after a new dial = %v, want 1", total)
+	}
+}
+
+func TestFlushOnReleasedPool(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("flushreleased", 1, 1))
+	p.Release()
+
+	if _, err := p.Flush(); err != ErrPoolNotRunning {
+		t.Fatalf("Flush on a released pool returned %v, want ErrPoolNotRunning", err)
+	}
+}
@@ -0,0 +1,211 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetExistingStartsLazyPool(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("existing", 2, 1)
+	config.LazyStart = true
+	p := newTestPool(t, config)
+
+	connection, err := p.GetExisting()
+	if err != nil {
+		t.Fatalf("GetExisting on a lazy pool: %v", err)
+	}
+	p.Put(connection)
+}
+
+func TestGetExistingIsAdmitted(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("existing", 2, 2))
+	p.SetConcurrencyLimit(1)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if _, err := p.GetExisting(); err != ErrNoIdleConnection {
+		t.Fatalf("GetExisting over the concurrency limit returned %v, want ErrNoIdleConnection", err)
+	}
+
+	p.Put(held)
+	connection, err := p.GetExisting()
+	if err != nil {
+		t.Fatalf("GetExisting under the concurrency limit: %v", err)
+	}
+	p.Put(connection)
+}
+
+func TestBorrowProbeIsBoundByProbeTimeout(t *testing.T) {
+
+	b := &fakeBackend{}
+	hang := make(chan struct{})
+	defer close(hang)
+	config := b.config("probe", 2, 1)
+	config.ProbeTimeout = time.Millisecond * 20
+	config.BorrowProbe = func(connection interface{}) error {
+		<-hang
+		return nil
+	}
+	p := newTestPool(t, config)
+
+	start := time.Now()
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if waited := time.Since(start); waited > time.Millisecond*500 {
+		t.Fatalf("Get waited %v on a hung BorrowProbe", waited)
+	}
+	if connection.(*fakeConn).id == 1 {
+		t.Fatalf("Get handed out the connection whose BorrowProbe timed out")
+	}
+	p.Put(connection)
+}
+
+func TestFairQueueGetObservesWholeWait(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("fairwait", 1, 1)
+	config.FairQueue = true
+	config.CreateNewInterval = time.Second
+	p := newTestPool(t, config)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.ResetMaxGetWait()
+
+	go func() {
+		time.Sleep(time.Millisecond * 30)
+		p.Put(held)
+	}()
+	connection, err := p.GetContext(context.Background())
+	if err != nil {
+		t.Fatalf("GetContext while queued: %v", err)
+	}
+	p.Put(connection)
+
+	if wait := p.ResetMaxGetWait(); wait < time.Millisecond*30 {
+		t.Fatalf("MaxGetWait = %v, want at least 30ms", wait)
+	}
+	if wait := p.ResetMaxGetWait(); wait != 0 {
+		t.Fatalf("MaxGetWait after reset = %v, want 0", wait)
+	}
+}
+
+func TestFailFastReturnsDialFailedError(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("exhausted", 2, 0)
+	config.QueueTimeout = time.Millisecond
+	config.OnDialExhausted = FailFast
+	p := newTestPool(t, config)
+	b.setDown(true)
+
+	_, err := p.Get()
+	if _, ok := err.(*DialFailedError); !ok || !errors.Is(err, ErrDialFailed) {
+		t.Fatalf("Get on a down backend returned %v, want a DialFailedError", err)
+	}
+	if !errors.Is(err, errFakeDown) {
+		t.Fatalf("DialFailedError %v does not unwrap to the dial error", err)
+	}
+}
+
+func TestOnFirstUsePrimesOnceAndReplacesFailures(t *testing.T) {
+
+	b := &fakeBackend{}
+	var primed []int64
+	var primedSync sync.Mutex
+	config := b.config("firstuse", 2, 1)
+	config.OnFirstUse = func(connection interface{}) error {
+		id := connection.(*fakeConn).id
+		primedSync.Lock()
+		primed = append(primed, id)
+		primedSync.Unlock()
+		if id == 1 {
+			return errFakeDown
+		}
+		return nil
+	}
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if id := connection.(*fakeConn).id; id != 2 {
+		t.Fatalf("Get returned connection %v, want the one dialed after priming failed", id)
+	}
+	if closes := b.closeCount(); closes != 1 {
+		t.Fatalf("closes after a failed OnFirstUse = %v, want 1", closes)
+	}
+
+	p.Put(connection)
+	again, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if again != connection {
+		t.Fatal("second Get did not reuse the primed connection")
+	}
+	p.Put(again)
+
+	primedSync.Lock()
+	defer primedSync.Unlock()
+	if !reflect.DeepEqual(primed, []int64{1, 2}) {
+		t.Fatalf("OnFirstUse ran on %v, want once on 1 and once on 2", primed)
+	}
+}
+
+// deadlineConn records every deadline SetDeadline sets on it.
+type deadlineConn struct {
+	fakeConn
+	deadlines []time.Time
+}
+
+func TestBorrowDeadlineIsSetOnGetAndClearedOnPut(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("deadline", 1, 0)
+	config.Dial = func(name, address, port string) (interface{}, error) {
+		return &deadlineConn{}, nil
+	}
+	config.Close = func(connection interface{}) error { return nil }
+	config.KeepAlive = func(connection interface{}) error { return nil }
+	config.BorrowDeadline = time.Minute
+	config.SetDeadline = func(connection interface{}, at time.Time) error {
+		c := connection.(*deadlineConn)
+		c.deadlines = append(c.deadlines, at)
+		return nil
+	}
+	p := newTestPool(t, config)
+
+	before := time.Now()
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	after := time.Now()
+	c := connection.(*deadlineConn)
+	if len(c.deadlines) != 1 || c.deadlines[0].Before(before.Add(time.Minute)) || c.deadlines[0].After(after.Add(time.Minute)) {
+		t.Fatalf("deadlines after Get = %v, want one at now+BorrowDeadline", c.deadlines)
+	}
+
+	if err := p.Put(connection); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if len(c.deadlines) != 2 || !c.deadlines[1].IsZero() {
+		t.Fatalf("deadlines after Put = %v, want the zero time last", c.deadlines)
+	}
+}
@@ -0,0 +1,128 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ExportAll hands the idle connections over to a successor process, e.g. the
+// file descriptors for a hot restart. Every connection Export succeeds on leaves
+// the pool without being closed, the others stay idle. Export runs without
+// p.sync, the connections stay counted in probing meanwhile.
+func (p *ThriftClientPool) ExportAll() ([][]byte, error) {
+
+	export := p.cfg().Export
+	if export == nil {
+		return nil, errors.New("export not specified.")
+	}
+
+	p.sync.Lock()
+	connections := []interface{}{}
+	for {
+		atomic.AddInt32(&p.probing, 1)
+		connection, ok := p.popAlive()
+		if !ok {
+			atomic.AddInt32(&p.probing, -1)
+			break
+		}
+		connections = append(connections, connection)
+	}
+	p.unlock()
+
+	payloads := [][]byte{}
+	failures := []string{}
+	kept := []interface{}{}
+	for _, connection := range connections {
+		payload, err := p.callExport(export, connection)
+		if err != nil {
+			failures = append(failures, err.Error())
+			kept = append(kept, connection)
+			continue
+		}
+
+		payloads = append(payloads, payload)
+		p.untrackConn(connection)
+		atomic.AddInt32(&p.probing, -1)
+	}
+	p.restoreRefused(kept)
+
+	p.logger().Infof("Exported %v idle connections of %v.", len(payloads), p.Name())
+	if len(failures) > 0 {
+		return payloads, errors.New(fmt.Sprintf("Export connection error: %v.", failures))
+	}
+	return payloads, nil
+}
+
+// ImportAll rebuilds connections from the payloads of ExportAll as idle
+// connections, until the pool or its CapacityBudget is full, and returns how
+// many it took. Like a dial of Get, every import reserves its slot against the
+// dial limit in dialsInFlight and Import runs without p.sync.
+func (p *ThriftClientPool) ImportAll(payloads [][]byte) (int, error) {
+
+	imp := p.cfg().Import
+	if imp == nil {
+		return 0, errors.New("import not specified.")
+	}
+
+	if !p.serving() {
+		return 0, ErrPoolNotRunning
+	}
+
+	imported := 0
+	failures := []string{}
+	for _, payload := range payloads {
+		p.sync.Lock()
+		if p.totalConnCount() >= p.dialLimit() {
+			p.unlock()
+			failures = append(failures, "pool is full.")
+			break
+		}
+		atomic.AddInt32(&p.dialsInFlight, 1)
+		p.unlock()
+
+		connection, err := p.callImport(imp, payload)
+		if err == nil && connection == nil {
+			err = ErrNilConnection
+		}
+		if err != nil {
+			atomic.AddInt32(&p.dialsInFlight, -1)
+			failures = append(failures, err.Error())
+			continue
+		}
+
+		p.sync.Lock()
+		if p.stopped() {
+			err = ErrPoolNotRunning
+		} else if err = p.adoptConn(connection, nil); err == nil {
+			p.pushAlive(connection)
+			imported++
+		}
+		atomic.AddInt32(&p.dialsInFlight, -1)
+		p.unlock()
+
+		if err != nil {
+			p.closeConnection(connection)
+			failures = append(failures, err.Error())
+			break
+		}
+	}
+
+	p.logger().Infof("Imported %v connections into %v.", imported, p.Name())
+	if len(failures) > 0 {
+		return imported, errors.New(fmt.Sprintf("Import connection error: %v.", failures))
+	}
+	return imported, nil
+}
+
+func (p *ThriftClientPool) callExport(export func(connection interface{}) ([]byte, error), connection interface{}) (payload []byte, err error) {
+
+	defer p.recoverCallback("Export", &err)
+	return export(connection)
+}
+
+func (p *ThriftClientPool) callImport(imp func(payload []byte) (interface{}, error), payload []byte) (connection interface{}, err error) {
+
+	defer p.recoverCallback("Import", &err)
+	return imp(payload)
+}
@@ -0,0 +1,162 @@
+package thrift_clientpool
+
+import (
+	"testing"
+)
+
+// importConfig is b.config with Export and Import round-tripping fakeConn ids.
+func importConfig(b *fakeBackend, name string, size, initial int) Config {
+
+	config := b.config(name, size, initial)
+	config.Export = func(connection interface{}) ([]byte, error) {
+		return []byte{byte(connection.(*fakeConn).id)}, nil
+	}
+	config.Import = func(payload []byte) (interface{}, error) {
+		return &fakeConn{id: int64(payload[0])}, nil
+	}
+	return config
+}
+
+func TestImportAllRespectsCapacityBudget(t *testing.T) {
+
+	budget := NewCapacityBudget(1)
+	b := &fakeBackend{}
+	newTestPool(t, b.config("holder", 1, 1), WithCapacityBudget(budget))
+	p := newTestPool(t, importConfig(b, "import", 2, 0), WithCapacityBudget(budget))
+
+	imported, err := p.ImportAll([][]byte{{7}})
+	if imported != 0 || err == nil {
+		t.Fatalf("ImportAll over the budget imported %v: %v", imported, err)
+	}
+	if budget.Used() != 1 || p.TotalConns() != 0 {
+		t.Fatalf("budget used %v and pool holds %v after a refused import", budget.Used(), p.TotalConns())
+	}
+	if b.closeCount() != 1 {
+		t.Fatalf("refused import was not closed")
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := importConfig(b, "old", 2, 2)
+	var old *ThriftClientPool
+	exported := 0
+	export := config.Export
+	config.Export = func(connection interface{}) ([]byte, error) {
+		// Export runs without p.sync, the connections not exported yet stay counted.
+		if total := old.TotalConns(); total != 2-exported {
+			t.Errorf("TotalConns during ExportAll = %v, want %v", total, 2-exported)
+		}
+		exported++
+		return export(connection)
+	}
+	old = newTestPool(t, config)
+	successor := newTestPool(t, importConfig(b, "successor", 2, 0))
+	exporting := popAll(old.alivePool)
+	old.restoreIdle(exporting)
+
+	payloads, err := old.ExportAll()
+	if err != nil || len(payloads) != 2 {
+		t.Fatalf("ExportAll = %v payloads: %v", len(payloads), err)
+	}
+	if old.TotalConns() != 0 || old.IsManaged(exporting[0]) || b.closeCount() != 0 {
+		t.Fatalf("old pool holds %v after ExportAll, %v closes", old.TotalConns(), b.closeCount())
+	}
+
+	imported, err := successor.ImportAll(payloads)
+	if err != nil || imported != 2 {
+		t.Fatalf("ImportAll = %v: %v", imported, err)
+	}
+	for _, want := range exporting {
+		connection, err := successor.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if id := connection.(*fakeConn).id; id != want.(*fakeConn).id {
+			t.Fatalf("successor handed out connection %v, want %v", id, want.(*fakeConn).id)
+		}
+	}
+	if b.dialCount() != 2 {
+		t.Fatalf("dials = %v, want only the 2 of the old pool", b.dialCount())
+	}
+}
+
+func TestImportAllStopsAtDialLimit(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, importConfig(b, "import", 3, 0))
+	p.SetSoftMax(1)
+
+	imported, err := p.ImportAll([][]byte{{7}, {8}})
+	if imported != 1 || err == nil {
+		t.Fatalf("ImportAll past the soft max imported %v: %v", imported, err)
+	}
+	if total := p.TotalConns(); total != 1 {
+		t.Fatalf("TotalConns after ImportAll = %v, want 1", total)
+	}
+}
+
+func TestTransferRespectsCapacityBudget(t *testing.T) {
+
+	budget := NewCapacityBudget(1)
+	b := &fakeBackend{}
+	from := newTestPool(t, b.config("from", 1, 1))
+	newTestPool(t, b.config("holder", 1, 1), WithCapacityBudget(budget))
+	to := newTestPool(t, b.config("to", 2, 0), WithCapacityBudget(budget))
+
+	moved, err := from.Transfer(to)
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if moved != 0 || budget.Used() != 1 {
+		t.Fatalf("Transfer moved %v connections into a full budget, used %v", moved, budget.Used())
+	}
+	if from.alivePool.Len() != 1 || to.TotalConns() != 0 {
+		t.Fatalf("source kept %v idle, target holds %v", from.alivePool.Len(), to.TotalConns())
+	}
+}
+
+func TestTransferMovesWithoutRedial(t *testing.T) {
+
+	b := &fakeBackend{}
+	from := newTestPool(t, b.config("from", 3, 3))
+	to := newTestPool(t, b.config("to", 3, 0))
+	moving := popAll(from.alivePool)
+	from.restoreIdle(moving)
+
+	moved, err := from.Transfer(to)
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if moved != 3 || b.dialCount() != 3 {
+		t.Fatalf("Transfer moved %v connections with %v dials, want 3 and the initial 3", moved, b.dialCount())
+	}
+	if from.TotalConns() != 0 || to.alivePool.Len() != 3 {
+		t.Fatalf("after Transfer source holds %v, target has %v idle", from.TotalConns(), to.alivePool.Len())
+	}
+	for _, connection := range moving {
+		if connection.(*fakeConn).isClosed() || !to.IsManaged(connection) || from.IsManaged(connection) {
+			t.Fatalf("connection %v was not handed over as is", connection.(*fakeConn).id)
+		}
+	}
+}
+
+func TestTransferStopsAtTargetSoftMax(t *testing.T) {
+
+	b := &fakeBackend{}
+	from := newTestPool(t, b.config("from", 3, 3))
+	to := newTestPool(t, b.config("to", 3, 0))
+	to.SetSoftMax(1)
+
+	moved, err := from.Transfer(to)
+	if err != nil {
+		t.Fatalf("Transfer: %v", err)
+	}
+	if moved != 1 || to.TotalConns() != 1 || from.alivePool.Len() != 2 {
+		t.Fatalf("Transfer moved %v into a target with SoftMax 1, target holds %v, source kept %v", moved, to.TotalConns(), from.alivePool.Len())
+	}
+	if closes := b.closeCount(); closes != 0 {
+		t.Fatalf("Transfer closed %v connections", closes)
+	}
+}
@@ -0,0 +1,247 @@
+package thrift_clientpool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	errFakeDown   = errors.New("fake backend down.")
+	errFakeClosed = errors.New("fake connection closed.")
+)
+
+type fakeConn struct {
+	id     int64
+	closed int32
+}
+
+func (c *fakeConn) isClosed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+// fakeBackend counts the callbacks a pool makes, keepAliveFn and dialFn
+// replace the default behaviour when set before the pool is built.
+type fakeBackend struct {
+	dials       int64
+	closes      int64
+	keepAlives  int64
+	nextID      int64
+	down        int32
+	dialFn      func() error
+	keepAliveFn func(c *fakeConn) error
+}
+
+func (b *fakeBackend) dial(name, address, port string) (connection interface{}, err error) {
+
+	atomic.AddInt64(&b.dials, 1)
+	if atomic.LoadInt32(&b.down) == 1 {
+		return nil, errFakeDown
+	}
+	if b.dialFn != nil {
+		if err = b.dialFn(); err != nil {
+			return nil, err
+		}
+	}
+	return &fakeConn{id: atomic.AddInt64(&b.nextID, 1)}, nil
+}
+
+func (b *fakeBackend) close(connection interface{}) (err error) {
+
+	atomic.AddInt64(&b.closes, 1)
+	if !atomic.CompareAndSwapInt32(&connection.(*fakeConn).closed, 0, 1) {
+		return errFakeClosed
+	}
+	return nil
+}
+
+func (b *fakeBackend) keepAlive(connection interface{}) (err error) {
+
+	atomic.AddInt64(&b.keepAlives, 1)
+	c := connection.(*fakeConn)
+	if c.isClosed() {
+		return errFakeClosed
+	}
+	if b.keepAliveFn != nil {
+		return b.keepAliveFn(c)
+	}
+	return nil
+}
+
+func (b *fakeBackend) setDown(down bool) {
+
+	if down {
+		atomic.StoreInt32(&b.down, 1)
+	} else {
+		atomic.StoreInt32(&b.down, 0)
+	}
+}
+
+func (b *fakeBackend) dialCount() int64 {
+	return atomic.LoadInt64(&b.dials)
+}
+
+func (b *fakeBackend) closeCount() int64 {
+	return atomic.LoadInt64(&b.closes)
+}
+
+func (b *fakeBackend) keepAliveCount() int64 {
+	return atomic.LoadInt64(&b.keepAlives)
+}
+
+// config returns a Config dialing b with intervals short enough for tests, the
+// background loops stay quiet unless a test shortens them further.
+func (b *fakeBackend) config(name string, size, initial int) Config {
+
+	config := DefaultConfig(name, "127.0.0.1", "0")
+	config.Dial = b.dial
+	config.Close = b.close
+	config.KeepAlive = b.keepAlive
+	config.MaxPoolSize = size
+	config.InitialPoolSize = initial
+	config.KeepAliveInterval = time.Hour
+	config.DialRetryInterval = time.Hour
+	config.CreateNewInterval = time.Millisecond * 10
+	config.Logger = NopLogger
+	return config
+}
+
+// newTestPool builds and starts a pool from config, it is released when the test ends.
+func newTestPool(t testing.TB, config Config, opts ...Option) *ThriftClientPool {
+
+	t.Helper()
+	p, err := NewFromConfig(config, opts...)
+	if err != nil {
+		t.Fatalf("NewFromConfig: %v", err)
+	}
+	p.Start()
+	t.Cleanup(p.Release)
+	return p
+}
+
+// waitFor polls cond until it holds or a second passed.
+func waitFor(t testing.TB, what string, cond func() bool) {
+
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// hangFirstClose makes the first Close of config block until unblock, hung is
+// closed once it blocks. Register unblock with t.Cleanup after the pool is
+// built, so it runs before the Release of newTestPool.
+func hangFirstClose(b *fakeBackend, config *Config) (hung <-chan struct{}, unblock func()) {
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var blocked int32
+	var once sync.Once
+	config.Close = func(connection interface{}) error {
+		if atomic.CompareAndSwapInt32(&blocked, 0, 1) {
+			close(started)
+			<-release
+		}
+		return b.close(connection)
+	}
+	return started, func() { once.Do(func() { close(release) }) }
+}
+
+// waitUnlocked fails unless the pool lock can be taken within a second once
+// hung is closed.
+func waitUnlocked(t testing.TB, p *ThriftClientPool, hung <-chan struct{}) {
+
+	t.Helper()
+	select {
+	case <-hung:
+	case <-time.After(time.Second):
+		t.Fatalf("Close was not called")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		p.TotalConns()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("a hung Close holds the pool lock")
+	}
+}
+
+// recordLogger keeps the messages logged at level and above.
+type recordLogger struct {
+	level    LogLevel
+	messages []string
+	sync     sync.Mutex
+}
+
+func (l *recordLogger) Debugf(format string, v ...interface{}) { l.record(LevelDebug, format, v...) }
+func (l *recordLogger) Infof(format string, v ...interface{})  { l.record(LevelInfo, format, v...) }
+func (l *recordLogger) Warnf(format string, v ...interface{})  { l.record(LevelWarn, format, v...) }
+func (l *recordLogger) Errorf(format string, v ...interface{}) { l.record(LevelError, format, v...) }
+
+func (l *recordLogger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
+func (l *recordLogger) record(level LogLevel, format string, v ...interface{}) {
+
+	if level < l.level {
+		return
+	}
+	l.sync.Lock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+	l.sync.Unlock()
+}
+
+// logged reports whether a message containing text was logged.
+func (l *recordLogger) logged(text string) bool {
+
+	l.sync.Lock()
+	defer l.sync.Unlock()
+
+	for _, message := range l.messages {
+		if strings.Contains(message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// loopGoroutines counts the running goroutines of pool name by their loop
+// pprof label.
+func loopGoroutines(name string) map[string]int {
+
+	var dump bytes.Buffer
+	pprof.Lookup("goroutine").WriteTo(&dump, 1)
+
+	loops := map[string]int{}
+	pool := fmt.Sprintf(`"pool":"%v"`, name)
+	for _, record := range strings.Split(dump.String(), "\n\n") {
+		var count int
+		if _, err := fmt.Sscanf(record, "%d @", &count); err != nil {
+			continue
+		}
+		for _, line := range strings.Split(record, "\n") {
+			if !strings.HasPrefix(line, "# labels: ") || !strings.Contains(line, pool) {
+				continue
+			}
+			if i := strings.Index(line, `"loop":"`); i >= 0 {
+				loop := line[i+len(`"loop":"`):]
+				loops[loop[:strings.Index(loop, `"`)]] += count
+			}
+		}
+	}
+	return loops
+}
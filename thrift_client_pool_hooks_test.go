@@ -0,0 +1,47 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPanickingHooksAreRecovered(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("hooks", 1, 1)
+	config.OnEvict = func(tag string, conn *Conn) { panic("OnEvict") }
+	config.OnConnState = func(id uint64, from, to ConnState) { panic("OnConnState") }
+	config.OnSaturated = func() { panic("OnSaturated") }
+	config.OnDesaturated = func() { panic("OnDesaturated") }
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := p.PutErr(connection, errors.New("rpc failed.")); err != nil {
+		t.Fatalf("PutErr: %v", err)
+	}
+	if !connection.(*fakeConn).isClosed() {
+		t.Fatalf("connection was not evicted after the hooks panicked")
+	}
+	if p.IsManaged(connection) {
+		t.Fatalf("evicted connection is still managed")
+	}
+}
+
+func TestPanickingOnReleaseIsRecovered(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("release", 2, 2)
+	config.OnRelease = func(tag string, connection interface{}) { panic("OnRelease") }
+	p := newTestPool(t, config)
+
+	if err := p.ReleaseContext(context.Background()); err != nil {
+		t.Fatalf("ReleaseContext: %v", err)
+	}
+	if b.closeCount() != 2 {
+		t.Fatalf("ReleaseContext closed %v connections, want 2", b.closeCount())
+	}
+}
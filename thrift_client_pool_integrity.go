@@ -0,0 +1,75 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// CheckIntegrity reports duplicated idle connections and counters not matching
+// the tracked connections. Gets and keepalive passes in flight make the counts
+// move, so it is meant for tests and admin checks on a quiet pool.
+func (p *ThriftClientPool) CheckIntegrity() error {
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	problems := []string{}
+	if dups := p.scanIdle(false); dups > 0 {
+		problems = append(problems, fmt.Sprintf("%v duplicated idle connections", dups))
+	}
+
+	inUse, idle := 0, 0
+	p.connsSync.Lock()
+	for _, conn := range p.conns {
+		switch conn.state {
+		case ConnInUse:
+			inUse++
+		case ConnIdle:
+			idle++
+		}
+	}
+	p.connsSync.Unlock()
+
+	if working := int(atomic.LoadInt32(&p.workConnCount)); working != inUse {
+		problems = append(problems, fmt.Sprintf("working count %v but %v connections in use", working, inUse))
+	}
+
+	if stored := p.alivePool.Len() + len(p.swapPool()) + int(atomic.LoadInt32(&p.coolingCount)) + int(atomic.LoadInt32(&p.permitConns)); stored != idle {
+		problems = append(problems, fmt.Sprintf("%v connections stored but %v tracked idle", stored, idle))
+	}
+
+	if len(problems) > 0 {
+		return errors.New(fmt.Sprintf("pool %v integrity check failed: %v.", p.Name(), problems))
+	}
+	return nil
+}
+
+// ReapDuplicates drops the extra references of connections stored twice in the
+// idle store and returns how many were dropped.
+func (p *ThriftClientPool) ReapDuplicates() int {
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	dups := p.scanIdle(true)
+	if dups > 0 {
+		p.logger().Infof("Reaped %v duplicated idle connections on %v.", dups, p.Name())
+	}
+	return dups
+}
+
+// scanIdle must be called with p.sync held.
+func (p *ThriftClientPool) scanIdle(reap bool) (dups int) {
+
+	seen := map[interface{}]bool{}
+	p.filterIdle(func(connection interface{}) bool {
+		if seen[connection] {
+			dups++
+			return !reap
+		}
+		seen[connection] = true
+		return true
+	})
+	return
+}
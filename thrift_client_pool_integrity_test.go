@@ -0,0 +1,32 @@
+package thrift_clientpool
+
+import (
+	"testing"
+)
+
+func TestCheckIntegrityFindsAndReapsDuplicates(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("integrity", 2, 1))
+
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatalf("CheckIntegrity on a quiet pool: %v", err)
+	}
+
+	connection, ok := p.popAlive()
+	if !ok {
+		t.Fatal("no idle connection")
+	}
+	p.alivePool.Push(connection)
+	p.alivePool.Push(connection)
+	if err := p.CheckIntegrity(); err == nil {
+		t.Fatal("CheckIntegrity missed a duplicated idle connection")
+	}
+
+	if dups := p.ReapDuplicates(); dups != 1 {
+		t.Fatalf("ReapDuplicates = %v, want 1", dups)
+	}
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatalf("CheckIntegrity after ReapDuplicates: %v", err)
+	}
+}
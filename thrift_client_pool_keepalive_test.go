@@ -0,0 +1,467 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeepAlivePassCountsProbingConnections(t *testing.T) {
+
+	backend := &fakeBackend{}
+	release := make(chan struct{})
+	var probing int32
+	backend.keepAliveFn = func(c *fakeConn) error {
+		atomic.AddInt32(&probing, 1)
+		<-release
+		return nil
+	}
+
+	p := newTestPool(t, backend.config("probing", 2, 2))
+
+	passDone := make(chan struct{})
+	go func() {
+		p.keepAlivePass()
+		close(passDone)
+	}()
+	waitFor(t, "both probes in flight", func() bool { return atomic.LoadInt32(&probing) == 2 })
+
+	if total := p.TotalConns(); total != 2 {
+		t.Fatalf("TotalConns during pass = %v, want 2", total)
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if connection, err := p.Get(); err == nil {
+				p.Put(connection)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if dials := backend.dialCount(); dials != 2 {
+		t.Fatalf("dials while probing = %v, want 2", dials)
+	}
+
+	close(release)
+	select {
+	case <-passDone:
+	case <-time.After(time.Second):
+		t.Fatal("keepalive pass did not finish")
+	}
+
+	if running := atomic.LoadInt32(&p.keepAliveRunning); running != 0 {
+		t.Fatalf("keepAliveRunning = %v after the pass", running)
+	}
+	if alive := p.alivePool.Len(); alive != 2 {
+		t.Fatalf("idle connections after pass = %v, want 2", alive)
+	}
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannelStoreRestoreDropsOverflow(t *testing.T) {
+
+	store := NewChannelStore(1).(*channelStore)
+	store.Push("newer")
+
+	done := make(chan []interface{})
+	go func() {
+		done <- store.Restore([]interface{}{"restored"})
+	}()
+
+	select {
+	case dropped := <-done:
+		if len(dropped) != 1 || dropped[0] != "newer" {
+			t.Fatalf("dropped = %v, want [newer]", dropped)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Restore blocked on a full store")
+	}
+
+	if connection, _ := store.Pop(); connection != "restored" {
+		t.Fatalf("Pop = %v, want restored", connection)
+	}
+}
+
+func TestCappedPassKeepsSuspectsOutOfRotation(t *testing.T) {
+
+	backend := &fakeBackend{}
+	failing := int32(1)
+	backend.keepAliveFn = func(c *fakeConn) error {
+		if atomic.LoadInt32(&failing) == 1 {
+			return errFakeDown
+		}
+		return nil
+	}
+	config := backend.config("suspects", 3, 3)
+	config.MaxEvictionsPerPass = 1
+	p := newTestPool(t, config)
+
+	p.keepAlivePass()
+	if closes := backend.closeCount(); closes != 1 {
+		t.Fatalf("closes after a capped pass = %v, want 1", closes)
+	}
+	if total := p.TotalConns(); total != 3 {
+		t.Fatalf("TotalConns after a capped pass = %v, want 3", total)
+	}
+	if connection, err := p.GetExisting(); err != ErrNoIdleConnection {
+		conn, _ := p.Conn(connection)
+		t.Fatalf("GetExisting after a capped pass returned %v, LastError %v", err, conn.LastError())
+	}
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&failing, 0)
+	p.keepAlivePass()
+	if alive := p.alivePool.Len(); alive != 2 {
+		t.Fatalf("idle after the suspects passed = %v, want 2", alive)
+	}
+	if cooling := p.Stats().Cooling; cooling != 0 {
+		t.Fatalf("Cooling after the suspects passed = %v, want 0", cooling)
+	}
+}
+
+func TestPanickingKeepAliveKeepsLoopRunning(t *testing.T) {
+
+	b := &fakeBackend{}
+	var panicked *fakeConn
+	var panickedSync sync.Mutex
+	b.keepAliveFn = func(c *fakeConn) error {
+		if c.id == 1 {
+			panickedSync.Lock()
+			panicked = c
+			panickedSync.Unlock()
+			panic("nil client")
+		}
+		return nil
+	}
+	config := b.config("panic", 2, 2)
+	config.KeepAliveInterval = time.Millisecond * 5
+	evicted := make(chan error, 1)
+	config.OnEvict = func(tag string, conn *Conn) { evicted <- conn.LastError() }
+	p := newTestPool(t, config)
+
+	waitFor(t, "the panicking connection to be evicted", func() bool {
+		panickedSync.Lock()
+		defer panickedSync.Unlock()
+		return panicked != nil && panicked.isClosed()
+	})
+	probes := b.keepAliveCount()
+	waitFor(t, "the loop to probe again", func() bool { return b.keepAliveCount() >= probes+2 })
+	// a pass takes the healthy connection out of the store while probing it.
+	waitFor(t, "the healthy connection to stay pooled", func() bool { return p.alivePool.Len() == 1 })
+	if err := <-evicted; err == nil || !strings.Contains(err.Error(), "KeepAlive panic") {
+		t.Fatalf("evicted connection has LastError %v, want the recovered panic", err)
+	}
+}
+
+func TestSlowKeepAlivePassesDoNotOverlap(t *testing.T) {
+
+	b := &fakeBackend{}
+	var inFlight, overlapped int32
+	b.keepAliveFn = func(c *fakeConn) error {
+		if atomic.AddInt32(&inFlight, 1) > 1 {
+			atomic.StoreInt32(&overlapped, 1)
+		}
+		time.Sleep(time.Millisecond * 20)
+		atomic.AddInt32(&inFlight, -1)
+		return nil
+	}
+	logger := &recordLogger{level: LevelWarn}
+	config := b.config("overlap", 1, 1)
+	config.KeepAliveInterval = time.Millisecond * 5
+	config.Logger = logger
+	p := newTestPool(t, config)
+
+	waitFor(t, "three slow passes", func() bool { return b.keepAliveCount() >= 3 })
+	if atomic.LoadInt32(&overlapped) == 1 {
+		t.Fatalf("a keepalive pass started while the previous one was probing")
+	}
+	if !logger.logged("longer than interval") {
+		t.Fatalf("pass longer than KeepAliveInterval was not logged")
+	}
+	if total := p.TotalConns(); total != 1 {
+		t.Fatalf("slow passes left %v connections, want 1", total)
+	}
+}
+
+func TestSlowProbeDoesNotStallOthers(t *testing.T) {
+
+	b := &fakeBackend{}
+	slow := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(slow) }) }
+	b.keepAliveFn = func(c *fakeConn) error {
+		if c.id == 1 {
+			<-slow
+		}
+		return nil
+	}
+	config := b.config("slow", 4, 3)
+	config.KeepAliveConcurrency = 3
+	p := newTestPool(t, config)
+	t.Cleanup(unblock)
+
+	done := make(chan struct{})
+	go func() {
+		p.keepAlivePass()
+		close(done)
+	}()
+	waitFor(t, "the other connections to be probed", func() bool { return b.keepAliveCount() == 3 })
+
+	got := make(chan error, 1)
+	go func() {
+		connection, err := p.Get()
+		if err == nil {
+			p.Put(connection)
+		}
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("Get during a slow probe: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Get waited for the slow probe")
+	}
+
+	unblock()
+	<-done
+	if total, idle := p.TotalConns(), p.alivePool.Len(); total != 3 || idle != 3 {
+		t.Fatalf("pass left %v connections and %v idle, want 3 and 3", total, idle)
+	}
+}
+
+func TestHungKeepAliveIsCutOffByProbeTimeout(t *testing.T) {
+
+	b := &fakeBackend{}
+	hang := make(chan struct{})
+	defer close(hang)
+	b.keepAliveFn = func(c *fakeConn) error {
+		<-hang
+		return nil
+	}
+	config := b.config("probetimeout", 2, 1)
+	config.ProbeTimeout = time.Millisecond * 20
+	evicted := make(chan error, 2)
+	config.OnEvict = func(tag string, conn *Conn) { evicted <- conn.LastError() }
+	p := newTestPool(t, config)
+
+	passDone := make(chan struct{})
+	go func() {
+		p.keepAlivePass()
+		close(passDone)
+	}()
+	select {
+	case <-passDone:
+	case <-time.After(time.Second):
+		t.Fatalf("keepalive pass waited for a hung KeepAlive past ProbeTimeout")
+	}
+	if err := <-evicted; err != ErrProbeTimeout {
+		t.Fatalf("hung probe evicted with %v, want ErrProbeTimeout", err)
+	}
+	if idle := p.alivePool.Len(); idle != 0 {
+		t.Fatalf("hung connection stayed idle")
+	}
+
+	// a KeepAliveContext sees its context end at ProbeTimeout.
+	cancelled := make(chan struct{}, 1)
+	config = b.config("probetimeoutctx", 2, 1)
+	config.ProbeTimeout = time.Millisecond * 20
+	config.KeepAliveContext = func(ctx context.Context, connection interface{}) error {
+		<-ctx.Done()
+		cancelled <- struct{}{}
+		return ctx.Err()
+	}
+	p = newTestPool(t, config)
+	p.keepAlivePass()
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("KeepAliveContext was not cancelled at ProbeTimeout")
+	}
+}
+
+func TestZeroIntervalIsRejectedOrClamped(t *testing.T) {
+
+	b := &fakeBackend{}
+	for _, zero := range []func(c *Config){
+		func(c *Config) { c.KeepAliveInterval = 0 },
+		func(c *Config) { c.DialRetryInterval = 0 },
+		func(c *Config) { c.CreateNewInterval = -time.Second },
+	} {
+		config := b.config("interval", 2, 1)
+		zero(&config)
+		if _, err := NewFromConfig(config); err == nil {
+			t.Fatal("NewFromConfig accepted a non-positive interval")
+		}
+	}
+
+	logger := &recordLogger{level: LevelWarn}
+	config := b.config("interval", 2, 1)
+	config.Logger = logger
+	p := newTestPool(t, config)
+
+	// an interval zeroed behind Validate is clamped by the loop.
+	p.update(func(c *Config) { c.KeepAliveInterval = 0 })
+	p.TriggerKeepAlive()
+	waitFor(t, "first keepalive pass", func() bool { return b.keepAliveCount() > 0 })
+	time.Sleep(time.Millisecond * 100)
+
+	if probes := b.keepAliveCount(); probes > int64(time.Millisecond*100/MinInterval)*2 {
+		t.Fatalf("%v keepalives in 100ms with a zero interval, want at most one per MinInterval", probes)
+	}
+	if !logger.logged("KeepAliveInterval of pool interval is 0s") {
+		t.Fatal("the clamped interval was not warned about")
+	}
+}
+
+func TestAdaptiveKeepAliveSkipsProbesUnderLoad(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("adaptive", 3, 3)
+	config.KeepAliveInterval = time.Millisecond * 10
+	config.AdaptiveKeepAlive = true
+	config.AdaptiveKeepAliveThreshold = 1
+	p := newTestPool(t, config)
+
+	first, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	second, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	probes := b.keepAliveCount()
+	time.Sleep(time.Millisecond * 100)
+	if got := b.keepAliveCount(); got != probes {
+		t.Fatalf("%v keepalives while 2 connections are in use over the threshold of 1", got-probes)
+	}
+
+	if err := p.Put(first); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := p.Put(second); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	waitFor(t, "keepalives to resume", func() bool { return b.keepAliveCount() >= probes+6 })
+}
+
+func TestTriggerKeepAliveRunsOnePassNow(t *testing.T) {
+
+	b := &fakeBackend{}
+	var blocking, blocked int32
+	gate := make(chan struct{})
+	b.keepAliveFn = func(c *fakeConn) error {
+		if atomic.LoadInt32(&blocking) == 1 {
+			atomic.AddInt32(&blocked, 1)
+			<-gate
+		}
+		return nil
+	}
+	p := newTestPool(t, b.config("trigger", 2, 2))
+
+	p.TriggerKeepAlive()
+	waitFor(t, "a pass despite the hour interval", func() bool { return b.keepAliveCount() == 2 })
+
+	atomic.StoreInt32(&blocking, 1)
+	p.TriggerKeepAlive()
+	waitFor(t, "the triggered pass to block", func() bool { return atomic.LoadInt32(&blocked) > 0 })
+	for i := 0; i < 100; i++ {
+		p.TriggerKeepAlive()
+	}
+	close(gate)
+
+	// the blocked pass and one merged pending pass.
+	waitFor(t, "the pending pass", func() bool { return b.keepAliveCount() == 6 })
+	time.Sleep(time.Millisecond * 50)
+	if got := b.keepAliveCount(); got != 6 {
+		t.Fatalf("%v probes after 100 triggers during a pass, want 2 passes of 2", got-2)
+	}
+}
+
+func TestProbeStaleOnlySkipsRecentlyAliveConnections(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("stale", 2, 2)
+	config.ProbeStaleOnly = true
+	p := newTestPool(t, config)
+
+	p.keepAlivePass()
+	if probes := b.keepAliveCount(); probes != 0 {
+		t.Fatalf("probes of freshly dialed connections = %v, want 0", probes)
+	}
+
+	p.connsSync.Lock()
+	for _, conn := range p.conns {
+		conn.lastUsed = conn.lastUsed.Add(-2 * config.KeepAliveInterval)
+		conn.lastProbe = conn.lastProbe.Add(-2 * config.KeepAliveInterval)
+		break
+	}
+	p.connsSync.Unlock()
+
+	p.keepAlivePass()
+	if probes := b.keepAliveCount(); probes != 1 {
+		t.Fatalf("probes after one connection went stale = %v, want 1", probes)
+	}
+	waitFor(t, "both connections back in the store", func() bool { return p.alivePool.Len() == 2 })
+}
+
+func TestKeepAlivePassKeepsHandOutOrder(t *testing.T) {
+
+	stores := []struct {
+		name  string
+		store func() IdleStore
+		want  []int64
+	}{
+		{"channel", func() IdleStore { return NewChannelStore(3) }, []int64{1, 2, 3}},
+		{"stack", NewStackStore, []int64{3, 2, 1}},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+
+			b := &fakeBackend{}
+			config := b.config("order", 3, 0)
+			config.IdleStore = s.store()
+			config.KeepAliveConcurrency = 3
+			p := newTestPool(t, config)
+
+			borrowed := []interface{}{}
+			for i := 0; i < 3; i++ {
+				connection, err := p.Get()
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				borrowed = append(borrowed, connection)
+			}
+			for _, connection := range borrowed {
+				p.Put(connection)
+			}
+			p.keepAlivePass()
+
+			got := []int64{}
+			for i := 0; i < 3; i++ {
+				connection, err := p.Get()
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				got = append(got, connection.(*fakeConn).id)
+			}
+			if !reflect.DeepEqual(got, s.want) {
+				t.Fatalf("Gets after a keepalive pass handed out %v, want %v", got, s.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Lease pins one borrowed connection so every call of a multi-call sequence,
+// e.g. begin and commit, runs on the same connection until Close.
+type Lease struct {
+	pool       *ThriftClientPool
+	connection interface{}
+	sync       sync.Mutex
+}
+
+var ErrLeaseClosed = errors.New("lease was closed.")
+
+func (p *ThriftClientPool) Lease(ctx context.Context) (*Lease, error) {
+
+	connection, err := p.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lease{pool: p, connection: connection}, nil
+}
+
+// Conn returns the pinned connection, the same one on every call.
+func (lease *Lease) Conn() (interface{}, error) {
+
+	lease.sync.Lock()
+	defer lease.sync.Unlock()
+
+	if lease.connection == nil {
+		return nil, ErrLeaseClosed
+	}
+	return lease.connection, nil
+}
+
+// Close returns the pinned connection to the pool, later calls do nothing.
+func (lease *Lease) Close() error {
+	return lease.CloseErr(nil)
+}
+
+// CloseErr returns the pinned connection like PutErr, evicting it when err is set.
+func (lease *Lease) CloseErr(err error) error {
+
+	lease.sync.Lock()
+	connection := lease.connection
+	lease.connection = nil
+	lease.sync.Unlock()
+
+	if connection == nil {
+		return nil
+	}
+	return lease.pool.PutErr(connection, err)
+}
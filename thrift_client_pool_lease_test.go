@@ -0,0 +1,55 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLeasePinsOneConnection(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("lease", 2, 2))
+
+	lease, err := p.Lease(context.Background())
+	if err != nil {
+		t.Fatalf("Lease: %v", err)
+	}
+	first, _ := lease.Conn()
+	second, _ := lease.Conn()
+	if first == nil || first != second {
+		t.Fatal("Lease.Conn returned different connections")
+	}
+	if working := p.Stats().Working; working != 1 {
+		t.Fatalf("Working with a lease = %v, want 1", working)
+	}
+
+	if err := lease.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := lease.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if _, err := lease.Conn(); err != ErrLeaseClosed {
+		t.Fatalf("Conn after Close returned %v, want ErrLeaseClosed", err)
+	}
+	if working := p.Stats().Working; working != 0 {
+		t.Fatalf("Working after Close = %v, want 0", working)
+	}
+}
+
+func TestLeaseCloseErrEvicts(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("leaseerr", 1, 1))
+
+	lease, err := p.Lease(context.Background())
+	if err != nil {
+		t.Fatalf("Lease: %v", err)
+	}
+	connection, _ := lease.Conn()
+	lease.CloseErr(errFakeClosed)
+
+	if !connection.(*fakeConn).isClosed() {
+		t.Fatal("CloseErr re-pooled the connection")
+	}
+}
@@ -0,0 +1,174 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// expired reports whether connection has existed longer than MaxConnLifetime,
+// however busy it has been.
+func (p *ThriftClientPool) expired(connection interface{}) bool {
+
+	lifetime := p.cfg().MaxConnLifetime
+	if lifetime <= 0 {
+		return false
+	}
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	conn, ok := p.conns[connection]
+	return ok && time.Since(conn.created) >= lifetime
+}
+
+// idledOut reports whether connection sat idle longer than MaxIdleTime, the
+// keepalive pass then closes it without a replacement so the pool shrinks.
+func (p *ThriftClientPool) idledOut(connection interface{}) bool {
+
+	maxIdle := p.cfg().MaxIdleTime
+	if maxIdle <= 0 {
+		return false
+	}
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	conn, ok := p.conns[connection]
+	return ok && conn.state == ConnIdle && time.Since(conn.lastUsed) >= maxIdle
+}
+
+// recycling is an expired or idled-out connection maintain took out of the
+// pool, it stays counted in probing until recycle decided on it.
+type recycling struct {
+	connection interface{}
+	expired    bool
+}
+
+// retireConnection closes an expired connection and leaves a retry slot so the
+// retry loop dials a fresh one in its place.
+func (p *ThriftClientPool) retireConnection(connection interface{}) {
+
+	p.logger().Infof("Connection on %v reached lifetime %v, retire it.", p.Name(), p.cfg().MaxConnLifetime)
+	if err := p.discardConnection(connection); err != nil {
+		p.logger().Warnf("Close connection on %v error: %v", p.Name(), err)
+	}
+	p.pushRetry()
+}
+
+// maintain queues connection to closing when it is over MaxConnLifetime or
+// MaxIdleTime and reports whether it stays, BeforeClose is asked by recycle
+// once p.sync is released.
+func (p *ThriftClientPool) maintain(connection interface{}, closing *closeQueue) bool {
+
+	if expired := p.expired(connection); expired || p.idledOut(connection) {
+		p.queueRecycle(connection, expired, closing)
+		return false
+	}
+	return true
+}
+
+func (p *ThriftClientPool) queueRecycle(connection interface{}, expired bool, closing *closeQueue) {
+
+	atomic.AddInt32(&p.probing, 1)
+	closing.recycle = append(closing.recycle, recycling{connection: connection, expired: expired})
+}
+
+// recycle must be called without p.sync, it retires or closes a connection
+// maintain queued unless BeforeClose keeps it, and reports whether it is kept.
+func (p *ThriftClientPool) recycle(r recycling) (kept bool) {
+
+	if p.beforeClose(r.connection) {
+		if r.expired {
+			p.logger().Infof("BeforeClose kept connection on %v past its lifetime.", p.Name())
+		} else {
+			p.logger().Infof("BeforeClose kept idle connection on %v.", p.Name())
+		}
+		return true
+	}
+
+	if r.expired {
+		p.retireConnection(r.connection)
+		return false
+	}
+	if p.logEnabled(LevelDebug) {
+		p.logger().Debugf("Connection on %v idle longer than %v, close it.", p.Name(), p.cfg().MaxIdleTime)
+	}
+	if err := p.discardConnection(r.connection); err != nil {
+		p.logger().Warnf("Close connection on %v error: %v", p.Name(), err)
+	}
+	return false
+}
+
+// maintenanceLoop applies MaxConnLifetime and MaxIdleTime to the idle
+// connections every MaintenanceInterval, apart from the keepalive probes.
+func (p *ThriftClientPool) maintenanceLoop() {
+
+	for {
+		<-time.After(p.safeInterval("MaintenanceInterval", p.cfg().MaintenanceInterval))
+
+		if p.stopped() {
+			break
+		}
+
+		p.maintenancePass()
+	}
+}
+
+func (p *ThriftClientPool) maintenancePass() {
+
+	var closing closeQueue
+	defer p.closeQueued(&closing)
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	p.filterIdle(func(connection interface{}) bool {
+		return p.maintain(connection, &closing)
+	})
+}
+
+// filterIdle must be called with p.sync held, it drops the idle connections
+// keep returns false for. keep must not close them, Close runs without p.sync,
+// see closeQueue.
+func (p *ThriftClientPool) filterIdle(keep func(connection interface{}) bool) {
+
+	connections := []interface{}{}
+	for i, max := 0, p.alivePool.Len(); i < max; i++ {
+		connection, ok := p.alivePool.Pop()
+		if !ok {
+			break
+		}
+		connections = append(connections, connection)
+	}
+
+	kept := connections[:0]
+	for _, connection := range connections {
+		if keep(connection) {
+			kept = append(kept, connection)
+		}
+	}
+	p.restoreIdle(kept)
+}
+
+// restoreIdle puts back idle connections taken out in hand-out order, and wakes
+// up the Gets waiting for one.
+func (p *ThriftClientPool) restoreIdle(connections []interface{}) {
+
+	if len(connections) == 0 {
+		return
+	}
+
+	if store, ok := p.alivePool.(RestoringStore); ok {
+		for _, connection := range store.Restore(connections) {
+			p.logger().Warnf("Idle store of %v is full, close restored connection.", p.Name())
+			p.discardConnection(connection)
+		}
+	} else {
+		for _, connection := range connections {
+			p.alivePool.Push(connection)
+		}
+	}
+	p.releaseLateIdle()
+	p.wakeIdle()
+	p.refreshHealth()
+}
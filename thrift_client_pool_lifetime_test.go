@@ -0,0 +1,108 @@
+package thrift_clientpool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenancePassRetiresExpiredConnections(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("lifetime", 2, 2)
+	config.MaxConnLifetime = time.Millisecond * 10
+	p := newTestPool(t, config)
+
+	time.Sleep(config.MaxConnLifetime)
+	p.maintenancePass()
+
+	if closes := b.closeCount(); closes != 2 {
+		t.Fatalf("closes after the pass = %v, want 2", closes)
+	}
+	if retry := len(p.retryPool()); retry != 2 {
+		t.Fatalf("retry slots after retiring = %v, want 2", retry)
+	}
+}
+
+func TestMaintenancePassClosesIdledOutConnections(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("idletime", 2, 2)
+	config.MaxIdleTime = time.Millisecond * 10
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	time.Sleep(config.MaxIdleTime)
+	p.maintenancePass()
+
+	if closes := b.closeCount(); closes != 1 {
+		t.Fatalf("closes after the pass = %v, want the idle one", closes)
+	}
+	if retry := len(p.retryPool()); retry != 0 {
+		t.Fatalf("retry slots after idling out = %v, want 0", retry)
+	}
+	p.Put(connection)
+	if total := p.TotalConns(); total != 1 {
+		t.Fatalf("TotalConns = %v, want 1", total)
+	}
+}
+
+func TestBorrowedConnectionPastLifetimeIsRetiredOnReturn(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("lifetime", 2, 1)
+	config.MaxConnLifetime = time.Millisecond * 20
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	// borrowed the whole time, the maintenance pass never sees it.
+	time.Sleep(config.MaxConnLifetime)
+	p.maintenancePass()
+	if connection.(*fakeConn).isClosed() {
+		t.Fatal("expired connection closed while borrowed")
+	}
+
+	if err := p.Put(connection); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !connection.(*fakeConn).isClosed() {
+		t.Fatal("connection past its lifetime was pooled again on return")
+	}
+	if got := p.alivePool.Len(); got != 0 {
+		t.Fatalf("%v idle connections after retiring, want 0", got)
+	}
+	if retry := len(p.retryPool()); retry != 1 {
+		t.Fatalf("retry slots after retiring on return = %v, want 1", retry)
+	}
+}
+
+func TestMaintenanceRunsOnItsOwnSchedule(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("maintenance", 2, 2)
+	config.MaxIdleTime = time.Millisecond * 10
+	config.KeepAliveInterval = time.Millisecond * 5
+	config.MaintenanceInterval = time.Hour
+	newTestPool(t, config)
+
+	waitFor(t, "keepalive passes", func() bool { return b.keepAliveCount() >= 10 })
+	if closes := b.closeCount(); closes != 0 {
+		t.Fatalf("keepalive passes idled out %v connections, want eviction left to maintenance", closes)
+	}
+
+	b = &fakeBackend{}
+	config = b.config("maintenance", 2, 2)
+	config.MaxIdleTime = time.Millisecond * 10
+	config.MaintenanceInterval = time.Millisecond * 20
+	newTestPool(t, config)
+
+	waitFor(t, "maintenance to idle out both", func() bool { return b.closeCount() == 2 })
+	if probes := b.keepAliveCount(); probes != 0 {
+		t.Fatalf("%v keepalives with an hour interval, want eviction without probing", probes)
+	}
+}
@@ -0,0 +1,104 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DialLimiter bounds dials shared by several pools, so an outage does not make
+// every pool in the process reconnect at once.
+type DialLimiter struct {
+	slots    chan struct{}
+	interval time.Duration
+	next     time.Time
+	sync     sync.Mutex
+}
+
+// NewDialLimiter allows at most concurrency dials at a time and, when interval
+// is positive, starts at most one dial per interval.
+func NewDialLimiter(concurrency int, interval time.Duration) *DialLimiter {
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &DialLimiter{slots: make(chan struct{}, concurrency), interval: interval}
+}
+
+// WithDialLimiter makes the pool take a slot from limiter for every dial.
+func WithDialLimiter(limiter *DialLimiter) Option {
+	return func(p *ThriftClientPool) {
+		p.update(func(c *Config) {
+			c.DialLimiter = limiter
+		})
+	}
+}
+
+func (l *DialLimiter) acquire() {
+
+	l.slots <- struct{}{}
+
+	if l.interval <= 0 {
+		return
+	}
+
+	l.sync.Lock()
+	now := time.Now()
+	wait := l.next.Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	l.next = now.Add(wait + l.interval)
+	l.sync.Unlock()
+
+	time.Sleep(wait)
+}
+
+func (l *DialLimiter) release() {
+	<-l.slots
+}
+
+var ErrBudgetExhausted = errors.New("capacity budget exhausted.")
+
+// CapacityBudget caps the open connections of all pools sharing it, a pool then
+// dials only while the budget has room, whatever its own MaxPoolSize allows.
+type CapacityBudget struct {
+	max  int32
+	used int32
+}
+
+func NewCapacityBudget(max int) *CapacityBudget {
+	return &CapacityBudget{max: int32(max)}
+}
+
+// WithCapacityBudget makes the pool count its connections against budget.
+func WithCapacityBudget(budget *CapacityBudget) Option {
+	return func(p *ThriftClientPool) {
+		p.update(func(c *Config) {
+			c.CapacityBudget = budget
+		})
+	}
+}
+
+// Used returns how many connections are open against the budget.
+func (b *CapacityBudget) Used() int {
+	return int(atomic.LoadInt32(&b.used))
+}
+
+func (b *CapacityBudget) take() bool {
+
+	for {
+		used := atomic.LoadInt32(&b.used)
+		if used >= b.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&b.used, used, used+1) {
+			return true
+		}
+	}
+}
+
+func (b *CapacityBudget) release() {
+	atomic.AddInt32(&b.used, -1)
+}
@@ -0,0 +1,88 @@
+package thrift_clientpool
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDialLimiterBoundsDialsAcrossPools(t *testing.T) {
+
+	limiter := NewDialLimiter(1, 0)
+	inFlight, peak := int32(0), int32(0)
+	slowDial := func() error {
+		n := atomic.AddInt32(&inFlight, 1)
+		for {
+			max := atomic.LoadInt32(&peak)
+			if n <= max || atomic.CompareAndSwapInt32(&peak, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 5)
+		atomic.AddInt32(&inFlight, -1)
+		return nil
+	}
+
+	pools := []*ThriftClientPool{}
+	for _, name := range []string{"limitera", "limiterb"} {
+		b := &fakeBackend{dialFn: slowDial}
+		pools = append(pools, newTestPool(t, b.config(name, 4, 0), WithDialLimiter(limiter)))
+	}
+
+	wg := sync.WaitGroup{}
+	for _, p := range pools {
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(p *ThriftClientPool) {
+				defer wg.Done()
+				if connection, err := p.Get(); err == nil {
+					p.Put(connection)
+				}
+			}(p)
+		}
+	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&peak); max != 1 {
+		t.Fatalf("dials in flight at once = %v, want 1", max)
+	}
+}
+
+func TestDialLimiterSpacesDials(t *testing.T) {
+
+	limiter := NewDialLimiter(4, time.Millisecond*20)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.acquire()
+		limiter.release()
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*40 {
+		t.Fatalf("3 dials took %v, want at least 40ms apart", elapsed)
+	}
+}
+
+func TestCapacityBudgetIsShared(t *testing.T) {
+
+	budget := NewCapacityBudget(2)
+	b := &fakeBackend{}
+	full := newTestPool(t, b.config("budgetfull", 2, 2), WithCapacityBudget(budget))
+	other := newTestPool(t, b.config("budgetother", 2, 0), WithCapacityBudget(budget))
+
+	if used := budget.Used(); used != 2 {
+		t.Fatalf("budget Used = %v, want 2", used)
+	}
+	if _, err := other.Get(); err == nil {
+		t.Fatal("Get past an exhausted budget succeeded")
+	}
+
+	full.Release()
+	if used := budget.Used(); used != 0 {
+		t.Fatalf("budget Used after Release = %v, want 0", used)
+	}
+	connection, err := other.Get()
+	if err != nil {
+		t.Fatalf("Get with budget room: %v", err)
+	}
+	other.Put(connection)
+}
@@ -0,0 +1,94 @@
+package thrift_clientpool
+
+import (
+	"log"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// Logger receives the pool messages, per borrow and return chatter goes to Debugf.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// LevelLogger is optionally implemented by a Logger, the pool then skips
+// building the arguments of messages the logger would drop.
+type LevelLogger interface {
+	Enabled(level LogLevel) bool
+}
+
+// DefaultLogger is used by pools without a Logger.
+var DefaultLogger Logger = NewStdLogger(LevelInfo)
+
+type stdLogger struct {
+	level LogLevel
+}
+
+// NewStdLogger writes the messages at level and above with the standard log package.
+func NewStdLogger(level LogLevel) Logger {
+	return &stdLogger{level: level}
+}
+
+// NopLogger drops every message, set it as Logger to silence a pool.
+var NopLogger Logger = nopLogger{}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, v ...interface{}) {}
+func (nopLogger) Infof(format string, v ...interface{})  {}
+func (nopLogger) Warnf(format string, v ...interface{})  {}
+func (nopLogger) Errorf(format string, v ...interface{}) {}
+func (nopLogger) Enabled(level LogLevel) bool            { return false }
+
+// WithLogger replaces DefaultLogger for one pool.
+func WithLogger(logger Logger) Option {
+	return func(p *ThriftClientPool) {
+		p.update(func(c *Config) {
+			c.Logger = logger
+		})
+	}
+}
+
+func (l *stdLogger) Debugf(format string, v ...interface{}) { l.printf(LevelDebug, format, v...) }
+func (l *stdLogger) Infof(format string, v ...interface{})  { l.printf(LevelInfo, format, v...) }
+func (l *stdLogger) Warnf(format string, v ...interface{})  { l.printf(LevelWarn, format, v...) }
+func (l *stdLogger) Errorf(format string, v ...interface{}) { l.printf(LevelError, format, v...) }
+
+func (l *stdLogger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
+func (l *stdLogger) printf(level LogLevel, format string, v ...interface{}) {
+
+	if level >= l.level {
+		log.Printf(format, v...)
+	}
+}
+
+func (p *ThriftClientPool) logger() Logger {
+
+	if logger := p.cfg().Logger; logger != nil {
+		return logger
+	}
+	return DefaultLogger
+}
+
+// logEnabled guards messages with arguments on the borrow and return path, so
+// nothing is boxed or formatted when the logger drops them.
+func (p *ThriftClientPool) logEnabled(level LogLevel) bool {
+
+	if logger, ok := p.logger().(LevelLogger); ok {
+		return logger.Enabled(level)
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package thrift_clientpool
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestBorrowPathDoesNotAllocateWithoutLogging(t *testing.T) {
+
+	loggers := map[string]Logger{
+		"nop":  NopLogger,
+		"info": NewStdLogger(LevelInfo),
+	}
+	for name, logger := range loggers {
+		b := &fakeBackend{}
+		config := b.config("quiet"+name, 2, 2)
+		config.Logger = logger
+		p := newTestPool(t, config)
+
+		allocs := testing.AllocsPerRun(100, func() {
+			connection, err := p.Get()
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			p.Put(connection)
+		})
+		if allocs != 0 {
+			t.Fatalf("Get and Put with the %v logger allocate %v times", name, allocs)
+		}
+	}
+}
+
+func BenchmarkGetPut(b *testing.B) {
+
+	backend := &fakeBackend{}
+	p := newTestPool(b, backend.config("getput", 1, 1))
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		connection, err := p.Get()
+		if err != nil {
+			b.Fatalf("Get: %v", err)
+		}
+		p.Put(connection)
+	}
+}
+
+func BenchmarkGetPutParallel(b *testing.B) {
+
+	backend := &fakeBackend{}
+	p := newTestPool(b, backend.config("getputparallel", 8, 8))
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if connection, err := p.Get(); err == nil {
+				p.Put(connection)
+			}
+		}
+	})
+}
+
+func TestInfoLevelSuppressesBorrowChatter(t *testing.T) {
+
+	logger := &recordLogger{level: LevelInfo}
+	b := &fakeBackend{}
+	config := b.config("chatter", 2, 1)
+	config.Logger = logger
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.Put(connection)
+	p.Put(&fakeConn{})
+
+	if logger.logged("Get new connection") {
+		t.Fatal("borrow chatter logged at Info level")
+	}
+	if !logger.logged("Put connection unknown to pool chatter") {
+		t.Fatal("warning dropped at Info level")
+	}
+}
+
+func TestStdLoggerWritesAtLevelAndAbove(t *testing.T) {
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	logger := NewStdLogger(LevelInfo)
+	logger.Debugf("debug %v", 1)
+	logger.Infof("info %v", 2)
+	logger.Errorf("error %v", 3)
+
+	if strings.Contains(out.String(), "debug 1") {
+		t.Fatalf("debug message written at Info level: %q", out.String())
+	}
+	if !strings.Contains(out.String(), "info 2") || !strings.Contains(out.String(), "error 3") {
+		t.Fatalf("messages at and above Info missing: %q", out.String())
+	}
+	if leveled := logger.(LevelLogger); leveled.Enabled(LevelDebug) || !leveled.Enabled(LevelWarn) {
+		t.Fatal("Enabled does not follow the Info level")
+	}
+}
@@ -0,0 +1,105 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNotAccepted = errors.New("dialed connection not accepted.")
+
+// GetMatching borrows the first idle connection accept agrees to, and dials a
+// fresh one when none does. A full pool closes the least recently used idle
+// connection accept refuses to make room, a fresh connection accept still
+// refuses is pooled and ErrNotAccepted returned. Like Get it fails with a
+// CircuitOpenError under CircuitFailFast while every endpoint breaker is open.
+func (p *ThriftClientPool) GetMatching(ctx context.Context, accept func(connection interface{}) bool) (connection interface{}, err error) {
+
+	defer p.observeGetWait(time.Now())
+	defer func() { p.endGet(ctx, connection, err) }()
+
+	if admitted, err := p.beginGet(ctx); err != nil {
+		return nil, err
+	} else if admitted {
+		defer p.admitDone()
+	}
+
+	if connection, ok := p.borrowIdle(accept); ok {
+		return connection, nil
+	}
+
+	if err = p.failFast(); err != nil {
+		return nil, err
+	}
+
+	p.sync.Lock()
+	var oldest interface{}
+	if p.totalConnCount() >= p.dialLimit() {
+		oldest = p.takeOldestIdle()
+	}
+	p.unlock()
+
+	if oldest != nil {
+		if p.callAccept(accept, oldest) {
+			// returned since borrowIdle looked, hand it out.
+			p.borrowConnection(oldest)
+			atomic.AddInt32(&p.probing, -1)
+			if p.probeBorrowed(oldest) {
+				return oldest, nil
+			}
+		} else {
+			p.discardConnection(oldest)
+			atomic.AddInt32(&p.probing, -1)
+		}
+	}
+
+	if connection, err = p.createConnection(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	if !p.callAccept(accept, connection) {
+		p.Put(connection)
+		return nil, ErrNotAccepted
+	}
+	if err = p.primeBorrowed(connection); err != nil {
+		return nil, err
+	}
+	return connection, nil
+}
+
+// takeOldestIdle must be called with p.sync held, it takes the idle connection
+// returned longest ago out of the store and counts it in probing until the
+// caller closed or borrowed it.
+func (p *ThriftClientPool) takeOldestIdle() (oldest interface{}) {
+
+	var oldestUsed time.Time
+	p.filterIdle(func(connection interface{}) bool {
+		p.connsSync.Lock()
+		conn, ok := p.conns[connection]
+		if ok && (oldest == nil || conn.lastUsed.Before(oldestUsed)) {
+			oldest, oldestUsed = connection, conn.lastUsed
+		}
+		p.connsSync.Unlock()
+		return true
+	})
+
+	if oldest != nil && p.removeIdle(oldest) {
+		atomic.AddInt32(&p.probing, 1)
+		return oldest
+	}
+	return nil
+}
+
+// callAccept runs the accept of GetMatching, a panic refuses the connection.
+func (p *ThriftClientPool) callAccept(accept func(connection interface{}) bool, connection interface{}) (ok bool) {
+
+	var err error
+	defer func() {
+		if err != nil {
+			ok = false
+		}
+	}()
+	defer p.recoverCallback("GetMatching accept", &err)
+	return accept(connection)
+}
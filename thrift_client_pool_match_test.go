@@ -0,0 +1,195 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetMatchingRunsAcceptOutsideLock(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("match", 2, 2))
+
+	done := make(chan error, 1)
+	go func() {
+		connection, err := p.GetMatching(context.Background(), func(connection interface{}) bool {
+			// takes p.sync, it deadlocks when accept runs under it.
+			p.SnapshotConnections()
+			return connection.(*fakeConn).id == 2
+		})
+		if err == nil && connection.(*fakeConn).id != 2 {
+			err = errors.New("GetMatching returned a refused connection.")
+		}
+		if err == nil {
+			err = p.Put(connection)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("GetMatching: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("GetMatching deadlocked calling accept")
+	}
+	if p.alivePool.Len() != 2 || p.TotalConns() != 2 {
+		t.Fatalf("pool has %v idle of %v connections after GetMatching, want 2 of 2", p.alivePool.Len(), p.TotalConns())
+	}
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	}
+}
+
+func TestGetMatchingRunsBorrowProbe(t *testing.T) {
+
+	b := &fakeBackend{}
+	var probes int64
+	config := b.config("match", 2, 1)
+	config.BorrowProbe = func(connection interface{}) error {
+		atomic.AddInt64(&probes, 1)
+		return errors.New("stale connection.")
+	}
+	p := newTestPool(t, config)
+
+	connection, err := p.GetMatching(context.Background(), func(connection interface{}) bool { return true })
+	if err != nil {
+		t.Fatalf("GetMatching: %v", err)
+	}
+	if atomic.LoadInt64(&probes) != 1 {
+		t.Fatalf("BorrowProbe ran %v times, want 1", probes)
+	}
+	if connection.(*fakeConn).id == 1 {
+		t.Fatalf("GetMatching handed out the connection BorrowProbe failed")
+	}
+	p.Put(connection)
+}
+
+func TestGetMatchingRestoresCooled(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("match", 1, 1))
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := p.PutCooldown(connection, time.Millisecond); err != nil {
+		t.Fatalf("PutCooldown: %v", err)
+	}
+	time.Sleep(time.Millisecond * 5)
+
+	matched, err := p.GetMatching(context.Background(), func(c interface{}) bool { return c == connection })
+	if err != nil {
+		t.Fatalf("GetMatching: %v", err)
+	}
+	if matched != connection || b.dialCount() != 1 {
+		t.Fatalf("GetMatching dialed instead of taking the cooled connection")
+	}
+	p.Put(matched)
+}
+
+func TestGetMatchingIsAdmitted(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("match", 2, 2))
+	p.SetConcurrencyLimit(1)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := p.GetMatching(ctx, func(interface{}) bool { return true }); err != context.DeadlineExceeded {
+		t.Fatalf("GetMatching over the concurrency limit returned %v, want it to wait for admission", err)
+	}
+
+	p.Put(held)
+	connection, err := p.GetMatching(context.Background(), func(interface{}) bool { return true })
+	if err != nil {
+		t.Fatalf("GetMatching under the concurrency limit: %v", err)
+	}
+	p.Put(connection)
+}
+
+// matchID accepts only the fakeConn with id.
+func matchID(id int64) func(connection interface{}) bool {
+	return func(connection interface{}) bool { return connection.(*fakeConn).id == id }
+}
+
+func TestGetMatchingSkipsRefusedAndDialsWhenNoneMatch(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("match", 4, 2))
+
+	matched, err := p.GetMatching(context.Background(), matchID(2))
+	if err != nil || matched.(*fakeConn).id != 2 {
+		t.Fatalf("GetMatching = %v: %v, want connection 2", matched, err)
+	}
+	if idle := p.alivePool.Len(); idle != 1 || b.dialCount() != 2 {
+		t.Fatalf("after a match %v idle and %v dials, want the refused one kept and no dial", idle, b.dialCount())
+	}
+
+	fresh, err := p.GetMatching(context.Background(), matchID(3))
+	if err != nil || fresh.(*fakeConn).id != 3 {
+		t.Fatalf("GetMatching without a match = %v: %v, want a fresh connection 3", fresh, err)
+	}
+	if idle := p.alivePool.Len(); idle != 1 || b.closeCount() != 0 {
+		t.Fatalf("a fresh dial left %v idle and closed %v, want the refused one kept", idle, b.closeCount())
+	}
+	p.PutAll([]interface{}{matched, fresh})
+}
+
+func TestGetMatchingEvictsOldestRefusedWhenFull(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("match", 3, 2))
+
+	// connection 1 is returned, connection 2 idles since its dial.
+	recent, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.Put(recent)
+	held, err := p.GetMatching(context.Background(), matchID(3))
+	if err != nil {
+		t.Fatalf("GetMatching: %v", err)
+	}
+
+	fresh, err := p.GetMatching(context.Background(), matchID(4))
+	if err != nil || fresh.(*fakeConn).id != 4 {
+		t.Fatalf("GetMatching on a full pool = %v: %v, want a fresh connection 4", fresh, err)
+	}
+	if closes := b.closeCount(); closes != 1 || recent.(*fakeConn).isClosed() {
+		t.Fatalf("full pool closed %v connections, want only the least recently used one", closes)
+	}
+	if total := p.TotalConns(); total != 3 || !p.IsManaged(recent) {
+		t.Fatalf("TotalConns = %v after the eviction, want 3 with connection 1 kept", total)
+	}
+	p.PutAll([]interface{}{held, fresh})
+}
+
+func TestGetMatchingFailsFastOnOpenCircuit(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("match", 2, 0)
+	config.EndpointFailureThreshold = 1
+	config.EndpointCooldown = time.Hour
+	config.CircuitFailFast = true
+	p := newTestPool(t, config)
+
+	p.reportEndpoint(config.Address, errFakeDown)
+
+	if _, err := p.GetMatching(context.Background(), matchID(1)); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("GetMatching with every breaker open returned %v, want ErrCircuitOpen", err)
+	}
+	if dials := b.dialCount(); dials != 0 {
+		t.Fatalf("dials with the circuit open = %v, want 0", dials)
+	}
+}
@@ -0,0 +1,63 @@
+package thrift_clientpool
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Option customizes a pool while it is constructed.
+type Option func(p *ThriftClientPool)
+
+// WithConfig changes the settings of the pool before it dials, e.g.
+// RegisterDefaults("billing.", WithConfig(func(c *Config) { c.MaxPoolSize = 32 })).
+func WithConfig(fn func(c *Config)) Option {
+	return func(p *ThriftClientPool) {
+		p.update(fn)
+	}
+}
+
+var (
+	defaultOptions     = map[string][]Option{}
+	defaultOptionsSync sync.Mutex
+)
+
+// RegisterDefaults registers options applied to every pool whose name starts
+// with prefix, before the Config and options passed to the constructor. A
+// setting the Config leaves at zero or at its DefaultConfig value keeps the
+// default. Longer prefixes are applied after shorter ones so the more specific
+// defaults win.
+func RegisterDefaults(prefix string, opts ...Option) {
+
+	defaultOptionsSync.Lock()
+	defaultOptions[prefix] = append(defaultOptions[prefix], opts...)
+	defaultOptionsSync.Unlock()
+}
+
+// UnregisterDefaults drops the options registered for prefix, pools already
+// built keep their settings.
+func UnregisterDefaults(prefix string) {
+
+	defaultOptionsSync.Lock()
+	delete(defaultOptions, prefix)
+	defaultOptionsSync.Unlock()
+}
+
+func defaultsFor(name string) (opts []Option) {
+
+	defaultOptionsSync.Lock()
+	defer defaultOptionsSync.Unlock()
+
+	prefixes := []string{}
+	for prefix := range defaultOptions {
+		if strings.HasPrefix(name, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) < len(prefixes[j]) })
+
+	for _, prefix := range prefixes {
+		opts = append(opts, defaultOptions[prefix]...)
+	}
+	return
+}
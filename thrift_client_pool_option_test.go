@@ -0,0 +1,116 @@
+package thrift_clientpool
+
+import (
+	"testing"
+)
+
+func TestWithConfigIsValidated(t *testing.T) {
+
+	b := &fakeBackend{}
+	_, err := NewFromConfig(b.config("options", 2, 1), WithConfig(func(c *Config) { c.DialRetryCount = 0 }))
+	if err == nil {
+		t.Fatalf("NewFromConfig accepted DialRetryCount 0 set by an option")
+	}
+
+	_, err = NewThriftClientPool("options", "127.0.0.1", "0", b.dial, b.close, b.keepAlive, 2, 1, WithConfig(func(c *Config) { c.KeepAlive = nil }))
+	if err == nil {
+		t.Fatalf("NewThriftClientPool accepted a nil KeepAlive set by an option")
+	}
+	if b.dialCount() != 0 {
+		t.Fatalf("rejected pools dialed %v connections", b.dialCount())
+	}
+}
+
+func TestWithConfigInitialSize(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("options", 4, 1), WithConfig(func(c *Config) { c.InitialPoolSize = 3 }))
+	if got := p.alivePool.Len(); got != 3 {
+		t.Fatalf("pool started with %v connections, want the option's 3", got)
+	}
+
+	b = &fakeBackend{}
+	clamp := WithConfig(func(c *Config) {
+		c.MaxPoolSize = 2
+		c.InitialSizePolicy = ClampInitialSize
+	})
+	p = newTestPool(t, b.config("options", 4, 4), clamp)
+	if got := p.alivePool.Len(); got != 2 {
+		t.Fatalf("pool started with %v connections, want it clamped to 2", got)
+	}
+
+	_, err := NewFromConfig(b.config("options", 4, 4), WithConfig(func(c *Config) { c.MaxPoolSize = 2 }))
+	if err == nil {
+		t.Fatalf("NewFromConfig accepted an initial size over the option's pool size")
+	}
+}
+
+func TestRegisterDefaultsAppliesByNamePrefix(t *testing.T) {
+
+	RegisterDefaults("org.", WithConfig(func(c *Config) {
+		c.MinIdle = 1
+		c.DialRetryCount = 5
+	}))
+	RegisterDefaults("org.billing.", WithConfig(func(c *Config) { c.DialRetryCount = 7 }))
+	t.Cleanup(func() {
+		UnregisterDefaults("org.")
+		UnregisterDefaults("org.billing.")
+	})
+
+	b := &fakeBackend{}
+	billing := newTestPool(t, b.config("org.billing.invoices", 2, 0))
+	if got := billing.cfg().MinIdle; got != 1 {
+		t.Errorf("matching pool has MinIdle %v, want the default 1", got)
+	}
+	if got := billing.cfg().DialRetryCount; got != 7 {
+		t.Errorf("matching pool has DialRetryCount %v, want the longer prefix's 7", got)
+	}
+
+	overridden := newTestPool(t, b.config("org.billing.refunds", 2, 0), WithConfig(func(c *Config) { c.DialRetryCount = 2 }))
+	if got := overridden.cfg().DialRetryCount; got != 2 {
+		t.Errorf("pool option was overridden by the defaults, DialRetryCount %v, want 2", got)
+	}
+
+	other := newTestPool(t, b.config("billing.org.", 2, 0))
+	if got := other.cfg().MinIdle; got != 0 {
+		t.Errorf("pool outside the prefix got MinIdle %v, want 0", got)
+	}
+}
+
+func TestExplicitSettingsWinOverRegisteredDefaults(t *testing.T) {
+
+	RegisterDefaults("explicit.", WithConfig(func(c *Config) {
+		c.MaxPoolSize = 9
+		c.MinIdle = 2
+	}))
+	t.Cleanup(func() { UnregisterDefaults("explicit.") })
+
+	b := &fakeBackend{}
+	config := b.config("explicit.config", 3, 0)
+	config.MinIdle = 1
+	fromConfig := newTestPool(t, config)
+	if got := fromConfig.cfg().MaxPoolSize; got != 3 {
+		t.Errorf("NewFromConfig pool has MaxPoolSize %v, want the explicit 3", got)
+	}
+	if got := fromConfig.cfg().MinIdle; got != 1 {
+		t.Errorf("NewFromConfig pool has MinIdle %v, want the explicit 1", got)
+	}
+
+	positional, err := NewThriftClientPool("explicit.positional", "127.0.0.1", "0", b.dial, b.close, b.keepAlive, 3, 0)
+	if err != nil {
+		t.Fatalf("NewThriftClientPool: %v", err)
+	}
+	defer positional.Release()
+	if got := positional.cfg().MaxPoolSize; got != 3 {
+		t.Errorf("NewThriftClientPool pool has MaxPoolSize %v, want the explicit 3", got)
+	}
+	if got := positional.cfg().MinIdle; got != 2 {
+		t.Errorf("NewThriftClientPool pool has MinIdle %v, want the default 2", got)
+	}
+
+	UnregisterDefaults("explicit.")
+	unregistered := newTestPool(t, b.config("explicit.after", 3, 0))
+	if got := unregistered.cfg().MinIdle; got != 0 {
+		t.Errorf("pool built after UnregisterDefaults has MinIdle %v, want 0", got)
+	}
+}
@@ -0,0 +1,176 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Permit reserves one slot of the pool, the connection is obtained lazily. The
+// slot is an idle connection taken out of the store, counted in permitConns, or
+// room to dial one, counted in permitSlots, so Gets can not use it meanwhile.
+type Permit struct {
+	pool       *ThriftClientPool
+	connection interface{}
+	idle       bool
+	reserved   bool
+	admitted   bool
+	released   bool
+	sync       sync.Mutex
+}
+
+var ErrPermitReleased = errors.New("permit was released.")
+
+// AcquirePermit waits until the pool has a slot to reserve or ctx is done. It
+// is admitted like Get, a permit counts against the concurrency limit until
+// its connection is borrowed or it is released.
+func (p *ThriftClientPool) AcquirePermit(ctx context.Context) (*Permit, error) {
+
+	admitted, err := p.beginGet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if !p.serving() {
+			err = ErrPoolNotRunning
+			break
+		}
+
+		ready := p.idleReady()
+		if permit, ok := p.reservePermit(); ok {
+			permit.admitted = admitted
+			return permit, nil
+		}
+
+		select {
+		case <-ready:
+		case <-time.After(p.safeInterval("CreateNewInterval", p.cfg().CreateNewInterval)):
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	if admitted {
+		p.admitDone()
+	}
+	return nil, err
+}
+
+// admitDone must be called with permit.sync held, it hands the admission of
+// the permit back once the borrowed connection counts instead.
+func (permit *Permit) admitDone() {
+
+	if permit.admitted {
+		permit.admitted = false
+		permit.pool.admitDone()
+	}
+}
+
+func (p *ThriftClientPool) reservePermit() (permit *Permit, ok bool) {
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	// counted before the pop so totalConnCount never misses the connection.
+	atomic.AddInt32(&p.permitConns, 1)
+	if connection, ok := p.popAlive(); ok {
+		return &Permit{pool: p, connection: connection, idle: true, reserved: true}, true
+	}
+	atomic.AddInt32(&p.permitConns, -1)
+
+	if p.totalConnCount() < p.dialLimit() {
+		atomic.AddInt32(&p.permitSlots, 1)
+		return &Permit{pool: p, reserved: true}, true
+	}
+	return nil, false
+}
+
+func (permit *Permit) Connection() (connection interface{}, err error) {
+	return permit.ConnectionContext(context.Background())
+}
+
+// ConnectionContext borrows the connection of the permit, dialing into the
+// reserved slot when no idle connection was reserved, and returns the same
+// connection on later calls.
+func (permit *Permit) ConnectionContext(ctx context.Context) (connection interface{}, err error) {
+
+	permit.sync.Lock()
+	defer permit.sync.Unlock()
+
+	if permit.released {
+		return nil, ErrPermitReleased
+	}
+	if permit.connection != nil && !permit.idle {
+		return permit.connection, nil
+	}
+
+	p := permit.pool
+	defer permit.admitDone()
+	if permit.idle {
+		connection, permit.connection, permit.idle = permit.connection, nil, false
+		// a failed probe frees the connection, the permit keeps its slot to dial.
+		atomic.AddInt32(&p.permitSlots, 1)
+		atomic.AddInt32(&p.permitConns, -1)
+		p.borrowConnection(connection)
+		if p.probeBorrowed(connection) {
+			atomic.AddInt32(&p.permitSlots, -1)
+			permit.connection, permit.reserved = connection, false
+			return connection, nil
+		}
+	}
+
+	if permit.reserved {
+		permit.reserved = false
+		connection, err = p.createReserved(ctx)
+	} else {
+		// GetContext is admitted on its own.
+		permit.admitDone()
+		connection, err = p.GetContext(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	permit.connection = connection
+	return connection, nil
+}
+
+// Release returns the connection or the reserved slot of the permit, later
+// calls do nothing.
+func (permit *Permit) Release() {
+
+	permit.sync.Lock()
+	if permit.released {
+		permit.sync.Unlock()
+		return
+	}
+	connection, idle, reserved := permit.connection, permit.idle, permit.reserved
+	permit.connection, permit.released = nil, true
+	permit.admitDone()
+	permit.sync.Unlock()
+
+	p := permit.pool
+	switch {
+	case idle:
+		var closing closeQueue
+		p.sync.Lock()
+		if p.stopped() {
+			closing.discard = append(closing.discard, connection)
+		} else {
+			p.restoreIdle([]interface{}{connection})
+		}
+		atomic.AddInt32(&p.permitConns, -1)
+		p.unlock()
+		p.closeQueued(&closing)
+	case reserved:
+		atomic.AddInt32(&p.permitSlots, -1)
+	case connection != nil:
+		p.Put(connection)
+	}
+}
@@ -0,0 +1,182 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPermitsBoundWorkToPoolSize(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("permit", 2, 1))
+
+	first, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit: %v", err)
+	}
+	second, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit: %v", err)
+	}
+
+	if _, err := p.Get(); err == nil {
+		t.Fatalf("Get took a slot reserved by a permit")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := p.AcquirePermit(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("third AcquirePermit returned %v, want DeadlineExceeded", err)
+	}
+
+	for _, permit := range []*Permit{first, second} {
+		if _, err := permit.Connection(); err != nil {
+			t.Fatalf("Connection: %v", err)
+		}
+	}
+	if b.dialCount() != 2 || p.TotalConns() != 2 {
+		t.Fatalf("permits made %v dials for %v connections, want 2 and 2", b.dialCount(), p.TotalConns())
+	}
+
+	first.Release()
+	second.Release()
+	if p.alivePool.Len() != 2 {
+		t.Fatalf("released permits left %v idle connections, want 2", p.alivePool.Len())
+	}
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatalf("CheckIntegrity: %v", err)
+	}
+}
+
+func TestPermitsFollowResize(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("permit", 2, 0))
+	if err := p.Resize(1); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+
+	permit, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit: %v", err)
+	}
+	defer permit.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := p.AcquirePermit(ctx); err == nil {
+		t.Fatalf("AcquirePermit reserved past the resized pool")
+	}
+}
+
+func TestPermitConnectionAfterRelease(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("permit", 1, 0))
+
+	permit, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit: %v", err)
+	}
+	permit.Release()
+	permit.Release()
+
+	if _, err := permit.Connection(); err != ErrPermitReleased {
+		t.Fatalf("Connection after Release returned %v, want ErrPermitReleased", err)
+	}
+	if b.dialCount() != 0 || p.TotalConns() != 0 {
+		t.Fatalf("released permit left %v dials and %v connections", b.dialCount(), p.TotalConns())
+	}
+}
+
+func TestPermitConnectionHonoursContext(t *testing.T) {
+
+	b, hanging, hang := hangingBackend()
+	defer close(hang)
+	atomic.StoreInt32(hanging, 1)
+	p := newTestPool(t, b.config("permit", 1, 0))
+
+	permit, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit: %v", err)
+	}
+	defer permit.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := permit.ConnectionContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("ConnectionContext on a hung dial returned %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestPermitsRespectConcurrencyLimit(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("permit", 2, 2))
+	p.SetConcurrencyLimit(1)
+
+	first, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := p.AcquirePermit(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("AcquirePermit over the concurrency limit returned %v, want DeadlineExceeded", err)
+	}
+
+	if _, err := first.Connection(); err != nil {
+		t.Fatalf("Connection: %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("GetContext next to a borrowed permit returned %v, want DeadlineExceeded", err)
+	}
+	if working := p.Stats().Working; working != 1 {
+		t.Fatalf("pool has %v working connections under a limit of 1", working)
+	}
+
+	first.Release()
+	second, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit after Release: %v", err)
+	}
+	if _, err := second.Connection(); err != nil {
+		t.Fatalf("Connection: %v", err)
+	}
+	second.Release()
+}
+
+func TestAcquirePermitStartsLazyPool(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("permit", 2, 1)
+	config.LazyStart = true
+	p := newTestPool(t, config)
+
+	permit, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit on a lazy pool: %v", err)
+	}
+	defer permit.Release()
+	waitFor(t, "the lazy pool to dial its initial connection", func() bool { return b.dialCount() >= 1 })
+}
+
+func TestPermitReleaseClosesWithoutLock(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("permit", 1, 1)
+	hung, unblock := hangFirstClose(b, &config)
+	p := newTestPool(t, config)
+	t.Cleanup(unblock)
+
+	permit, err := p.AcquirePermit(context.Background())
+	if err != nil {
+		t.Fatalf("AcquirePermit: %v", err)
+	}
+	p.Release()
+	go permit.Release()
+	waitUnlocked(t, p, hung)
+}
@@ -0,0 +1,113 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type preheatSchedule struct {
+	target int
+	at     time.Time
+}
+
+// ScheduleTarget asks the retry loop to dial idle connections up to target,
+// starting PreheatLeadTime before at, so the pool is warm when traffic arrives.
+// The preheat dials do not hold the pool lock, see fillIdle.
+func (p *ThriftClientPool) ScheduleTarget(target int, at time.Time) {
+
+	if target > p.cfg().MaxPoolSize {
+		target = p.cfg().MaxPoolSize
+	}
+
+	p.sync.Lock()
+	p.schedules = append(p.schedules, preheatSchedule{target: target, at: at})
+	p.unlock()
+}
+
+// WithClock makes the retry loop read the time from now instead of time.Now,
+// e.g. to move a test past the PreheatLeadTime of a schedule.
+func WithClock(now func() time.Time) Option {
+	return func(p *ThriftClientPool) {
+		p.clock = now
+	}
+}
+
+func (p *ThriftClientPool) now() time.Time {
+
+	if p.clock != nil {
+		return p.clock()
+	}
+	return time.Now()
+}
+
+func (p *ThriftClientPool) preheatTarget(now time.Time) (target int) {
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	pending := p.schedules[:0]
+	for _, schedule := range p.schedules {
+		if now.After(schedule.at) {
+			continue
+		}
+
+		pending = append(pending, schedule)
+		if now.Add(p.cfg().PreheatLeadTime).After(schedule.at) && schedule.target > target {
+			target = schedule.target
+		}
+	}
+	p.schedules = pending
+
+	return
+}
+
+func (p *ThriftClientPool) preheat(now time.Time) {
+	p.fillIdle(p.preheatTarget(now))
+}
+
+// warmOnFirstGet starts dialing toward MinIdle in the background on the first
+// Get, so the Gets after it find idle connections.
+func (p *ThriftClientPool) warmOnFirstGet() {
+
+	if p.cfg().WarmOnFirstGet {
+		p.warmOnce.Do(func() {
+			go p.runLoop("warmup", func() {
+				p.fillIdle(p.cfg().MinIdle)
+			})
+		})
+	}
+}
+
+// fillIdle dials idle connections until there are target of them, the pool is
+// full or a dial fails. The slot is reserved in dialsInFlight under p.sync and
+// the dial runs without it, so Get and Put go on meanwhile.
+func (p *ThriftClientPool) fillIdle(target int) {
+
+	for !p.stopped() && p.alivePool.Len() < target {
+		p.sync.Lock()
+		if p.totalConnCount() >= p.dialLimit() {
+			p.unlock()
+			return
+		}
+		atomic.AddInt32(&p.dialsInFlight, 1)
+		p.unlock()
+
+		connection, err := p.dialConnection()
+
+		var closing closeQueue
+		p.sync.Lock()
+		if err == nil && p.stopped() {
+			closing.discard = append(closing.discard, connection)
+		} else if err == nil {
+			p.pushAlive(connection)
+		}
+		atomic.AddInt32(&p.dialsInFlight, -1)
+		p.unlock()
+		p.closeQueued(&closing)
+
+		if err != nil {
+			p.logger().Warnf("Preheat pool failed: %v", err)
+			return
+		}
+	}
+}
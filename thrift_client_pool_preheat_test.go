@@ -0,0 +1,170 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// hangingBackend is a fakeBackend whose dials block once hanging is set, until hang is closed.
+func hangingBackend() (b *fakeBackend, hanging *int32, hang chan struct{}) {
+
+	b, hanging, hang = &fakeBackend{}, new(int32), make(chan struct{})
+	b.dialFn = func() error {
+		if atomic.LoadInt32(hanging) == 1 {
+			<-hang
+		}
+		return nil
+	}
+	return
+}
+
+// putDuring fails the test when Put of held waits behind the dial counted in
+// dialsInFlight. The dial only returns once hang is closed, so a Put that
+// returns first did not wait for it.
+func putDuring(t *testing.T, p *ThriftClientPool, held interface{}, hang chan struct{}) {
+
+	t.Helper()
+	waitFor(t, "dial in flight", func() bool { return atomic.LoadInt32(&p.dialsInFlight) == 1 })
+
+	put := make(chan error, 1)
+	go func() { put <- p.Put(held) }()
+	select {
+	case err := <-put:
+		if err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		close(hang)
+		t.Fatalf("Put waited behind a slow dial")
+	}
+	if atomic.LoadInt32(&p.dialsInFlight) != 1 {
+		t.Fatalf("slow dial returned before it was released")
+	}
+}
+
+func TestWarmOnFirstGetDialsWithoutLock(t *testing.T) {
+
+	b, hanging, hang := hangingBackend()
+	config := b.config("warm", 3, 1)
+	config.WarmOnFirstGet = true
+	config.MinIdle = 2
+	p := newTestPool(t, config)
+	atomic.StoreInt32(hanging, 1)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	putDuring(t, p, held, hang)
+	if got := p.TotalConns(); got != 2 {
+		t.Fatalf("TotalConns is %v with one idle and one warming, want 2", got)
+	}
+
+	close(hang)
+	waitFor(t, "warm up", func() bool { return p.alivePool.Len() == 2 && atomic.LoadInt32(&p.dialsInFlight) == 0 })
+}
+
+func TestPreheatDialsWithoutLock(t *testing.T) {
+
+	b, hanging, hang := hangingBackend()
+	config := b.config("preheat", 3, 1)
+	config.DialRetryInterval = time.Millisecond * 5
+	p := newTestPool(t, config)
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	atomic.StoreInt32(hanging, 1)
+
+	// within PreheatLeadTime, the next retry round preheats.
+	p.ScheduleTarget(2, time.Now().Add(time.Second*30))
+	putDuring(t, p, held, hang)
+
+	close(hang)
+	waitFor(t, "preheat", func() bool { return p.alivePool.Len() == 2 && atomic.LoadInt32(&p.dialsInFlight) == 0 })
+}
+
+func TestPreheatClosesDialAfterStopWithoutLock(t *testing.T) {
+
+	b, hanging, hang := hangingBackend()
+	config := b.config("preheat", 2, 0)
+	hung, unblock := hangFirstClose(b, &config)
+	p := newTestPool(t, config)
+	t.Cleanup(unblock)
+
+	atomic.StoreInt32(hanging, 1)
+	go p.fillIdle(1)
+	waitFor(t, "dial in flight", func() bool { return atomic.LoadInt32(&p.dialsInFlight) == 1 })
+	p.Release()
+	close(hang)
+	waitUnlocked(t, p, hung)
+}
+
+// fakeClock is a clock for WithClock that counts its reads, one per retry round.
+type fakeClock struct {
+	now   atomic.Value
+	reads int64
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+
+	clock := &fakeClock{}
+	clock.now.Store(now)
+	return clock
+}
+
+func (c *fakeClock) read() time.Time {
+
+	atomic.AddInt64(&c.reads, 1)
+	return c.now.Load().(time.Time)
+}
+
+func (c *fakeClock) set(now time.Time) {
+	c.now.Store(now)
+}
+
+// rounds waits until the retry loop read the clock n more times.
+func (c *fakeClock) rounds(t *testing.T, n int64) {
+
+	t.Helper()
+	reads := atomic.LoadInt64(&c.reads)
+	waitFor(t, "retry rounds", func() bool { return atomic.LoadInt64(&c.reads) >= reads+n })
+}
+
+func TestScheduledPreheatReachesTargetByScheduledTime(t *testing.T) {
+
+	b := &fakeBackend{}
+	start := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+	clock := newFakeClock(start)
+	config := b.config("preheat", 4, 1)
+	config.DialRetryInterval = time.Millisecond * 5
+	config.PreheatLeadTime = time.Minute
+	p := newTestPool(t, config, WithClock(clock.read))
+
+	at := start.Add(time.Hour)
+	p.ScheduleTarget(3, at)
+	clock.rounds(t, 2)
+	if got := b.dialCount(); got != 1 {
+		t.Fatalf("pool dialed %v connections an hour before the schedule, want 1", got)
+	}
+
+	clock.set(at.Add(-time.Minute * 2))
+	clock.rounds(t, 2)
+	if got := b.dialCount(); got != 1 {
+		t.Fatalf("pool dialed %v connections before PreheatLeadTime, want 1", got)
+	}
+
+	clock.set(at.Add(-time.Second * 30))
+	waitFor(t, "preheat to the target", func() bool { return p.alivePool.Len() == 3 })
+	if now := clock.read(); now.After(at) {
+		t.Fatalf("target reached at %v, after the scheduled %v", now, at)
+	}
+
+	// the schedule is done once its time passed, no more dials.
+	clock.set(at.Add(time.Minute))
+	clock.rounds(t, 2)
+	if got := b.dialCount(); got != 3 {
+		t.Fatalf("pool dialed %v connections after the schedule, want 3", got)
+	}
+}
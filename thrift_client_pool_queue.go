@@ -0,0 +1,82 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"sync"
+)
+
+type queueWaiter struct {
+	priority int
+	turn     chan struct{}
+}
+
+// waitQueue lets Get callers through one at a time, higher priority first and
+// in arrival order among equal priorities.
+type waitQueue struct {
+	sync    sync.Mutex
+	waiters []*queueWaiter
+	busy    bool
+}
+
+func (q *waitQueue) enter(ctx context.Context, priority int) error {
+
+	q.sync.Lock()
+	if !q.busy {
+		q.busy = true
+		q.sync.Unlock()
+		return nil
+	}
+
+	w := &queueWaiter{priority: priority, turn: make(chan struct{})}
+	i := len(q.waiters)
+	for i > 0 && q.waiters[i-1].priority < priority {
+		i--
+	}
+	q.waiters = append(q.waiters, nil)
+	copy(q.waiters[i+1:], q.waiters[i:])
+	q.waiters[i] = w
+	q.sync.Unlock()
+
+	select {
+	case <-w.turn:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.sync.Lock()
+	for i, waiter := range q.waiters {
+		if waiter == w {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			q.sync.Unlock()
+			return ctx.Err()
+		}
+	}
+	q.sync.Unlock()
+
+	// the turn was handed over while giving up, pass it on.
+	q.leave()
+	return ctx.Err()
+}
+
+// queued returns the callers waiting for their turn.
+func (q *waitQueue) queued() int {
+
+	q.sync.Lock()
+	defer q.sync.Unlock()
+	return len(q.waiters)
+}
+
+func (q *waitQueue) leave() {
+
+	q.sync.Lock()
+	defer q.sync.Unlock()
+
+	if len(q.waiters) == 0 {
+		q.busy = false
+		return
+	}
+
+	w := q.waiters[0]
+	q.waiters = q.waiters[1:]
+	close(w.turn)
+}
@@ -0,0 +1,184 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWaitQueueServesHigherPriorityFirst(t *testing.T) {
+
+	q := &waitQueue{}
+	if err := q.enter(context.Background(), 0); err != nil {
+		t.Fatalf("enter on an idle queue: %v", err)
+	}
+
+	order := make(chan int, 3)
+	for i, priority := range []int{1, 3, 2} {
+		go func(priority int) {
+			q.enter(context.Background(), priority)
+			order <- priority
+			q.leave()
+		}(priority)
+		waitFor(t, "waiter queued", func() bool { return q.queued() == i+1 })
+	}
+
+	q.leave()
+	for _, want := range []int{3, 2, 1} {
+		select {
+		case got := <-order:
+			if got != want {
+				t.Fatalf("served priority %v, want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter was not served")
+		}
+	}
+}
+
+func TestWaitQueueDropsCancelledWaiter(t *testing.T) {
+
+	q := &waitQueue{}
+	q.enter(context.Background(), 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := q.enter(ctx, 0); err != context.DeadlineExceeded {
+		t.Fatalf("enter returned %v, want DeadlineExceeded", err)
+	}
+	if queued := q.queued(); queued != 0 {
+		t.Fatalf("queued after the waiter gave up = %v, want 0", queued)
+	}
+
+	q.leave()
+	if err := q.enter(context.Background(), 0); err != nil {
+		t.Fatalf("enter after leave: %v", err)
+	}
+}
+
+func TestFairQueueServesWaitersInArrivalOrder(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("fifo", 1, 1)
+	config.FairQueue = true
+	config.CreateNewInterval = time.Second
+	p := newTestPool(t, config)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	order := make(chan int, 4)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			connection, err := p.Get()
+			if err != nil {
+				order <- -1
+				return
+			}
+			order <- i
+			p.Put(connection)
+		}(i)
+		waitFor(t, "waiter queued", func() bool { return p.waitingGets() == i+1 })
+	}
+
+	p.Put(held)
+	for want := 0; want < 4; want++ {
+		select {
+		case got := <-order:
+			if got != want {
+				t.Fatalf("served waiter %v, want %v", got, want)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("waiter was not served")
+		}
+	}
+}
+
+func TestFairQueueDialsConcurrently(t *testing.T) {
+
+	inFlight, overlapped := int32(0), int32(0)
+	b := &fakeBackend{}
+	b.dialFn = func() error {
+		// every dial waits until all four overlap, the queue must not serialize them.
+		atomic.AddInt32(&inFlight, 1)
+		deadline := time.Now().Add(time.Second * 2)
+		for atomic.LoadInt32(&inFlight) < 4 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if atomic.LoadInt32(&inFlight) == 4 {
+			atomic.AddInt32(&overlapped, 1)
+		}
+		return nil
+	}
+	config := b.config("fifodial", 4, 0)
+	config.FairQueue = true
+	config.DialTimeout = time.Second * 5
+	p := newTestPool(t, config)
+
+	got := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			connection, err := p.Get()
+			got <- err
+			if err == nil {
+				defer p.Put(connection)
+			}
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-got; err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&overlapped); n != 4 {
+		t.Fatalf("%v of 4 dials overlapped, queued Gets dialed one at a time", n)
+	}
+}
+
+func TestGetWithPriorityServesHigherPriorityFirst(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("priority", 1, 1)
+	config.CreateNewInterval = time.Second * 5
+	p := newTestPool(t, config)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	// the first waiter holds the queue turn, the later ones line up by priority.
+	order := make(chan int, 3)
+	for i, priority := range []int{0, 1, 5} {
+		go func(priority int) {
+			connection, err := p.GetWithPriority(context.Background(), priority)
+			if err != nil {
+				t.Errorf("GetWithPriority(%v): %v", priority, err)
+				order <- -1
+				return
+			}
+			order <- priority
+			p.Put(connection)
+		}(priority)
+		if i == 0 {
+			waitFor(t, "the first waiter in get", func() bool { return atomic.LoadInt32(&p.pendingGets) == 1 })
+		} else {
+			waitFor(t, "waiter queued", func() bool { return p.waiters.queued() == i })
+		}
+	}
+
+	p.Put(held)
+	for _, want := range []int{0, 5, 1} {
+		select {
+		case got := <-order:
+			if got != want {
+				t.Fatalf("served priority %v, want %v", got, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("waiter was not served")
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package thrift_clientpool
+
+import (
+	"context"
+)
+
+// GetFor borrows a connection on behalf of caller. With CallerQuota set a caller
+// holds at most CallerQuota connections, its further GetFor wait until one of
+// them is returned or ctx is done while other callers go on borrowing. With
+// DrainKeep a draining pool still hands a caller the connections it returned.
+func (p *ThriftClientPool) GetFor(ctx context.Context, caller string) (connection interface{}, err error) {
+
+	quota := p.cfg().CallerQuota
+	if quota > 0 {
+		if err = p.acquireCaller(ctx, caller, quota); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.State() == StateDraining && p.cfg().DrainPolicy == DrainKeep {
+		connection, err = p.reclaim(caller)
+	} else {
+		connection, err = p.GetContext(ctx)
+	}
+
+	if err != nil {
+		if quota > 0 {
+			p.callerDone(caller)
+		}
+		return nil, err
+	}
+
+	p.connsSync.Lock()
+	if conn, ok := p.conns[connection]; ok {
+		conn.lastCaller = caller
+		if quota > 0 {
+			conn.caller = caller
+		}
+	}
+	p.connsSync.Unlock()
+	return
+}
+
+// reclaim borrows an idle connection last returned by caller.
+func (p *ThriftClientPool) reclaim(caller string) (connection interface{}, err error) {
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	p.filterIdle(func(c interface{}) bool {
+		if connection == nil {
+			p.connsSync.Lock()
+			conn, ok := p.conns[c]
+			mine := ok && conn.lastCaller == caller
+			p.connsSync.Unlock()
+
+			if mine {
+				connection = c
+				return false
+			}
+		}
+		return true
+	})
+
+	if connection == nil {
+		return nil, ErrPoolNotRunning
+	}
+
+	p.borrowConnection(connection)
+	return connection, nil
+}
+
+func (p *ThriftClientPool) acquireCaller(ctx context.Context, caller string, quota int) error {
+
+	for {
+		p.callersSync.Lock()
+		if p.callersHeld[caller] < quota {
+			if p.callersHeld == nil {
+				p.callersHeld = map[string]int{}
+			}
+			p.callersHeld[caller]++
+			p.callersSync.Unlock()
+			return nil
+		}
+		if p.callersFreed == nil {
+			p.callersFreed = make(chan struct{})
+		}
+		freed := p.callersFreed
+		p.callersSync.Unlock()
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// lastCallerOf returns the caller that borrowed connection with GetFor, "" for a plain Get.
+func (p *ThriftClientPool) lastCallerOf(connection interface{}) string {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	if conn, ok := p.conns[connection]; ok {
+		return conn.lastCaller
+	}
+	return ""
+}
+
+// releaseCaller gives the quota taken by a borrowed connection back to its caller.
+func (p *ThriftClientPool) releaseCaller(connection interface{}) {
+
+	p.connsSync.Lock()
+	caller := ""
+	conn, ok := p.conns[connection]
+	if ok {
+		caller, conn.caller = conn.caller, ""
+	}
+	p.connsSync.Unlock()
+
+	if caller != "" {
+		p.callerDone(caller)
+	}
+}
+
+func (p *ThriftClientPool) callerDone(caller string) {
+
+	p.callersSync.Lock()
+	defer p.callersSync.Unlock()
+
+	if p.callersHeld[caller]--; p.callersHeld[caller] <= 0 {
+		delete(p.callersHeld, caller)
+	}
+	if p.callersFreed != nil {
+		close(p.callersFreed)
+		p.callersFreed = nil
+	}
+}
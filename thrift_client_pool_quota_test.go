@@ -0,0 +1,115 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// drainKept starts draining p and returns once connection is returned and the drain is done.
+func drainKept(t *testing.T, p *ThriftClientPool, connection interface{}) {
+
+	t.Helper()
+	drained := make(chan error, 1)
+	go func() { drained <- p.Drain(context.Background()) }()
+	waitFor(t, "draining", func() bool { return p.State() == StateDraining })
+
+	p.Put(connection)
+	select {
+	case err := <-drained:
+		if err != nil {
+			t.Fatalf("Drain: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Drain did not finish")
+	}
+}
+
+func TestDrainKeepReclaimsForReturningCaller(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("drainkeep", 1, 1)
+	config.DrainPolicy = DrainKeep
+	p := newTestPool(t, config)
+
+	held, err := p.GetFor(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("GetFor: %v", err)
+	}
+	drainKept(t, p, held)
+
+	if _, err := p.GetFor(context.Background(), "b"); err != ErrPoolNotRunning {
+		t.Fatalf("GetFor of another caller while draining returned %v, want ErrPoolNotRunning", err)
+	}
+	connection, err := p.GetFor(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("GetFor of the returning caller while draining: %v", err)
+	}
+	if connection != held {
+		t.Fatal("GetFor reclaimed another connection")
+	}
+	p.Put(connection)
+}
+
+func TestDrainKeepForgetsCallerOnPlainGet(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("drainforget", 1, 1)
+	config.DrainPolicy = DrainKeep
+	p := newTestPool(t, config)
+
+	connection, err := p.GetFor(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("GetFor: %v", err)
+	}
+	p.Put(connection)
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	drainKept(t, p, held)
+
+	if _, err := p.GetFor(context.Background(), "a"); err != ErrPoolNotRunning {
+		t.Fatalf("GetFor after a plain Get returned the connection gave %v, want ErrPoolNotRunning", err)
+	}
+	// no caller can reclaim it, so it is not kept until Release.
+	if !held.(*fakeConn).isClosed() || p.alivePool.Len() != 0 || p.IsManaged(held) {
+		t.Fatalf("connection of a plain Get was kept while draining, %v idle", p.alivePool.Len())
+	}
+}
+
+func TestCallerQuotaLimitsOneCallerOnly(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("quota", 4, 4)
+	config.CallerQuota = 1
+	p := newTestPool(t, config)
+
+	held, err := p.GetFor(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("GetFor: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if _, err := p.GetFor(ctx, "a"); err != context.DeadlineExceeded {
+		t.Fatalf("GetFor over the quota returned %v, want it to wait until ctx is done", err)
+	}
+
+	others := []interface{}{}
+	for _, caller := range []string{"b", "c"} {
+		connection, err := p.GetFor(context.Background(), caller)
+		if err != nil {
+			t.Fatalf("GetFor of caller %v beside a full quota: %v", caller, err)
+		}
+		others = append(others, connection)
+	}
+
+	p.Put(held)
+	connection, err := p.GetFor(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("GetFor after the caller returned its connection: %v", err)
+	}
+	p.PutAll(append(others, connection))
+}
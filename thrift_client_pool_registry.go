@@ -0,0 +1,66 @@
+package thrift_clientpool
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	registry     = map[*ThriftClientPool]uint64{}
+	registryNext uint64
+	registrySync sync.Mutex
+)
+
+// register adds a built pool to the process registry. The registry holds the
+// pool strongly, so ReleaseContext removes it on every call, before anything
+// that could return early.
+func (p *ThriftClientPool) register() {
+
+	registrySync.Lock()
+	defer registrySync.Unlock()
+
+	registryNext++
+	registry[p] = registryNext
+}
+
+func (p *ThriftClientPool) unregister() {
+
+	registrySync.Lock()
+	defer registrySync.Unlock()
+
+	delete(registry, p)
+}
+
+// ownerOf returns the registered pool tracking connection, nil when none does.
+func ownerOf(connection interface{}) *ThriftClientPool {
+
+	registrySync.Lock()
+	defer registrySync.Unlock()
+
+	for pool := range registry {
+		pool.connsSync.Lock()
+		_, ok := pool.conns[connection]
+		pool.connsSync.Unlock()
+		if ok {
+			return pool
+		}
+	}
+	return nil
+}
+
+// ListPools returns every pool built in this process and not released yet,
+// oldest first, e.g. for an admin endpoint listing Name and Stats.
+func ListPools() []*ThriftClientPool {
+
+	registrySync.Lock()
+	defer registrySync.Unlock()
+
+	pools := make([]*ThriftClientPool, 0, len(registry))
+	for pool := range registry {
+		pools = append(pools, pool)
+	}
+	sort.Slice(pools, func(i, j int) bool {
+		return registry[pools[i]] < registry[pools[j]]
+	})
+	return pools
+}
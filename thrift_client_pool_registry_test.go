@@ -0,0 +1,71 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+)
+
+func listed(p *ThriftClientPool) bool {
+
+	for _, pool := range ListPools() {
+		if pool == p {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReleaseUnregisters(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("registry", 2, 1))
+	if !listed(p) {
+		t.Fatal("built pool missing from ListPools")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.ReleaseContext(ctx)
+	if listed(p) {
+		t.Fatal("released pool still in ListPools")
+	}
+	if err := p.ReleaseContext(context.Background()); err != nil {
+		t.Fatalf("second ReleaseContext: %v", err)
+	}
+}
+
+func TestReleaseUnregistersAClosingPool(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("registryclosing", 2, 1))
+
+	// a pool that left StateRunning behind Release still registered.
+	p.transition(StateClosing, StateRunning)
+	p.Release()
+	if listed(p) {
+		t.Fatal("closing pool still in ListPools after Release")
+	}
+}
+
+func TestReleasedPoolIsCollected(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("registrygc", 2, 1)
+	p, err := NewFromConfig(config)
+	if err != nil {
+		t.Fatalf("NewFromConfig: %v", err)
+	}
+	p.PublishExpvar()
+
+	collected := int32(0)
+	runtime.SetFinalizer(p, func(*ThriftClientPool) { atomic.StoreInt32(&collected, 1) })
+	p.Release()
+	p = nil
+
+	waitFor(t, "released pool collected", func() bool {
+		runtime.GC()
+		return atomic.LoadInt32(&collected) == 1
+	})
+}
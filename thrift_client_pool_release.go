@@ -0,0 +1,97 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ReleaseContext moves a running or draining pool to StateClosing and closes
+// the idle connections with at most CloseConcurrency closes in flight. It
+// returns the close errors, or ctx.Err() when ctx is done first, the remaining
+// closes then finish in the background before the pool reaches StateClosed.
+// Releasing a pool again does nothing and returns nil. Every call drops the
+// pool from ListPools and its expvar, the process keeps no reference after it.
+func (p *ThriftClientPool) ReleaseContext(ctx context.Context) error {
+
+	p.unregister()
+	p.unpublishExpvar()
+
+	p.sync.Lock()
+	if !p.transition(StateClosing, StateRunning, StateDraining) {
+		// released before, the first call closes the connections.
+		p.unlock()
+		return nil
+	}
+
+	connections := []interface{}{}
+	for connection, ok := p.popAlive(); ok; connection, ok = p.popAlive() {
+		connections = append(connections, connection)
+	}
+	connections = append(connections, p.takeCooling()...)
+	p.unlock()
+	// stop the loops waiting to restart after a panic.
+	close(p.closing)
+	// wake Gets parked for the retry loop, they see the pool is not running.
+	p.wakeIdle()
+
+	workers := p.cfg().CloseConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan interface{}, len(connections))
+	for _, connection := range connections {
+		jobs <- connection
+	}
+	close(jobs)
+
+	failures := []string{}
+	failuresSync := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers && i < len(connections); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for connection := range jobs {
+				if err := p.releaseConnection(connection); err != nil {
+					failuresSync.Lock()
+					failures = append(failures, err.Error())
+					failuresSync.Unlock()
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		p.transition(StateClosed, StateClosing)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if len(failures) > 0 {
+		return errors.New(fmt.Sprintf("Release connection error: %v.", failures))
+	}
+	return nil
+}
+
+// releaseLateIdle closes the idle connections pooled after Release took them
+// out of the store. The loops and probes pool without p.sync, a push that
+// finds the pool stopped after it landed closes the store itself.
+func (p *ThriftClientPool) releaseLateIdle() {
+
+	if !p.stopped() {
+		return
+	}
+	for connection, ok := p.popAlive(); ok; connection, ok = p.popAlive() {
+		p.releaseConnection(connection)
+	}
+}
@@ -0,0 +1,175 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReleaseContextClosesConcurrently(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("releaseconcurrency", 4, 4)
+	config.CloseConcurrency = 2
+	config.Close = func(connection interface{}) error {
+		time.Sleep(time.Millisecond * 20)
+		return b.close(connection)
+	}
+	p := newTestPool(t, config)
+
+	start := time.Now()
+	if err := p.ReleaseContext(context.Background()); err != nil {
+		t.Fatalf("ReleaseContext: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Millisecond*80 {
+		t.Fatalf("4 closes took %v with 2 at a time", elapsed)
+	}
+	if state := p.State(); state != StateClosed {
+		t.Fatalf("State after ReleaseContext = %v, want closed", state)
+	}
+}
+
+func TestReleaseContextReturnsWhenDone(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("releasectx", 1, 1)
+	block := make(chan struct{})
+	closed := int32(0)
+	config.Close = func(connection interface{}) error {
+		<-block
+		atomic.StoreInt32(&closed, 1)
+		return b.close(connection)
+	}
+	p := newTestPool(t, config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := p.ReleaseContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("ReleaseContext returned %v, want DeadlineExceeded", err)
+	}
+	if state := p.State(); state != StateClosing {
+		t.Fatalf("State with a close in flight = %v, want closing", state)
+	}
+
+	close(block)
+	waitFor(t, "background close", func() bool { return p.State() == StateClosed })
+	if atomic.LoadInt32(&closed) != 1 {
+		t.Fatal("the background close did not run")
+	}
+}
+
+func TestReleaseContextReportsCloseErrors(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("releaseerr", 2, 2)
+	config.Close = func(connection interface{}) error { return errFakeClosed }
+	p := newTestPool(t, config)
+
+	if err := p.ReleaseContext(context.Background()); err == nil {
+		t.Fatal("ReleaseContext hid the close errors")
+	}
+}
+
+func TestRepeatedReleaseClosesOnce(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("rerelease", 3, 3))
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Release()
+		}()
+	}
+	wg.Wait()
+	p.Release()
+	if err := p.ReleaseContext(context.Background()); err != nil {
+		t.Fatalf("ReleaseContext on a released pool: %v", err)
+	}
+	if closes := b.closeCount(); closes != 2 {
+		t.Fatalf("repeated Release made %v closes, want the 2 idle connections", closes)
+	}
+
+	p.Put(held)
+	p.Release()
+	if closes := b.closeCount(); closes != 3 {
+		t.Fatalf("%v closes after returning the borrowed connection, want 3", closes)
+	}
+}
+
+func TestCloseTimeoutAbandonsHungClose(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("closetimeout", 2, 2)
+	config.CloseTimeout = time.Millisecond * 20
+	hang := make(chan struct{})
+	t.Cleanup(func() { close(hang) })
+	config.Close = func(connection interface{}) error {
+		<-hang
+		return b.close(connection)
+	}
+	p := newTestPool(t, config)
+
+	start := time.Now()
+	err := p.ReleaseContext(context.Background())
+	if err == nil || !strings.Contains(err.Error(), ErrCloseTimeout.Error()) {
+		t.Fatalf("ReleaseContext with hung closes returned %v, want the close timeouts", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Fatalf("ReleaseContext took %v with a 20ms CloseTimeout", elapsed)
+	}
+	if state := p.State(); state != StateClosed {
+		t.Fatalf("State after the abandoned closes = %v, want closed", state)
+	}
+	if forced := p.Stats().ForcedCloses; forced != 2 {
+		t.Fatalf("Stats.ForcedCloses = %v, want 2", forced)
+	}
+}
+
+func TestOnReleaseRunsOnceBeforeClose(t *testing.T) {
+
+	b := &fakeBackend{}
+	var events []string
+	var eventsSync sync.Mutex
+	record := func(event string, connection interface{}) {
+		eventsSync.Lock()
+		events = append(events, fmt.Sprintf("%v:%v", event, connection.(*fakeConn).id))
+		eventsSync.Unlock()
+	}
+	config := b.config("onrelease", 2, 2)
+	config.OnRelease = func(tag string, connection interface{}) { record("release", connection) }
+	config.Close = func(connection interface{}) error {
+		record("close", connection)
+		return b.close(connection)
+	}
+	p := newTestPool(t, config)
+
+	borrowed, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	idle := 3 - borrowed.(*fakeConn).id
+
+	p.Release()
+	p.Put(borrowed)
+	p.Release()
+
+	eventsSync.Lock()
+	defer eventsSync.Unlock()
+	want := []string{fmt.Sprintf("release:%v", idle), fmt.Sprintf("close:%v", idle), fmt.Sprintf("release:%v", borrowed.(*fakeConn).id), fmt.Sprintf("close:%v", borrowed.(*fakeConn).id)}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+}
@@ -0,0 +1,86 @@
+package thrift_clientpool
+
+import (
+	"errors"
+)
+
+// Resize changes MaxPoolSize at runtime, up to the size the pool was built with.
+// Shrinking drops pending retries and idle connections over the new size, borrowed
+// connections are closed on return instead of re-pooled until the pool fits.
+//
+// Like SetDial and ApplyConfig, Resize never touches a borrowed connection: it
+// stays usable until the caller returns it, and only then the new settings apply.
+func (p *ThriftClientPool) Resize(size int) error {
+
+	if size < 1 {
+		return errors.New("pool size less than 1.")
+	}
+
+	if size > p.capacity {
+		return errors.New("pool size greater than pool capacity.")
+	}
+
+	var closing closeQueue
+	defer p.closeQueued(&closing)
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	p.resize(size, &closing)
+	return nil
+}
+
+// resize must be called with p.sync held, the idle connections over size are
+// queued to closing.
+func (p *ThriftClientPool) resize(size int, closing *closeQueue) {
+
+	p.update(func(c *Config) {
+		c.MaxPoolSize = size
+	})
+
+	for p.totalConnCount() > size && p.popRetry() {
+	}
+
+	for p.totalConnCount() > size {
+		connection, ok := p.popAlive()
+		if !ok {
+			break
+		}
+		closing.discard = append(closing.discard, connection)
+	}
+}
+
+// SetDial replaces the dial function used for new connections, connections
+// already dialed, borrowed or idle, are kept.
+func (p *ThriftClientPool) SetDial(dialFn func(name, address, port string) (connection interface{}, err error)) error {
+
+	if dialFn == nil {
+		return errors.New("function not specified.")
+	}
+
+	p.update(func(c *Config) {
+		c.Dial = dialFn
+	})
+
+	return nil
+}
+
+// UpdateCallbacks swaps dial, close and keepalive together so they never
+// mismatch. New connections use the new callbacks, connections dialed before
+// keep being closed by the Close they were dialed with. KeepAliveContext is
+// cleared so keepAliveFn is the probe from now on.
+func (p *ThriftClientPool) UpdateCallbacks(dialFn func(name, address, port string) (connection interface{}, err error), closeFn func(connection interface{}) (err error), keepAliveFn func(connection interface{}) (err error)) error {
+
+	if dialFn == nil || closeFn == nil || keepAliveFn == nil {
+		return errors.New("function not specified.")
+	}
+
+	p.update(func(c *Config) {
+		c.Dial = dialFn
+		c.Close = closeFn
+		c.KeepAlive = keepAliveFn
+		c.KeepAliveContext = nil
+	})
+
+	return nil
+}
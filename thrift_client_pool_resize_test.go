@@ -0,0 +1,149 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestUpdateCallbacksReplacesKeepAliveContext(t *testing.T) {
+
+	old, next := &fakeBackend{}, &fakeBackend{}
+	var oldProbes int64
+	config := old.config("callbacks", 1, 1)
+	config.KeepAliveContext = func(ctx context.Context, connection interface{}) error {
+		atomic.AddInt64(&oldProbes, 1)
+		return nil
+	}
+	p := newTestPool(t, config)
+
+	if err := p.UpdateCallbacks(next.dial, next.close, next.keepAlive); err != nil {
+		t.Fatalf("UpdateCallbacks: %v", err)
+	}
+	if err := p.callKeepAlive(context.Background(), &fakeConn{}); err != nil {
+		t.Fatalf("callKeepAlive: %v", err)
+	}
+	if atomic.LoadInt64(&oldProbes) != 0 || next.keepAliveCount() != 1 {
+		t.Fatalf("probe went to the old KeepAliveContext %v times and the new KeepAlive %v times", oldProbes, next.keepAliveCount())
+	}
+}
+
+func TestUpdateCallbacksClosesWithOriginalClose(t *testing.T) {
+
+	old, next := &fakeBackend{}, &fakeBackend{}
+	p := newTestPool(t, old.config("callbacks", 3, 2))
+
+	borrowed, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := p.UpdateCallbacks(next.dial, next.close, next.keepAlive); err != nil {
+		t.Fatalf("UpdateCallbacks: %v", err)
+	}
+	connections := []interface{}{borrowed}
+	for i := 0; i < 2; i++ {
+		connection, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get after the swap: %v", err)
+		}
+		connections = append(connections, connection)
+	}
+	if old.dialCount() != 2 || next.dialCount() != 1 {
+		t.Fatalf("dialed %v old and %v new connections, want 2 and 1", old.dialCount(), next.dialCount())
+	}
+
+	for _, connection := range connections {
+		p.Put(connection)
+	}
+	p.Release()
+	if old.closeCount() != 2 || next.closeCount() != 1 {
+		t.Fatalf("old Close ran %v times and new Close %v times, want 2 and 1", old.closeCount(), next.closeCount())
+	}
+}
+
+func TestShrinkBelowInUseRetiresOnReturn(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("shrink", 4, 4))
+
+	borrowed := []interface{}{}
+	for i := 0; i < 4; i++ {
+		connection, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		borrowed = append(borrowed, connection)
+	}
+
+	if err := p.Resize(2); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	if closes := b.closeCount(); closes != 0 {
+		t.Fatalf("Resize closed %v borrowed connections", closes)
+	}
+	if _, err := p.Get(); err == nil {
+		t.Fatalf("Get past the shrunk size succeeded")
+	}
+
+	for i, connection := range borrowed {
+		p.Put(connection)
+		wantClosed := i < 2
+		if closed := connection.(*fakeConn).isClosed(); closed != wantClosed {
+			t.Fatalf("return %v closed = %v, want %v", i+1, closed, wantClosed)
+		}
+	}
+	if idle, total := p.alivePool.Len(), p.TotalConns(); idle != 2 || total != 2 {
+		t.Fatalf("shrunk pool has %v idle of %v connections, want 2 and 2", idle, total)
+	}
+	if dials := b.dialCount(); dials != 4 {
+		t.Fatalf("shrunk pool dialed %v times, want the initial 4", dials)
+	}
+}
+
+func TestHeldConnectionSurvivesResizeAndSetDial(t *testing.T) {
+
+	old, next := &fakeBackend{}, &fakeBackend{}
+	p := newTestPool(t, old.config("upgrade", 3, 2))
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	if err := p.Resize(1); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	if err := p.SetDial(next.dial); err != nil {
+		t.Fatalf("SetDial: %v", err)
+	}
+	config := p.ExportConfig()
+	config.MinIdle, config.InitialPoolSize = 1, 0
+	if err := p.ApplyConfig(config); err != nil {
+		t.Fatalf("ApplyConfig: %v", err)
+	}
+	if state, _ := p.connState(held); held.(*fakeConn).isClosed() || state != ConnInUse {
+		t.Fatalf("upgrade touched the held connection, closed %v", held.(*fakeConn).isClosed())
+	}
+	if closes := old.closeCount(); closes != 1 {
+		t.Fatalf("Resize to 1 closed %v idle connections, want the other one", closes)
+	}
+
+	if err := p.Put(held); err != nil || held.(*fakeConn).isClosed() {
+		t.Fatalf("Put of the held connection returned %v, closed %v", err, held.(*fakeConn).isClosed())
+	}
+	again, err := p.Get()
+	if err != nil || again != held {
+		t.Fatalf("Get after the upgrade returned %v, %v, want the pooled held connection", again, err)
+	}
+
+	if err := p.Resize(2); err != nil {
+		t.Fatalf("Resize: %v", err)
+	}
+	dialed, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if next.dialCount() != 1 || old.dialCount() != 2 {
+		t.Fatalf("new connection dialed old %v and new %v times, want the new Dial", old.dialCount(), next.dialCount())
+	}
+	p.PutAll([]interface{}{again, dialed})
+}
@@ -0,0 +1,63 @@
+package thrift_clientpool
+
+import (
+	"time"
+)
+
+// dialAddress returns the address handed to Dial. With a Resolver, e.g.
+// net.LookupHost, Address is re-resolved every ResolveInterval and new
+// connections rotate over the resolved addresses, so they follow DNS changes.
+// Addresses with an open breaker are skipped while healthy ones remain.
+// Dial should join address and port with net.JoinHostPort to support IPv6.
+func (p *ThriftClientPool) dialAddress() string {
+
+	config := p.cfg()
+	if config.Resolver == nil {
+		return config.Address
+	}
+
+	p.resolveSync.Lock()
+	defer p.resolveSync.Unlock()
+
+	if len(p.resolved) == 0 || time.Since(p.resolvedAt) >= config.ResolveInterval {
+		if addresses, err := p.callResolver(config.Resolver, config.Address); err == nil && len(addresses) > 0 {
+			p.resolved = addresses
+		} else {
+			p.logger().Warnf("Resolve %v failed, keep previous addresses: %v", config.Address, err)
+		}
+		p.resolvedAt = time.Now()
+	}
+
+	if len(p.resolved) == 0 {
+		return config.Address
+	}
+
+	for i := 0; i < len(p.resolved); i++ {
+		p.resolveNext = (p.resolveNext + 1) % len(p.resolved)
+		if p.endpointAllowed(p.resolved[p.resolveNext]) {
+			return p.resolved[p.resolveNext]
+		}
+	}
+
+	// every address is cooling down, keep rotating so dials still go somewhere.
+	p.resolveNext = (p.resolveNext + 1) % len(p.resolved)
+	return p.resolved[p.resolveNext]
+}
+
+// endpoints returns the addresses dials currently rotate over.
+func (p *ThriftClientPool) endpoints() []string {
+
+	p.resolveSync.Lock()
+	defer p.resolveSync.Unlock()
+
+	if len(p.resolved) > 0 {
+		return append([]string(nil), p.resolved...)
+	}
+	return []string{p.cfg().Address}
+}
+
+func (p *ThriftClientPool) callResolver(resolver func(host string) ([]string, error), host string) (addresses []string, err error) {
+
+	defer p.recoverCallback("Resolver", &err)
+	return resolver(host)
+}
@@ -0,0 +1,55 @@
+package thrift_clientpool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResolverRotatesAndSkipsOpenBreakers(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("resolve", 4, 0)
+	config.Resolver = func(host string) ([]string, error) { return []string{"10.0.0.1", "10.0.0.2"}, nil }
+	config.ResolveInterval = time.Hour
+	config.EndpointFailureThreshold = 1
+	config.EndpointCooldown = time.Hour
+	p := newTestPool(t, config)
+
+	first, second := p.dialAddress(), p.dialAddress()
+	if first == second {
+		t.Fatalf("dialAddress returned %v twice, want a rotation", first)
+	}
+
+	p.reportEndpoint("10.0.0.1", errFakeDown)
+	for i := 0; i < 3; i++ {
+		if address := p.dialAddress(); address != "10.0.0.2" {
+			t.Fatalf("dialAddress = %v with the breaker of 10.0.0.1 open", address)
+		}
+	}
+	if endpoints := p.endpoints(); len(endpoints) != 2 {
+		t.Fatalf("endpoints = %v, want both resolved addresses", endpoints)
+	}
+}
+
+func TestResolverFailureKeepsPreviousAddresses(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("resolvefail", 2, 0)
+	fail := false
+	config.Resolver = func(host string) ([]string, error) {
+		if fail {
+			return nil, errFakeDown
+		}
+		return []string{"10.0.0.3"}, nil
+	}
+	config.ResolveInterval = time.Nanosecond
+	p := newTestPool(t, config)
+
+	if address := p.dialAddress(); address != "10.0.0.3" {
+		t.Fatalf("dialAddress = %v, want 10.0.0.3", address)
+	}
+	fail = true
+	if address := p.dialAddress(); address != "10.0.0.3" {
+		t.Fatalf("dialAddress after a failed resolve = %v, want 10.0.0.3", address)
+	}
+}
@@ -0,0 +1,67 @@
+package thrift_clientpool
+
+import "time"
+
+// pushRetry leaves a slot for the retry loop to dial, remembering when it was
+// queued. With every slot of the pool already queued the slot is dropped, the
+// pool then holds one connection less until Get dials it.
+func (p *ThriftClientPool) pushRetry() {
+
+	p.retrySync.Lock()
+	defer p.retrySync.Unlock()
+
+	select {
+	case p.retryQueue() <- 0:
+		p.retryQueued = append(p.retryQueued, time.Now())
+	default:
+		p.logger().Warnf("Retry pool of %v is full, drop retry slot.", p.Name())
+	}
+}
+
+// replaceConnection dials at once for the retry slot a keepalive eviction left,
+// so the idle count holds. On failure the slot stays with the retry loop.
+func (p *ThriftClientPool) replaceConnection() {
+
+	connection, err := p.dialConnection()
+	if err != nil {
+		p.logger().Warnf("Replace evicted connection on %v failed, leave it to retry loop: %v", p.Name(), err)
+		return
+	}
+
+	if !p.popRetry() {
+		// the retry loop or Resize took the slot meanwhile.
+		p.discardConnection(connection)
+		return
+	}
+	p.pushAlive(connection)
+	p.logger().Infof("Replaced evicted connection on %v.", p.Name())
+}
+
+func (p *ThriftClientPool) popRetry() bool {
+
+	select {
+	case <-p.retryPool():
+	default:
+		return false
+	}
+
+	p.retrySync.Lock()
+	if len(p.retryQueued) > 0 {
+		p.retryQueued = p.retryQueued[1:]
+	}
+	p.retrySync.Unlock()
+
+	return true
+}
+
+// oldestRetry returns how long the oldest pending retry slot has been waiting.
+func (p *ThriftClientPool) oldestRetry() time.Duration {
+
+	p.retrySync.Lock()
+	defer p.retrySync.Unlock()
+
+	if len(p.retryQueued) == 0 {
+		return 0
+	}
+	return time.Since(p.retryQueued[0])
+}
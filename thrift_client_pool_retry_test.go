@@ -0,0 +1,209 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetrySlotsAreStampedAndTrimmed(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("retry", 2, 0))
+
+	if oldest := p.oldestRetry(); oldest != 0 {
+		t.Fatalf("oldestRetry without slots = %v", oldest)
+	}
+	p.pushRetry()
+	time.Sleep(time.Millisecond * 10)
+	p.pushRetry()
+
+	if oldest := p.oldestRetry(); oldest < time.Millisecond*10 {
+		t.Fatalf("oldestRetry = %v, want at least 10ms", oldest)
+	}
+	if retry := p.Stats().Retry; retry != 2 {
+		t.Fatalf("Stats.Retry = %v, want 2", retry)
+	}
+
+	if !p.popRetry() || !p.popRetry() {
+		t.Fatal("popRetry missed a queued slot")
+	}
+	if p.popRetry() {
+		t.Fatal("popRetry took a slot from an empty retry channel")
+	}
+	if oldest := p.oldestRetry(); oldest != 0 {
+		t.Fatalf("oldestRetry after popping = %v, want 0", oldest)
+	}
+}
+
+// panicOnceLogger panics the first time the retry loop logs its start.
+type panicOnceLogger struct {
+	recordLogger
+	panicked int32
+}
+
+func (l *panicOnceLogger) Debugf(format string, v ...interface{}) {
+
+	if format == "retry loop start." && atomic.CompareAndSwapInt32(&l.panicked, 0, 1) {
+		panic("bad logger")
+	}
+	l.recordLogger.Debugf(format, v...)
+}
+
+func TestRetryLoopRestartsAfterPanic(t *testing.T) {
+
+	b := &fakeBackend{}
+	logger := &panicOnceLogger{recordLogger: recordLogger{level: LevelWarn}}
+	config := b.config("restart", 2, 0)
+	config.DialRetryInterval = time.Millisecond * 5
+	config.Logger = logger
+	p, err := NewFromConfig(config)
+	if err != nil {
+		t.Fatalf("NewFromConfig: %v", err)
+	}
+	p.pushRetry()
+	p.Start()
+	t.Cleanup(p.Release)
+
+	waitFor(t, "the restarted loop to redial", func() bool { return p.alivePool.Len() == 1 })
+	if restarts := p.Stats().LoopRestarts; restarts != 1 {
+		t.Fatalf("LoopRestarts = %v, want 1", restarts)
+	}
+	if !logger.logged("retry loop of pool restart recovered from panic: bad logger") {
+		t.Fatal("the loop panic was not logged")
+	}
+}
+
+// alwaysPanickingBackoff panics on every Next and Reset.
+type alwaysPanickingBackoff struct{}
+
+func (alwaysPanickingBackoff) Next(attempt int) time.Duration { panic("bad backoff") }
+func (alwaysPanickingBackoff) Reset()                         { panic("bad backoff reset") }
+
+func TestPanickingBackoffFallsBackToRetryInterval(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("backoff-panic", 2, 0)
+	config.DialRetryInterval = time.Millisecond * 5
+	config.Backoff = alwaysPanickingBackoff{}
+	p, err := NewFromConfig(config)
+	if err != nil {
+		t.Fatalf("NewFromConfig: %v", err)
+	}
+	p.pushRetry()
+	p.Start()
+	t.Cleanup(p.Release)
+
+	waitFor(t, "the retry loop to redial", func() bool { return p.alivePool.Len() == 1 })
+
+	// Get backs off between its dials with p.sync released, a panic there must not escape.
+	if _, err := p.Get(); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	b.setDown(true)
+	if _, err := p.Get(); !errors.Is(err, ErrDialFailed) {
+		t.Fatalf("Get against a backend that is down = %v, want ErrDialFailed", err)
+	}
+	if restarts := p.Stats().LoopRestarts; restarts != 0 {
+		t.Fatalf("LoopRestarts = %v, want 0 with the Backoff panics recovered", restarts)
+	}
+}
+
+func TestLoopRestartsBackOffUntilRelease(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("restart-delay", 1, 0))
+
+	done := make(chan struct{})
+	go func() {
+		p.runLoop("panicking", func() { panic("every round") })
+		close(done)
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	// MinInterval doubling fits at most 4 restarts in 100ms.
+	if restarts := p.Stats().LoopRestarts; restarts < 1 || restarts > 5 {
+		t.Fatalf("LoopRestarts in 100ms = %v, want a few", restarts)
+	}
+
+	p.Release()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the restart delay did not end with Release")
+	}
+}
+
+func TestReplaceOnEvictRedialsWithoutTheRetryLoop(t *testing.T) {
+
+	b := &fakeBackend{}
+	b.keepAliveFn = func(c *fakeConn) error {
+		if c.id == 1 {
+			return errFakeDown
+		}
+		return nil
+	}
+	config := b.config("replace", 2, 2)
+	config.ReplaceOnEvict = true
+	p := newTestPool(t, config)
+
+	p.keepAlivePass()
+	if closes := b.closeCount(); closes != 1 {
+		t.Fatalf("closes after the pass = %v, want 1", closes)
+	}
+	if dials := b.dialCount(); dials != 3 {
+		t.Fatalf("dials after the pass = %v, want 3", dials)
+	}
+	waitFor(t, "the replacement in the store", func() bool { return p.alivePool.Len() == 2 })
+	if retry := p.Stats().Retry; retry != 0 {
+		t.Fatalf("Stats.Retry after the replacement = %v, want 0", retry)
+	}
+	if err := p.CheckIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForRetryParksUntilRetryLoopRecovers(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("exhausted", 2, 0)
+	config.QueueTimeout = time.Millisecond
+	config.DialRetryInterval = time.Millisecond * 10
+	config.OnDialExhausted = WaitForRetry
+	p := newTestPool(t, config)
+	b.setDown(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if _, err := p.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("parked Get returned %v when its context ended, want DeadlineExceeded", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		connection, err := p.GetContext(context.Background())
+		if err == nil {
+			p.Put(connection)
+		}
+		result <- err
+	}()
+	dials := b.dialCount()
+	waitFor(t, "retry loop redialing", func() bool { return b.dialCount() > dials+int64(p.cfg().DialRetryCount) })
+	select {
+	case err := <-result:
+		t.Fatalf("Get returned %v while the backend is down, want it parked", err)
+	default:
+	}
+
+	b.setDown(false)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("parked Get returned %v after the backend recovered", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("parked Get was not served after the retry loop recovered")
+	}
+}
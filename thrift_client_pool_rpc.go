@@ -0,0 +1,83 @@
+package thrift_clientpool
+
+import (
+	"time"
+)
+
+// RPCStats aggregates the results reported with PutResult for one endpoint.
+type RPCStats struct {
+	Calls      int64
+	Errors     int64
+	Latency    time.Duration
+	MaxLatency time.Duration
+}
+
+func (s RPCStats) ErrorRate() float64 {
+
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Errors) / float64(s.Calls)
+}
+
+func (s RPCStats) AvgLatency() time.Duration {
+
+	if s.Calls == 0 {
+		return 0
+	}
+	return s.Latency / time.Duration(s.Calls)
+}
+
+// PutResult is PutErr also recording the RPC outcome and duration for the
+// endpoint of connection, see Stats.RPC.
+func (p *ThriftClientPool) PutResult(connection interface{}, rpcErr error, duration time.Duration) error {
+
+	if connection != nil {
+		p.connsSync.Lock()
+		conn, ok := p.conns[connection]
+		endpoint := ""
+		if ok {
+			endpoint = conn.endpoint
+		}
+		p.connsSync.Unlock()
+
+		if ok {
+			p.observeRPC(endpoint, rpcErr, duration)
+		}
+	}
+
+	return p.PutErr(connection, rpcErr)
+}
+
+func (p *ThriftClientPool) observeRPC(endpoint string, rpcErr error, duration time.Duration) {
+
+	p.rpcSync.Lock()
+	defer p.rpcSync.Unlock()
+
+	if p.rpcStats == nil {
+		p.rpcStats = map[string]RPCStats{}
+	}
+
+	s := p.rpcStats[endpoint]
+	s.Calls++
+	if rpcErr != nil {
+		s.Errors++
+	}
+	s.Latency += duration
+	if duration > s.MaxLatency {
+		s.MaxLatency = duration
+	}
+	p.rpcStats[endpoint] = s
+}
+
+func (p *ThriftClientPool) rpcSnapshot() map[string]RPCStats {
+
+	p.rpcSync.Lock()
+	defer p.rpcSync.Unlock()
+
+	snapshot := make(map[string]RPCStats, len(p.rpcStats))
+	for endpoint, s := range p.rpcStats {
+		snapshot[endpoint] = s
+	}
+	return snapshot
+}
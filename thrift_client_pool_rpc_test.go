@@ -0,0 +1,47 @@
+package thrift_clientpool
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPutResultAggregatesPerEndpoint(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("rpc", 2, 2))
+
+	for i, rpcErr := range []error{nil, errFakeDown, nil, nil} {
+		connection, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		p.PutResult(connection, rpcErr, time.Duration(i+1)*time.Millisecond)
+	}
+
+	rpc := p.Stats().RPC
+	if len(rpc) != 1 {
+		t.Fatalf("RPC stats for %v endpoints, want 1", len(rpc))
+	}
+	for endpoint, s := range rpc {
+		if s.Calls != 4 || s.Errors != 1 || s.MaxLatency != 4*time.Millisecond {
+			t.Fatalf("RPC stats of %v = %+v, want 4 calls, 1 error, max 4ms", endpoint, s)
+		}
+		if rate := s.ErrorRate(); rate != 0.25 {
+			t.Fatalf("ErrorRate = %v, want 0.25", rate)
+		}
+		if avg := s.AvgLatency(); avg != 2500*time.Microsecond {
+			t.Fatalf("AvgLatency = %v, want 2.5ms", avg)
+		}
+	}
+}
+
+func TestPutResultIgnoresForeignConnections(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("rpcforeign", 1, 1))
+
+	p.PutResult(&fakeConn{}, nil, time.Millisecond)
+	if rpc := p.Stats().RPC; len(rpc) != 0 {
+		t.Fatalf("RPC stats after a foreign PutResult = %v, want none", rpc)
+	}
+}
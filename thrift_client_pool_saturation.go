@@ -0,0 +1,82 @@
+package thrift_clientpool
+
+import "sync/atomic"
+
+// acquireWorkSlot counts a borrowed connection, OnSaturated fires once when
+// every slot of the pool is in use.
+func (p *ThriftClientPool) acquireWorkSlot() {
+
+	if int(atomic.AddInt32(&p.workConnCount, 1)) >= p.cfg().MaxPoolSize && atomic.CompareAndSwapInt32(&p.saturated, 0, 1) {
+		p.queueSaturation(true)
+	}
+}
+
+// releaseWorkSlot counts a returned connection, OnDesaturated fires once when
+// a saturated pool has a free slot again.
+func (p *ThriftClientPool) releaseWorkSlot() {
+
+	defer p.refreshHealth()
+	defer p.wakeAdmit()
+
+	working := int(atomic.AddInt32(&p.workConnCount, -1))
+	if working == 0 {
+		p.wakeWorkIdle()
+	}
+	if working < p.cfg().MaxPoolSize && atomic.CompareAndSwapInt32(&p.saturated, 1, 0) {
+		p.queueSaturation(false)
+	}
+}
+
+// queueSaturation records a transition for fireSaturation, the slots are often
+// counted with p.sync held so the hooks run once it is released. A caller
+// without p.sync fires them here.
+func (p *ThriftClientPool) queueSaturation(saturated bool) {
+
+	if config := p.cfg(); config.OnSaturated == nil && config.OnDesaturated == nil {
+		return
+	}
+
+	p.saturationSync.Lock()
+	p.saturations = append(p.saturations, saturated)
+	atomic.AddInt32(&p.saturationCount, 1)
+	p.saturationSync.Unlock()
+
+	if p.sync.TryLock() {
+		p.unlock()
+	}
+}
+
+// unlock releases p.sync and fires the saturation hooks queued meanwhile.
+func (p *ThriftClientPool) unlock() {
+
+	p.sync.Unlock()
+	p.fireSaturation()
+}
+
+// fireSaturation calls OnSaturated and OnDesaturated for the queued transitions
+// in order, one goroutine at a time. A hook calling back into the pool leaves
+// the transitions it causes to the running loop.
+func (p *ThriftClientPool) fireSaturation() {
+
+	for atomic.LoadInt32(&p.saturationCount) > 0 && p.saturationFire.TryLock() {
+		for {
+			p.saturationSync.Lock()
+			if len(p.saturations) == 0 {
+				p.saturationSync.Unlock()
+				break
+			}
+			saturated := p.saturations[0]
+			p.saturations = p.saturations[1:]
+			atomic.AddInt32(&p.saturationCount, -1)
+			p.saturationSync.Unlock()
+
+			config := p.cfg()
+			if saturated && config.OnSaturated != nil {
+				p.callHook("OnSaturated", config.OnSaturated)
+			} else if !saturated && config.OnDesaturated != nil {
+				p.callHook("OnDesaturated", config.OnDesaturated)
+			}
+		}
+		p.saturationFire.Unlock()
+	}
+}
@@ -0,0 +1,60 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSaturationHooksFireOncePerTransition(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("saturation", 2, 2)
+	saturated, desaturated := int32(0), int32(0)
+	config.OnSaturated = func() { atomic.AddInt32(&saturated, 1) }
+	config.OnDesaturated = func() { atomic.AddInt32(&desaturated, 1) }
+	p := newTestPool(t, config)
+
+	for round := 1; round <= 2; round++ {
+		first, _ := p.Get()
+		second, _ := p.Get()
+		if n := atomic.LoadInt32(&saturated); n != int32(round) {
+			t.Fatalf("OnSaturated fired %v times in round %v", n, round)
+		}
+
+		p.Put(first)
+		p.Put(second)
+		if n := atomic.LoadInt32(&desaturated); n != int32(round) {
+			t.Fatalf("OnDesaturated fired %v times in round %v", n, round)
+		}
+	}
+}
+
+func TestSaturationHooksMayReadPoolStats(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("saturation", 2, 2)
+	var p *ThriftClientPool
+	totals := make(chan int, 4)
+	config.OnSaturated = func() { totals <- p.TotalConns() }
+	config.OnDesaturated = func() { totals <- p.TotalConns() }
+	p = newTestPool(t, config)
+
+	done := make(chan struct{})
+	go func() {
+		first, _ := p.Get()
+		second, _ := p.Get()
+		p.Put(first)
+		p.CloseConn(second)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a saturation hook reading TotalConns deadlocked the pool")
+	}
+
+	if fired := len(totals); fired != 2 {
+		t.Fatalf("hooks fired %v times, want OnSaturated and OnDesaturated once", fired)
+	}
+}
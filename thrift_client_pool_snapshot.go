@@ -0,0 +1,53 @@
+package thrift_clientpool
+
+import "time"
+
+// ConnInfo describes a connection without exposing it, for admin tooling.
+// Pending retry slots are listed with state ConnRetrying and no ID.
+type ConnInfo struct {
+	ID            uint64
+	Endpoint      string
+	Age           time.Duration
+	IdleFor       time.Duration
+	UseCount      int64
+	State         ConnState
+	CorrelationID string
+}
+
+func (p *ThriftClientPool) SnapshotConnections() []ConnInfo {
+
+	p.sync.Lock()
+	defer p.unlock()
+
+	now := time.Now()
+	infos := []ConnInfo{}
+
+	p.connsSync.Lock()
+	for _, conn := range p.conns {
+		if conn.state == ConnClosed {
+			continue
+		}
+
+		info := ConnInfo{
+			ID:            conn.id,
+			Endpoint:      conn.endpoint,
+			Age:           now.Sub(conn.created),
+			UseCount:      conn.useCount,
+			State:         conn.state,
+			CorrelationID: conn.corrID,
+		}
+		if conn.state == ConnIdle {
+			info.IdleFor = now.Sub(conn.lastUsed)
+		}
+		infos = append(infos, info)
+	}
+	p.connsSync.Unlock()
+
+	p.retrySync.Lock()
+	for _, queued := range p.retryQueued {
+		infos = append(infos, ConnInfo{Endpoint: p.cfg().Address + ":" + p.cfg().Port, Age: now.Sub(queued), State: ConnRetrying})
+	}
+	p.retrySync.Unlock()
+
+	return infos
+}
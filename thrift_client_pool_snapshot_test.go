@@ -0,0 +1,33 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSnapshotConnectionsListsStates(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("snapshot", 3, 2))
+
+	connection, err := p.GetContext(WithCorrelationID(context.Background(), "req-7"))
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer p.Put(connection)
+	p.pushRetry()
+
+	states := map[ConnState]int{}
+	for _, info := range p.SnapshotConnections() {
+		states[info.State]++
+		if info.State == ConnInUse && (info.CorrelationID != "req-7" || info.UseCount != 1) {
+			t.Fatalf("borrowed connection info = %+v", info)
+		}
+		if info.State == ConnRetrying && info.ID != 0 {
+			t.Fatalf("retry slot listed with ID %v", info.ID)
+		}
+	}
+	if states[ConnInUse] != 1 || states[ConnIdle] != 1 || states[ConnRetrying] != 1 {
+		t.Fatalf("snapshot states = %v, want one in use, one idle and one retrying", states)
+	}
+}
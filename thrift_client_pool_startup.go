@@ -0,0 +1,30 @@
+package thrift_clientpool
+
+import (
+	"errors"
+	"fmt"
+)
+
+// WithStartupProbe makes the constructor dial and close one connection, and
+// fail when that dial fails, so a misconfigured endpoint is caught at boot.
+func WithStartupProbe() Option {
+	return func(p *ThriftClientPool) {
+		p.startupProbe = true
+	}
+}
+
+func (p *ThriftClientPool) probeStartup() error {
+
+	connection, err := p.callDial(p.dialAddress())
+	if err == nil && connection == nil {
+		err = ErrNilConnection
+	}
+	if err != nil {
+		return errors.New(fmt.Sprintf("startup probe of pool %v failed: %v", p.Name(), err))
+	}
+
+	if err = p.closeConnection(connection); err != nil {
+		p.logger().Warnf("Startup probe close error: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package thrift_clientpool
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStartupProbeFailsConstruction(t *testing.T) {
+
+	b := &fakeBackend{}
+	b.setDown(true)
+	if _, err := NewFromConfig(b.config("startupdown", 2, 0), WithStartupProbe()); err == nil {
+		t.Fatal("NewFromConfig with a failing startup probe succeeded")
+	}
+}
+
+func TestStartupProbeClosesItsConnection(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("startup", 2, 0), WithStartupProbe())
+
+	if dials, closes := b.dialCount(), b.closeCount(); dials != 1 || closes != 1 {
+		t.Fatalf("startup probe dialed %v and closed %v, want 1 each", dials, closes)
+	}
+	if total := p.TotalConns(); total != 0 {
+		t.Fatalf("TotalConns after the probe = %v, want 0", total)
+	}
+}
+
+func TestLazyStartDefersLoopsToFirstGet(t *testing.T) {
+
+	b := &fakeBackend{}
+	// released pools of earlier runs may still have loops winding down.
+	name := fmt.Sprintf("lazystart-%p", b)
+	config := b.config(name, 4, 2)
+	config.LazyStart = true
+	p := newTestPool(t, config)
+
+	if loops := loopGoroutines(name); len(loops) != 0 {
+		t.Fatalf("loops %v run before the first Get", loops)
+	}
+	if dials := b.dialCount(); dials != 0 {
+		t.Fatalf("%v dials before the first Get", dials)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if connection, err := p.Get(); err == nil {
+				p.Put(connection)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the loop goroutines carry their labels once they are scheduled.
+	waitFor(t, "the loops to start", func() bool {
+		loops := loopGoroutines(name)
+		return loops["retry"] > 0 && loops["keepAlive"] > 0
+	})
+	loops := loopGoroutines(name)
+	if loops["retry"] != 1 || loops["keepAlive"] != 1 || len(loops) != 2 {
+		t.Fatalf("loops after concurrent first Gets = %v, want one retry and one keepAlive", loops)
+	}
+	if dials := b.dialCount(); dials < 2 || dials > 4 {
+		t.Fatalf("%v dials, want the 2 initial ones warmed once and at most the pool size", dials)
+	}
+}
+
+func TestLoopGoroutinesCarryPprofLabels(t *testing.T) {
+
+	b := &fakeBackend{}
+	name := fmt.Sprintf("labels-%p", b)
+	config := b.config(name, 2, 1)
+	config.MaintenanceInterval = time.Hour
+	newTestPool(t, config, WithMetricsSink(time.Hour, func(Stats) {}))
+
+	want := map[string]int{"retry": 1, "keepAlive": 1, "maintenance": 1, "metrics": 1}
+	waitFor(t, "every loop to be labeled", func() bool { return reflect.DeepEqual(loopGoroutines(name), want) })
+}
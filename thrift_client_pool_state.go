@@ -0,0 +1,82 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+type PoolState int32
+
+const (
+	// StateRunning hands out and re-pools connections.
+	StateRunning PoolState = iota
+	// StateDraining rejects Gets and closes returned connections, see DrainPolicy,
+	// the loops keep running.
+	StateDraining
+	// StateClosing stops the loops and closes the idle connections.
+	StateClosing
+	// StateClosed is reached once Release closed the idle connections.
+	StateClosed
+)
+
+var ErrPoolNotRunning = errors.New("pool not running.")
+
+type DrainPolicy int
+
+const (
+	// DrainClose closes connections returned while the pool drains.
+	DrainClose DrainPolicy = iota
+	// DrainKeep keeps them open, GetFor of the caller that returned one can
+	// borrow it again until the pool is released. Connections borrowed with
+	// Get have no caller to reclaim them and are closed like with DrainClose.
+	DrainKeep
+)
+
+func (s PoolState) String() string {
+
+	switch s {
+	case StateRunning:
+		return "running"
+	case StateDraining:
+		return "draining"
+	case StateClosing:
+		return "closing"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+func (p *ThriftClientPool) State() PoolState {
+	return PoolState(atomic.LoadInt32(&p.state))
+}
+
+// Drain stops handing out connections and waits until the borrowed ones are
+// returned or ctx is done, Release then closes the pool.
+func (p *ThriftClientPool) Drain(ctx context.Context) error {
+
+	if !p.transition(StateDraining, StateRunning) {
+		return ErrPoolNotRunning
+	}
+	return p.WaitIdle(ctx)
+}
+
+// transition moves the pool from one of from to to, and reports whether it did.
+func (p *ThriftClientPool) transition(to PoolState, from ...PoolState) bool {
+
+	for _, state := range from {
+		if atomic.CompareAndSwapInt32(&p.state, int32(state), int32(to)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ThriftClientPool) serving() bool {
+	return p.State() == StateRunning
+}
+
+func (p *ThriftClientPool) stopped() bool {
+	return p.State() >= StateClosing
+}
@@ -0,0 +1,126 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDrainRejectsGetsAndClosesReturns(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("drain", 2, 2))
+
+	held, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := p.Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Drain with a borrowed connection returned %v, want DeadlineExceeded", err)
+	}
+	if state := p.State(); state != StateDraining {
+		t.Fatalf("State = %v, want draining", state)
+	}
+	if _, err := p.Get(); err != ErrPoolNotRunning {
+		t.Fatalf("Get while draining returned %v, want ErrPoolNotRunning", err)
+	}
+
+	p.Put(held)
+	if !held.(*fakeConn).isClosed() {
+		t.Fatal("connection returned while draining was kept")
+	}
+	if err := p.Drain(context.Background()); err != ErrPoolNotRunning {
+		t.Fatalf("second Drain returned %v, want ErrPoolNotRunning", err)
+	}
+}
+
+func TestStatesRejectGetPutAndStart(t *testing.T) {
+
+	for _, state := range []PoolState{StateDraining, StateClosing, StateClosed} {
+		t.Run(state.String(), func(t *testing.T) {
+
+			b := &fakeBackend{}
+			config := b.config("states", 2, 2)
+			// when closing, the first Close, of the idle connection on Release,
+			// hangs until unblocked so the pool stays closing.
+			hung := make(chan struct{})
+			var closes int32
+			var unblock sync.Once
+			config.Close = func(connection interface{}) error {
+				if state == StateClosing && atomic.AddInt32(&closes, 1) == 1 {
+					<-hung
+				}
+				return b.close(connection)
+			}
+			p, err := NewFromConfig(config)
+			if err != nil {
+				t.Fatalf("NewFromConfig: %v", err)
+			}
+			released := make(chan struct{})
+			t.Cleanup(func() {
+				unblock.Do(func() { close(hung) })
+				p.Release()
+			})
+
+			held, err := p.Get()
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			switch state {
+			case StateDraining:
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				p.Drain(ctx)
+			case StateClosing:
+				go func() {
+					p.Release()
+					close(released)
+				}()
+				waitFor(t, "Release to hang closing", func() bool { return atomic.LoadInt32(&closes) == 1 })
+			case StateClosed:
+				unblock.Do(func() { close(hung) })
+				p.Release()
+			}
+			if got := p.State(); got != state {
+				t.Fatalf("State = %v, want %v", got, state)
+			}
+
+			if _, err := p.Get(); err != ErrPoolNotRunning {
+				t.Fatalf("Get returned %v, want ErrPoolNotRunning", err)
+			}
+			p.Put(held)
+			if !held.(*fakeConn).isClosed() {
+				t.Fatal("returned connection was kept")
+			}
+			p.Start()
+			if atomic.LoadInt32(&p.started) != 0 {
+				t.Fatal("Start started the loops")
+			}
+
+			if state == StateClosing {
+				unblock.Do(func() { close(hung) })
+				<-released
+			}
+		})
+	}
+}
+
+func TestPoolStateString(t *testing.T) {
+
+	names := map[PoolState]string{
+		StateRunning:  "running",
+		StateDraining: "draining",
+		StateClosing:  "closing",
+		StateClosed:   "closed",
+		PoolState(42): "unknown",
+	}
+	for state, name := range names {
+		if got := state.String(); got != name {
+			t.Fatalf("PoolState(%d).String() = %q, want %q", state, got, name)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type Stats struct {
+	Name           string
+	MaxPoolSize    int
+	Working        int
+	Alive          int
+	Swap           int
+	Cooling        int
+	Retry          int
+	OldestIdle     time.Duration
+	StalestProbe   time.Duration
+	MaxGetWait     time.Duration
+	OldestRetry    time.Duration
+	RetryAttempts  int64
+	RetrySuccesses int64
+	RetryFailures  int64
+	LoopRestarts   int64
+	ForcedCloses   int64
+	Churn          int
+	DialSuccess    DialStats
+	DialFailure    DialStats
+	RPC            map[string]RPCStats
+}
+
+func (p *ThriftClientPool) Stats() Stats {
+
+	oldestIdle, stalestProbe := p.idleAges()
+
+	dialSuccess, dialFailure := p.dialSnapshot()
+	return Stats{
+		Name:           p.Name(),
+		MaxPoolSize:    p.cfg().MaxPoolSize,
+		Working:        int(atomic.LoadInt32(&p.workConnCount)),
+		Alive:          p.alivePool.Len(),
+		Swap:           len(p.swapPool()),
+		Cooling:        int(atomic.LoadInt32(&p.coolingCount)),
+		Retry:          len(p.retryPool()),
+		OldestIdle:     oldestIdle,
+		StalestProbe:   stalestProbe,
+		MaxGetWait:     time.Duration(atomic.LoadInt64(&p.maxGetWait)),
+		OldestRetry:    p.oldestRetry(),
+		RetryAttempts:  atomic.LoadInt64(&p.retryAttempts),
+		RetrySuccesses: atomic.LoadInt64(&p.retrySuccesses),
+		RetryFailures:  atomic.LoadInt64(&p.retryFailures),
+		LoopRestarts:   atomic.LoadInt64(&p.loopRestarts),
+		ForcedCloses:   atomic.LoadInt64(&p.forcedCloses),
+		Churn:          p.churnRate(),
+		DialSuccess:    dialSuccess,
+		DialFailure:    dialFailure,
+		RPC:            p.rpcSnapshot(),
+	}
+}
+
+// WithMetricsSink pushes a Stats snapshot to fn every interval until the pool is released.
+func WithMetricsSink(interval time.Duration, fn func(Stats)) Option {
+	return func(p *ThriftClientPool) {
+		p.update(func(c *Config) {
+			c.MetricsInterval = interval
+			c.MetricsSink = fn
+		})
+	}
+}
+
+func (p *ThriftClientPool) metricsLoop() {
+
+	for {
+		<-time.After(p.safeInterval("MetricsInterval", p.cfg().MetricsInterval))
+
+		if p.stopped() {
+			break
+		}
+
+		if sink := p.cfg().MetricsSink; sink != nil {
+			p.callHook("MetricsSink", func() { sink(p.Stats()) })
+		}
+	}
+}
+
+// ResetMaxGetWait starts a new observation window for Stats.MaxGetWait and
+// returns the maximum of the previous one.
+func (p *ThriftClientPool) ResetMaxGetWait() time.Duration {
+	return time.Duration(atomic.SwapInt64(&p.maxGetWait, 0))
+}
+
+func (p *ThriftClientPool) observeGetWait(start time.Time) {
+
+	wait := int64(time.Since(start))
+	for {
+		max := atomic.LoadInt64(&p.maxGetWait)
+		if wait <= max || atomic.CompareAndSwapInt64(&p.maxGetWait, max, wait) {
+			return
+		}
+	}
+}
+
+// idleAges reads the idle connections under p.connsSync, so no Conn escapes
+// and wrappers stay recyclable.
+func (p *ThriftClientPool) idleAges() (oldestIdle, stalestProbe time.Duration) {
+
+	p.connsSync.Lock()
+	defer p.connsSync.Unlock()
+
+	for _, conn := range p.conns {
+		if conn.state != ConnIdle {
+			continue
+		}
+		if idle := time.Since(conn.lastUsed); idle > oldestIdle {
+			oldestIdle = idle
+		}
+		if age := time.Since(conn.lastProbe); age > stalestProbe {
+			stalestProbe = age
+		}
+	}
+	return
+}
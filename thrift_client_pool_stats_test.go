@@ -0,0 +1,168 @@
+package thrift_clientpool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTotalConnsCountsEveryState(t *testing.T) {
+
+	backend := &fakeBackend{}
+	p := newTestPool(t, backend.config("total", 4, 4))
+
+	inUse, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cooling, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutCooldown(cooling, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	retired, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PutErr(retired, errFakeDown); err != nil {
+		t.Fatal(err)
+	}
+
+	if total := p.TotalConns(); total != 4 {
+		t.Fatalf("TotalConns = %v, want 4 with one in use, idle, cooling and retrying", total)
+	}
+	p.Put(inUse)
+}
+
+func TestTotalConnsDuringKeepAlivePass(t *testing.T) {
+
+	backend := &fakeBackend{}
+	release := make(chan struct{})
+	var probing int32
+	backend.keepAliveFn = func(c *fakeConn) error {
+		atomic.AddInt32(&probing, 1)
+		<-release
+		return nil
+	}
+
+	p := newTestPool(t, backend.config("total-pass", 3, 3))
+	passDone := make(chan struct{})
+	go func() {
+		p.keepAlivePass()
+		close(passDone)
+	}()
+	waitFor(t, "probes in flight", func() bool { return atomic.LoadInt32(&probing) == 3 })
+
+	if total := p.TotalConns(); total != 3 {
+		t.Fatalf("TotalConns while probing = %v, want 3", total)
+	}
+
+	close(release)
+	<-passDone
+	if total := p.TotalConns(); total != 3 {
+		t.Fatalf("TotalConns after pass = %v, want 3", total)
+	}
+}
+
+func TestMetricsSinkFollowsIntervalUntilRelease(t *testing.T) {
+
+	backend := &fakeBackend{}
+	interval := time.Millisecond * 20
+	calls := make(chan time.Time, 64)
+	p := newTestPool(t, backend.config("metrics", 2, 1), WithMetricsSink(interval, func(stats Stats) {
+		if stats.Name != "metrics" || stats.Alive != 1 {
+			t.Errorf("sink got stats of %v with %v idle connections, want metrics with 1", stats.Name, stats.Alive)
+		}
+		calls <- time.Now()
+	}))
+
+	last := time.Now()
+	for i := 0; i < 3; i++ {
+		select {
+		case at := <-calls:
+			if gap := at.Sub(last); gap < interval {
+				t.Fatalf("sink called %v after the previous call, interval is %v", gap, interval)
+			}
+			last = at
+		case <-time.After(time.Second):
+			t.Fatalf("sink was called %v times within a second, interval is %v", i, interval)
+		}
+	}
+
+	p.Release()
+	// a call started before Release may still land.
+	time.Sleep(interval * 2)
+	count := len(calls)
+	time.Sleep(interval * 5)
+	if got := len(calls); got != count {
+		t.Fatalf("sink was called %v times after Release", got-count)
+	}
+}
+
+func TestRetryCountersFollowTheRetryLoop(t *testing.T) {
+
+	b := &fakeBackend{}
+	script := []error{errFakeDown, nil, errFakeDown, nil, nil}
+	var dials int32
+	b.dialFn = func() error {
+		if i := int(atomic.AddInt32(&dials, 1)) - 1; i < len(script) {
+			return script[i]
+		}
+		return nil
+	}
+	config := b.config("retrystats", 3, 0)
+	config.DialRetryInterval = time.Millisecond * 5
+	p, err := NewFromConfig(config)
+	if err != nil {
+		t.Fatalf("NewFromConfig: %v", err)
+	}
+	// queued before the loop runs, so the first pass sees all three.
+	for i := 0; i < 3; i++ {
+		p.pushRetry()
+	}
+	p.Start()
+	t.Cleanup(p.Release)
+
+	// pass one fails, lands and fails, pass two lands both slots left.
+	waitFor(t, "the retry slots to be redialed", func() bool { return p.Stats().RetrySuccesses == 3 })
+	stats := p.Stats()
+	if stats.RetryAttempts != 5 || stats.RetrySuccesses != 3 || stats.RetryFailures != 2 {
+		t.Fatalf("retry counters = %v attempts, %v successes, %v failures, want 5, 3, 2",
+			stats.RetryAttempts, stats.RetrySuccesses, stats.RetryFailures)
+	}
+	if alive := p.alivePool.Len(); alive != 3 {
+		t.Fatalf("%v connections pooled by the retry loop, want 3", alive)
+	}
+}
+
+func TestStalestProbeAfterKeepAlivePass(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("stalest", 2, 2)
+	config.ProbeStaleOnly = true
+	p := newTestPool(t, config)
+
+	// the first connection is stale and probed, the second was used too recently.
+	ages := []time.Duration{time.Hour * 2, time.Minute * 30}
+	p.connsSync.Lock()
+	for _, conn := range p.conns {
+		age := ages[conn.id-1]
+		conn.lastUsed = conn.lastUsed.Add(-age)
+		conn.lastProbe = conn.lastProbe.Add(-age)
+	}
+	p.connsSync.Unlock()
+
+	if stalest := p.Stats().StalestProbe; stalest < time.Hour*2 {
+		t.Fatalf("StalestProbe before the pass = %v, want at least 2h", stalest)
+	}
+
+	p.keepAlivePass()
+	if probes := b.keepAliveCount(); probes != 1 {
+		t.Fatalf("probes = %v, want only the stale connection", probes)
+	}
+	if stalest := p.Stats().StalestProbe; stalest < time.Minute*30 || stalest > time.Minute*31 {
+		t.Fatalf("StalestProbe after the pass = %v, want the 30m of the skipped connection", stalest)
+	}
+}
@@ -0,0 +1,159 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type PoolStatus struct {
+	Healthy bool
+	State   PoolState
+}
+
+// Status reads the health cached by checkHealth, it neither locks nor
+// allocates so it can be called per request.
+func (p *ThriftClientPool) Status() PoolStatus {
+	return PoolStatus{Healthy: p.healthyAt(time.Now()), State: p.State()}
+}
+
+// checkHealth reports unhealthy only once the pool has been without any live
+// connection for longer than UnhealthyGracePeriod while its dials fail or after
+// it lost a connection to an error, a pool with no connections yet or whose
+// connections idled out stays healthy. It runs on every borrow, return, dial
+// and close so the cached value Status reads stays current, and only writes it
+// when the pool gains its first or loses its last live connection.
+func (p *ThriftClientPool) checkHealth(now time.Time) bool {
+
+	if p.servable() {
+		p.markServable()
+		return true
+	}
+
+	if atomic.CompareAndSwapInt64(&p.unservableSince, 0, now.UnixNano()) && p.servable() {
+		// a connection came back meanwhile, its refresh may have run before the swap.
+		p.markServable()
+		return true
+	}
+	return p.healthyAt(now)
+}
+
+// refreshHealth is checkHealth without the clock read while connections are live.
+func (p *ThriftClientPool) refreshHealth() {
+
+	if p.servable() {
+		p.markServable()
+		return
+	}
+	p.checkHealth(time.Now())
+}
+
+// servable reports whether any connection is borrowed or idle or nothing failed
+// since the last successful dial, reading the idle store last since its Len may
+// lock.
+func (p *ThriftClientPool) servable() bool {
+	return atomic.LoadInt32(&p.failing) == 0 || atomic.LoadInt32(&p.workConnCount) > 0 || len(p.swapPool()) > 0 || p.alivePool.Len() > 0
+}
+
+// noteDial marks the pool failing when a dial failed and clears it once one
+// succeeds.
+func (p *ThriftClientPool) noteDial(err error) {
+
+	if err != nil {
+		p.noteFailure()
+		return
+	}
+	if atomic.CompareAndSwapInt32(&p.failing, 1, 0) {
+		p.refreshHealth()
+	}
+}
+
+// noteFailure starts the unhealthy timer once the pool has no live connection
+// left, see checkHealth.
+func (p *ThriftClientPool) noteFailure() {
+
+	atomic.StoreInt32(&p.failing, 1)
+	p.refreshHealth()
+}
+
+func (p *ThriftClientPool) markServable() {
+
+	if atomic.LoadInt64(&p.unservableSince) != 0 {
+		atomic.StoreInt64(&p.unservableSince, 0)
+	}
+}
+
+func (p *ThriftClientPool) healthyAt(now time.Time) bool {
+
+	since := atomic.LoadInt64(&p.unservableSince)
+	return since == 0 || now.Sub(time.Unix(0, since)) < p.cfg().UnhealthyGracePeriod
+}
+
+// checkSwap calls OnSwapStuck once swapPool stayed non-empty for longer than a
+// keepalive cycle, connections are only parked there while a pass probes them.
+func (p *ThriftClientPool) checkSwap(now time.Time) {
+
+	p.healthSync.Lock()
+	defer p.healthSync.Unlock()
+
+	count := len(p.swapPool())
+	if count == 0 {
+		p.swapSince = time.Time{}
+		p.swapAlerted = false
+		return
+	}
+
+	if p.swapSince.IsZero() {
+		p.swapSince = now
+	}
+
+	config := p.cfg()
+	if stuck := now.Sub(p.swapSince); stuck > config.KeepAliveInterval && !p.swapAlerted {
+		p.swapAlerted = true
+		p.logger().Warnf("Swap pool of %v holds %v connections for %v.", config.Name, count, stuck)
+		if config.OnSwapStuck != nil {
+			p.callHook("OnSwapStuck", func() { config.OnSwapStuck(config.Name, count, stuck) })
+		}
+	}
+}
+
+// WaitIdle blocks until no connection is borrowed or ctx is done, it does not
+// stop new Gets.
+func (p *ThriftClientPool) WaitIdle(ctx context.Context) error {
+
+	for {
+		idle := p.workIdleReady()
+		if atomic.LoadInt32(&p.workConnCount) == 0 {
+			return nil
+		}
+
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// workIdleReady returns a channel closed by the next return of the last
+// borrowed connection.
+func (p *ThriftClientPool) workIdleReady() <-chan struct{} {
+
+	p.workSync.Lock()
+	defer p.workSync.Unlock()
+
+	if p.workIdle == nil {
+		p.workIdle = make(chan struct{})
+	}
+	return p.workIdle
+}
+
+func (p *ThriftClientPool) wakeWorkIdle() {
+
+	p.workSync.Lock()
+	if p.workIdle != nil {
+		close(p.workIdle)
+		p.workIdle = nil
+	}
+	p.workSync.Unlock()
+}
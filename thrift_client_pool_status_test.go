@@ -0,0 +1,290 @@
+package thrift_clientpool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatusFollowsLiveConnections(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("status", 1, 1))
+	if !p.Status().Healthy {
+		t.Fatalf("pool with an idle connection is unhealthy")
+	}
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if err := p.PutErr(connection, errors.New("rpc failed.")); err != nil {
+		t.Fatalf("PutErr: %v", err)
+	}
+	if p.Status().Healthy {
+		t.Fatalf("pool that lost its last connection is healthy past a zero grace period")
+	}
+
+	if !p.retryPass() {
+		t.Fatalf("retry pass failed")
+	}
+	if connection, err = p.Get(); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !p.Status().Healthy {
+		t.Fatalf("pool with a borrowed connection is unhealthy")
+	}
+	p.Put(connection)
+}
+
+func TestUnhealthyGracePeriodSmoothsShortOutages(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("grace", 1, 1)
+	config.UnhealthyGracePeriod = time.Minute
+	p := newTestPool(t, config)
+
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.PutErr(connection, errors.New("rpc failed."))
+	outage := time.Now()
+	if !p.Status().Healthy || !p.healthyAt(outage.Add(time.Second*30)) {
+		t.Fatalf("outage shorter than the grace period reported unhealthy")
+	}
+
+	// a new connection ends the outage and resets the timer.
+	if !p.retryPass() {
+		t.Fatalf("retry pass failed")
+	}
+	if connection, err = p.Get(); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if atomic.LoadInt64(&p.unservableSince) != 0 {
+		t.Fatalf("recovered pool kept its outage timer")
+	}
+	p.PutErr(connection, errors.New("rpc failed."))
+	outage = time.Now()
+	if p.healthyAt(outage.Add(time.Minute * 2)) {
+		t.Fatalf("outage longer than the grace period reported healthy")
+	}
+}
+
+func TestPoolWithoutFailuresStaysHealthy(t *testing.T) {
+
+	b := &fakeBackend{}
+	if p := newTestPool(t, b.config("empty", 2, 0)); !p.Status().Healthy {
+		t.Errorf("new pool without connections is unhealthy")
+	}
+
+	lazy := b.config("lazy", 2, 2)
+	lazy.LazyStart = true
+	if p := newTestPool(t, lazy); !p.Status().Healthy {
+		t.Errorf("lazy pool before its first Get is unhealthy")
+	}
+
+	idle := b.config("idle", 2, 1)
+	idle.MaxIdleTime = time.Millisecond
+	p := newTestPool(t, idle)
+	time.Sleep(time.Millisecond * 2)
+	p.maintenancePass()
+	if b.closeCount() != 1 || p.TotalConns() != 0 {
+		t.Fatalf("maintenance left %v connections after %v closes, want 0 after 1", p.TotalConns(), b.closeCount())
+	}
+	if !p.Status().Healthy {
+		t.Errorf("pool whose connections idled out is unhealthy")
+	}
+}
+
+func TestFailedDialsMakeEmptyPoolUnhealthy(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("failing", 1, 0))
+
+	b.setDown(true)
+	if _, err := p.Get(); err == nil {
+		t.Fatalf("Get against a down backend succeeded")
+	}
+	if p.Status().Healthy {
+		t.Fatalf("pool whose dials fail is healthy past a zero grace period")
+	}
+
+	b.setDown(false)
+	if !p.retryPass() {
+		t.Fatalf("retry pass failed")
+	}
+	connection, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	p.Put(connection)
+	if !p.Status().Healthy {
+		t.Fatalf("pool is unhealthy after a successful dial")
+	}
+}
+
+func TestStatusCacheMatchesPoolState(t *testing.T) {
+
+	cases := []struct {
+		name    string
+		initial int
+		lazy    bool
+		run     func(t *testing.T, p *ThriftClientPool, b *fakeBackend)
+		healthy bool
+	}{
+		{name: "empty", healthy: true},
+		{name: "lazy", initial: 1, lazy: true, healthy: true},
+		{name: "lazy after Get", initial: 1, lazy: true, healthy: true, run: func(t *testing.T, p *ThriftClientPool, b *fakeBackend) {
+			connection, err := p.Get()
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			p.Put(connection)
+		}},
+		{name: "idled out", initial: 1, healthy: true, run: func(t *testing.T, p *ThriftClientPool, b *fakeBackend) {
+			p.update(func(c *Config) { c.MaxIdleTime = time.Millisecond })
+			time.Sleep(time.Millisecond * 2)
+			p.maintenancePass()
+		}},
+		{name: "evicted", initial: 1, run: func(t *testing.T, p *ThriftClientPool, b *fakeBackend) {
+			connection, err := p.Get()
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			p.PutErr(connection, errors.New("rpc failed."))
+		}},
+		{name: "dial failed", run: func(t *testing.T, p *ThriftClientPool, b *fakeBackend) {
+			b.setDown(true)
+			p.Get()
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+
+			b := &fakeBackend{}
+			config := b.config("cache", 1, c.initial)
+			config.LazyStart = c.lazy
+			p := newTestPool(t, config)
+			if c.run != nil {
+				c.run(t, p, b)
+			}
+			if got := p.Status().Healthy; got != c.healthy {
+				t.Fatalf("Status().Healthy = %v, want %v", got, c.healthy)
+			}
+			if fresh := p.checkHealth(time.Now()); fresh != c.healthy {
+				t.Fatalf("checkHealth = %v, want %v like the cached Status", fresh, c.healthy)
+			}
+		})
+	}
+}
+
+func TestWaitIdleReturnsOnLastPut(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("waitidle", 2, 2))
+	first, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	second, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+	if err := p.WaitIdle(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("WaitIdle with borrowed connections returned %v, want DeadlineExceeded", err)
+	}
+
+	idle := make(chan error, 1)
+	go func() { idle <- p.WaitIdle(context.Background()) }()
+	p.Put(first)
+	select {
+	case err := <-idle:
+		t.Fatalf("WaitIdle returned %v with a connection still borrowed", err)
+	case <-time.After(time.Millisecond * 20):
+	}
+
+	// WaitIdle does not stop new Gets.
+	third, err := p.Get()
+	if err != nil {
+		t.Fatalf("Get while waiting for idle: %v", err)
+	}
+	p.Put(second)
+	p.Put(third)
+	select {
+	case err := <-idle:
+		if err != nil {
+			t.Fatalf("WaitIdle: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("WaitIdle did not return after the last Put")
+	}
+}
+
+func TestStatusDoesNotAllocate(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("status", 1, 1))
+	if allocs := testing.AllocsPerRun(100, func() { p.Status() }); allocs != 0 {
+		t.Fatalf("Status allocates %v times per call", allocs)
+	}
+}
+
+func BenchmarkStatus(b *testing.B) {
+
+	backend := &fakeBackend{}
+	p := newTestPool(b, backend.config("status", 1, 1))
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.Status()
+		}
+	})
+}
+
+func TestOnSwapStuckFiresOncePastAKeepAliveCycle(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("swapstuck", 2, 1)
+	var alerts []int
+	config.OnSwapStuck = func(tag string, count int, stuck time.Duration) { alerts = append(alerts, count) }
+	p := newTestPool(t, config)
+
+	// a stalled pass leaves the idle connection parked in swapPool.
+	connection, _ := p.popAlive()
+	p.swapQueue() <- connection
+	if swap := p.Stats().Swap; swap != 1 {
+		t.Fatalf("Stats.Swap = %v, want the stuck connection", swap)
+	}
+
+	start := time.Now()
+	p.checkSwap(start)
+	p.checkSwap(start.Add(config.KeepAliveInterval))
+	if len(alerts) != 0 {
+		t.Fatalf("OnSwapStuck fired within a keepalive cycle")
+	}
+	p.checkSwap(start.Add(config.KeepAliveInterval + time.Second))
+	p.checkSwap(start.Add(config.KeepAliveInterval + time.Second*2))
+	if len(alerts) != 1 || alerts[0] != 1 {
+		t.Fatalf("OnSwapStuck alerts = %v, want one for 1 connection", alerts)
+	}
+
+	// a drained swap pool arms the alert again.
+	p.pushAlive(<-p.swapPool())
+	restart := start.Add(config.KeepAliveInterval * 3)
+	p.checkSwap(restart)
+	connection, _ = p.popAlive()
+	p.swapQueue() <- connection
+	p.checkSwap(restart.Add(time.Second))
+	p.checkSwap(restart.Add(config.KeepAliveInterval + time.Second*2))
+	if len(alerts) != 2 {
+		t.Fatalf("OnSwapStuck alerts = %v after a second stall, want 2", alerts)
+	}
+	p.pushAlive(<-p.swapPool())
+}
@@ -0,0 +1,224 @@
+package thrift_clientpool
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// IdleStore holds the idle connections of a pool, implementations must be safe
+// for concurrent use. Pop never blocks.
+type IdleStore interface {
+	Push(connection interface{})
+	Pop() (connection interface{}, ok bool)
+	Len() int
+}
+
+// RestoringStore is an IdleStore that can put back connections taken out with
+// Pop, given in the order Pop returned them, as if they had never been taken
+// out. Keepalive passes and the other scans of the idle store use it to keep
+// the hand-out order, stores without it get the connections pushed in order.
+// Restore must not block, it returns the connections it has no room for and
+// the pool closes them.
+type RestoringStore interface {
+	IdleStore
+	Restore(connections []interface{}) (dropped []interface{})
+}
+
+// CloningStore is an IdleStore that can build an empty store of its own kind,
+// Clone uses it so the clone hands out connections in the same order. Clones
+// of other stores get the default channel backed one, sized to their pool.
+type CloningStore interface {
+	IdleStore
+	Empty() IdleStore
+}
+
+// WithIdleStore replaces the default channel backed idle store.
+func WithIdleStore(store IdleStore) Option {
+	return func(p *ThriftClientPool) {
+		p.alivePool = store
+	}
+}
+
+// WithLIFO hands out the most recently returned connection first, see NewStackStore.
+func WithLIFO() Option {
+	return WithIdleStore(NewStackStore())
+}
+
+type channelStore struct {
+	ch          chan interface{}
+	restoreSync sync.Mutex
+}
+
+// NewChannelStore returns the default FIFO store backed by a buffered channel.
+func NewChannelStore(capacity int) IdleStore {
+	return &channelStore{ch: make(chan interface{}, capacity)}
+}
+
+func (s *channelStore) Push(connection interface{}) {
+	s.ch <- connection
+}
+
+func (s *channelStore) Pop() (connection interface{}, ok bool) {
+
+	select {
+	case connection = <-s.ch:
+		return connection, true
+	default:
+		return nil, false
+	}
+}
+
+func (s *channelStore) Len() int {
+	return len(s.ch)
+}
+
+// Restore puts connections ahead of the ones pushed since they were popped,
+// what does not fit the channel any more is dropped, the newest first.
+func (s *channelStore) Restore(connections []interface{}) (dropped []interface{}) {
+
+	s.restoreSync.Lock()
+	defer s.restoreSync.Unlock()
+
+	newer := []interface{}{}
+	for connection, ok := s.Pop(); ok; connection, ok = s.Pop() {
+		newer = append(newer, connection)
+	}
+
+	for _, connection := range append(connections, newer...) {
+		select {
+		case s.ch <- connection:
+		default:
+			dropped = append(dropped, connection)
+		}
+	}
+	return
+}
+
+type stackStore struct {
+	sync        sync.Mutex
+	connections []interface{}
+}
+
+// NewStackStore returns a LIFO store, the most recently returned connection is handed out first.
+// Like every store here it keeps that order across keepalive passes.
+// With MaxIdleTime the connections left at the bottom idle out under low load.
+func NewStackStore() IdleStore {
+	return &stackStore{}
+}
+
+func (s *stackStore) Push(connection interface{}) {
+
+	s.sync.Lock()
+	s.connections = append(s.connections, connection)
+	s.sync.Unlock()
+}
+
+func (s *stackStore) Pop() (connection interface{}, ok bool) {
+
+	s.sync.Lock()
+	defer s.sync.Unlock()
+
+	if len(s.connections) == 0 {
+		return nil, false
+	}
+
+	connection = s.connections[len(s.connections)-1]
+	s.connections[len(s.connections)-1] = nil
+	s.connections = s.connections[:len(s.connections)-1]
+	return connection, true
+}
+
+func (s *stackStore) Len() int {
+
+	s.sync.Lock()
+	defer s.sync.Unlock()
+	return len(s.connections)
+}
+
+func (s *stackStore) Empty() IdleStore {
+	return NewStackStore()
+}
+
+// Restore puts connections below the ones pushed since they were popped.
+func (s *stackStore) Restore(connections []interface{}) (dropped []interface{}) {
+
+	s.sync.Lock()
+	defer s.sync.Unlock()
+
+	restored := make([]interface{}, 0, len(connections)+len(s.connections))
+	for i := len(connections) - 1; i >= 0; i-- {
+		restored = append(restored, connections[i])
+	}
+	s.connections = append(restored, s.connections...)
+	return nil
+}
+
+type connectionHeap struct {
+	connections []interface{}
+	less        func(a, b interface{}) bool
+}
+
+func (h *connectionHeap) Len() int           { return len(h.connections) }
+func (h *connectionHeap) Less(i, j int) bool { return h.less(h.connections[i], h.connections[j]) }
+func (h *connectionHeap) Swap(i, j int) {
+	h.connections[i], h.connections[j] = h.connections[j], h.connections[i]
+}
+func (h *connectionHeap) Push(x interface{}) { h.connections = append(h.connections, x) }
+func (h *connectionHeap) Pop() interface{} {
+	last := h.connections[len(h.connections)-1]
+	h.connections[len(h.connections)-1] = nil
+	h.connections = h.connections[:len(h.connections)-1]
+	return last
+}
+
+type heapStore struct {
+	sync sync.Mutex
+	heap connectionHeap
+}
+
+// NewHeapStore returns a store handing out first the connection ordered first by less,
+// e.g. the oldest one using Conn.CreatedAt.
+func NewHeapStore(less func(a, b interface{}) bool) IdleStore {
+	return &heapStore{heap: connectionHeap{less: less}}
+}
+
+func (s *heapStore) Push(connection interface{}) {
+
+	s.sync.Lock()
+	heap.Push(&s.heap, connection)
+	s.sync.Unlock()
+}
+
+func (s *heapStore) Pop() (connection interface{}, ok bool) {
+
+	s.sync.Lock()
+	defer s.sync.Unlock()
+
+	if s.heap.Len() == 0 {
+		return nil, false
+	}
+	return heap.Pop(&s.heap), true
+}
+
+func (s *heapStore) Len() int {
+
+	s.sync.Lock()
+	defer s.sync.Unlock()
+	return s.heap.Len()
+}
+
+// Empty shares less with s, a less reading Conn of one pool must not be cloned.
+func (s *heapStore) Empty() IdleStore {
+	return NewHeapStore(s.heap.less)
+}
+
+// Restore needs no care for order, less decides it.
+func (s *heapStore) Restore(connections []interface{}) (dropped []interface{}) {
+
+	s.sync.Lock()
+	for _, connection := range connections {
+		heap.Push(&s.heap, connection)
+	}
+	s.sync.Unlock()
+	return nil
+}
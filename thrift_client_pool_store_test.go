@@ -0,0 +1,103 @@
+package thrift_clientpool
+
+import (
+	"testing"
+	"time"
+)
+
+func popAll(store IdleStore) (popped []interface{}) {
+
+	for connection, ok := store.Pop(); ok; connection, ok = store.Pop() {
+		popped = append(popped, connection)
+	}
+	return
+}
+
+func TestStackStoreRestoreKeepsOrder(t *testing.T) {
+
+	store := NewStackStore()
+	for _, connection := range []string{"a", "b", "c"} {
+		store.Push(connection)
+	}
+	// a scan takes every connection out, "d" is returned meanwhile.
+	scanned := popAll(store)
+	store.Push("d")
+	store.(RestoringStore).Restore(scanned)
+
+	popped := popAll(store)
+	want := []interface{}{"d", "c", "b", "a"}
+	if len(popped) != len(want) {
+		t.Fatalf("popped %v, want %v", popped, want)
+	}
+	for i := range want {
+		if popped[i] != want[i] {
+			t.Fatalf("popped %v, want %v", popped, want)
+		}
+	}
+}
+
+func TestHeapStoreHandsOutByLess(t *testing.T) {
+
+	store := NewHeapStore(func(a, b interface{}) bool { return a.(int) < b.(int) })
+	for _, connection := range []int{3, 1, 2} {
+		store.Push(connection)
+	}
+	smallest, _ := store.Pop()
+	if smallest != 1 {
+		t.Fatalf("Pop = %v, want 1", smallest)
+	}
+	store.(RestoringStore).Restore([]interface{}{smallest})
+
+	empty := store.(CloningStore).Empty()
+	empty.Push(5)
+	empty.Push(4)
+	if connection, _ := empty.Pop(); connection != 4 {
+		t.Fatalf("Pop of the empty clone = %v, want 4", connection)
+	}
+
+	popped := popAll(store)
+	if len(popped) != 3 || popped[0] != 1 || popped[1] != 2 || popped[2] != 3 {
+		t.Fatalf("popped %v, want [1 2 3]", popped)
+	}
+}
+
+func TestWithLIFOHandsOutLatestReturn(t *testing.T) {
+
+	b := &fakeBackend{}
+	p := newTestPool(t, b.config("lifo", 2, 2), WithLIFO())
+
+	first, _ := p.Get()
+	second, _ := p.Get()
+	p.Put(first)
+	p.Put(second)
+
+	if connection, _ := p.Get(); connection != second {
+		t.Fatal("LIFO pool did not hand out the latest returned connection")
+	}
+}
+
+func TestLIFOLetsExcessConnectionsIdleOut(t *testing.T) {
+
+	b := &fakeBackend{}
+	config := b.config("lifoidle", 4, 4)
+	config.MaxIdleTime = time.Millisecond * 30
+	p := newTestPool(t, config, WithLIFO())
+
+	// one caller at a time keeps reusing the same hot connection.
+	for i := 0; i < 20; i++ {
+		connection, err := p.Get()
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		p.Put(connection)
+		time.Sleep(time.Millisecond * 5)
+	}
+	p.maintenancePass()
+
+	if closes := b.closeCount(); closes != 3 {
+		t.Fatalf("%v connections idled out under steady load of one, want 3", closes)
+	}
+	if total := p.TotalConns(); total != 1 {
+		t.Fatalf("TotalConns = %v, want the hot connection", total)
+	}
+}